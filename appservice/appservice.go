@@ -4,8 +4,13 @@ import (
 	"errors"
 	"shopping-cart-kata/cart"
 	"shopping-cart-kata/catalog"
+	"shopping-cart-kata/money"
 	"shopping-cart-kata/pricedcart"
 	"shopping-cart-kata/promotion"
+	"shopping-cart-kata/publisher"
+	"sort"
+	"strconv"
+	"time"
 )
 
 // ErrNotInitialized when there are problems with the dependencies AppService relies on
@@ -17,6 +22,9 @@ var ErrCartCreation = errors.New("Error on cart creation")
 // ErrCartNotFound when the cart is not present
 var ErrCartNotFound = errors.New("Unable to find the cart")
 
+// ErrCartExpired when the cart was not modified for longer than the cart TTL
+var ErrCartExpired = errors.New("The cart expired")
+
 // ErrArtNotFound when the article is not present
 var ErrArtNotFound = errors.New("Unable to find the article")
 
@@ -26,9 +34,46 @@ var ErrNonPositiveArtQty = errors.New("Article quantity must be positive")
 // ErrArtAlreadyAdded when the article is not present
 var ErrArtAlreadyAdded = errors.New("Article already in the cart")
 
+// ErrHistoryUnavailable when the cart store does not keep the history of the carts
+var ErrHistoryUnavailable = errors.New("Cart history not available")
+
+// ErrPricingNotRecorded when the prices or the promotion rules of a past moment were not recorded, e.g. before a restart
+var ErrPricingNotRecorded = errors.New("Prices and promotions not recorded at that moment")
+
 // ErrPromoRulesApplication when there is an error applying promotion rules
 var ErrPromoRulesApplication = errors.New("Error applying promotion rules")
 
+// ErrCartStore when the cart store cannot persist the changes
+var ErrCartStore = errors.New("Error persisting the cart")
+
+// ErrConcurrentModification when the cart keeps being modified concurrently and the update cannot be applied
+var ErrConcurrentModification = errors.New("Cart modified concurrently")
+
+// ErrPreconditionFailed when the cart does not satisfy the precondition of a change
+var ErrPreconditionFailed = errors.New("Cart precondition failed")
+
+// maxUpdateAttempts bounds the retries of a cart update conflicting with concurrent ones
+const maxUpdateAttempts = 10
+
+// PromoErrorPolicy tells how to handle promotion rules that cannot be applied
+type PromoErrorPolicy int
+
+const (
+	// FailOnPromoError makes GetCart fail with ErrPromoRulesApplication
+	FailOnPromoError PromoErrorPolicy = iota
+	// SkipFailingPromo skips the failing rules and marks the priced cart as degraded
+	SkipFailingPromo
+)
+
+// CartVersion identifies a state of a cart: every change increments the version
+type CartVersion struct {
+	Version    int64
+	ModifiedAt time.Time
+}
+
+// Precondition tells if a change can be applied to a cart in the given state
+type Precondition func(v CartVersion) bool
+
 // IDGenerator provides int64 IDs
 type IDGenerator interface {
 	NextID() int64
@@ -40,6 +85,12 @@ type AppService struct {
 	CartDB  cart.Store
 	Catalog catalog.Catalog
 	PromEng promotion.Engine
+	// PromoErrPolicy defaults to FailOnPromoError
+	PromoErrPolicy PromoErrorPolicy
+	// CartTTL is how long a cart lives after its last change (0 means forever)
+	CartTTL time.Duration
+	// Publisher receives an integration event for every cart change (nil disables publishing)
+	Publisher publisher.Publisher
 }
 
 // CreateCart creates a cart and return its ID
@@ -51,87 +102,317 @@ func (s AppService) CreateCart() (int64, error) {
 	if err != nil {
 		return 0, ErrCartCreation
 	}
-	s.CartDB.Save(c)
+	if err := s.CartDB.Save(c); err != nil {
+		return 0, ErrCartCreation
+	}
+	s.publish(publisher.Event{Type: publisher.CartCreated, CartID: c.GetID()})
 	return c.GetID(), nil
 }
 
 // AddArticleToCart adds an article to an existing cart
-func (s AppService) AddArticleToCart(cartID int64, artCod string, quantity int) error {
+// The preconditions are checked against the same cart state the change is applied to
+func (s AppService) AddArticleToCart(cartID int64, artCod string, quantity int, conds ...Precondition) error {
 	if s.isNotReady() {
 		return ErrNotInitialized
 	}
-	c := s.CartDB.Get(cartID)
-	if c == cart.DummyCart {
-		return ErrCartNotFound
-	}
-	a, ok := s.Catalog.GetArticle(artCod)
-	if !ok {
-		return ErrArtNotFound
-	}
-	err := c.AddArticle(a.Code, quantity)
-	if err == cart.ErrNonPositiveQuantity {
-		return ErrNonPositiveArtQty
-	}
-	if err == cart.ErrItemAlreadyExistent {
-		return ErrArtAlreadyAdded
-	}
-	s.CartDB.Save(c)
-	return nil
+	e := publisher.Event{Type: publisher.ArticleAdded, CartID: cartID, ArticleID: artCod, Quantity: quantity}
+	return s.updateCart(cartID, e, conds, func(c cart.Cart) error {
+		a, ok := s.Catalog.GetArticle(artCod)
+		if !ok {
+			return ErrArtNotFound
+		}
+		err := c.AddArticle(a.Code, quantity)
+		if err == cart.ErrNonPositiveQuantity {
+			return ErrNonPositiveArtQty
+		}
+		if err == cart.ErrItemAlreadyExistent {
+			return ErrArtAlreadyAdded
+		}
+		return err
+	})
 }
 
 // SetArticleQty changes the quantity of an existing article in an existing cart
-func (s AppService) SetArticleQty(cartID int64, artCod string, quantity int) error {
+// A zero quantity removes the article from the cart
+func (s AppService) SetArticleQty(cartID int64, artCod string, quantity int, conds ...Precondition) error {
 	if s.isNotReady() {
 		return ErrNotInitialized
 	}
-	c := s.CartDB.Get(cartID)
-	if c == cart.DummyCart {
-		return ErrCartNotFound
-	}
-	err := c.SetArticleQty(artCod, quantity)
-	if err == cart.ErrNonPositiveQuantity {
-		return ErrNonPositiveArtQty
+	if quantity == 0 {
+		return s.RemoveArticleFromCart(cartID, artCod, conds...)
 	}
-	if err == cart.ErrItemNotExistent {
-		return ErrArtNotFound
+	e := publisher.Event{Type: publisher.QuantityChanged, CartID: cartID, ArticleID: artCod, Quantity: quantity}
+	return s.updateCart(cartID, e, conds, func(c cart.Cart) error {
+		err := c.SetArticleQty(artCod, quantity)
+		if err == cart.ErrNonPositiveQuantity {
+			return ErrNonPositiveArtQty
+		}
+		if err == cart.ErrItemNotExistent {
+			return ErrArtNotFound
+		}
+		return err
+	})
+}
+
+// RemoveArticleFromCart removes an existing article from an existing cart
+func (s AppService) RemoveArticleFromCart(cartID int64, artCod string, conds ...Precondition) error {
+	if s.isNotReady() {
+		return ErrNotInitialized
 	}
-	s.CartDB.Save(c)
-	return nil
+	e := publisher.Event{Type: publisher.ArticleRemoved, CartID: cartID, ArticleID: artCod}
+	return s.updateCart(cartID, e, conds, func(c cart.Cart) error {
+		if err := c.RemoveArticle(artCod); err == cart.ErrItemNotExistent {
+			return ErrArtNotFound
+		}
+		return nil
+	})
 }
 
 // GetCart retrieves a priced cart with promotions applied
 func (s AppService) GetCart(id int64) (pricedcart.PricedCart, error) {
+	pc, _, err := s.GetVersionedCart(id)
+	return pc, err
+}
+
+// GetVersionedCart retrieves a priced cart with promotions applied and the state it was priced in
+func (s AppService) GetVersionedCart(id int64) (pricedcart.PricedCart, CartVersion, error) {
+	if s.isNotReady() {
+		return pricedcart.DummyPricedCart, CartVersion{}, ErrNotInitialized
+	}
+	c, version := s.CartDB.GetVersioned(id)
+	if err := s.checkLive(id, c); err != nil {
+		return pricedcart.DummyPricedCart, CartVersion{}, err
+	}
+	prices := s.Catalog.GetPrices(articleCodes(c))
+	promoSet, errs := s.PromEng.ApplyRules(c, prices)
+	pc, err := s.price(c, prices, promoSet, errs)
+	return pc, CartVersion{Version: version, ModifiedAt: c.GetModifiedAt()}, err
+}
+
+// GetCartVersion retrieves the current state of a cart without pricing it
+func (s AppService) GetCartVersion(id int64) (CartVersion, error) {
+	if s.isNotReady() {
+		return CartVersion{}, ErrNotInitialized
+	}
+	c, version := s.CartDB.GetVersioned(id)
+	if err := s.checkLive(id, c); err != nil {
+		return CartVersion{}, err
+	}
+	return CartVersion{Version: version, ModifiedAt: c.GetModifiedAt()}, nil
+}
+
+// GetCartAt retrieves the cart as it was at the given moment priced with the prices and promotions of that moment
+func (s AppService) GetCartAt(id int64, at time.Time) (pricedcart.PricedCart, error) {
 	if s.isNotReady() {
 		return pricedcart.DummyPricedCart, ErrNotInitialized
 	}
-	c := s.CartDB.Get(id)
+	hs, ok := s.CartDB.(cart.EventSourcedStore)
+	if !ok {
+		return pricedcart.DummyPricedCart, ErrHistoryUnavailable
+	}
+	c := hs.GetAt(id, at)
 	if c == cart.DummyCart {
 		return pricedcart.DummyPricedCart, ErrCartNotFound
 	}
-	items := c.GetItems()
-	itemIDs := make([]string, len(items))
-	for i, item := range c.GetItems() {
-		itemIDs[i] = item.ID
+	if at.Before(s.Catalog.RecordedSince()) || at.Before(s.PromEng.RecordedSince()) {
+		return pricedcart.DummyPricedCart, ErrPricingNotRecorded
 	}
-	prices := s.Catalog.GetPrices(itemIDs)
-	pc := pricedcart.NewPricedCart(c, prices)
-	promoSet, err := s.PromEng.ApplyRules(c, prices)
-	if err != nil {
-		return nil, ErrPromoRulesApplication
+	prices := s.Catalog.GetPricesAt(articleCodes(c), at)
+	promoSet, errs := s.PromEng.ApplyRulesAt(at, c, prices)
+	return s.price(c, prices, promoSet, errs)
+}
+
+// GetCartHistory retrieves the changes that led the cart to its state
+func (s AppService) GetCartHistory(id int64) ([]cart.Event, error) {
+	if s.isNotReady() {
+		return nil, ErrNotInitialized
+	}
+	hs, ok := s.CartDB.(cart.EventSourcedStore)
+	if !ok {
+		return nil, ErrHistoryUnavailable
+	}
+	events := hs.History(id)
+	if len(events) == 0 {
+		return nil, ErrCartNotFound
+	}
+	return events, nil
+}
+
+func (s AppService) price(c cart.Cart, prices map[string]money.Money, promoSet promotion.PromoSet, errs map[int64]error) (pricedcart.PricedCart, error) {
+	if len(errs) > 0 && s.PromoErrPolicy == FailOnPromoError {
+		return pricedcart.DummyPricedCart, ErrPromoRulesApplication
 	}
+	pc := pricedcart.NewPricedCart(c, prices)
 	pc.ApplyPromotions(promoSet)
+	if len(errs) > 0 {
+		pc.MarkDegraded(degradedReasons(errs)...)
+	}
 	return pc, nil
 }
 
-// DeleteCart deletes a cart
-func (s AppService) DeleteCart(id int64) error {
+func articleCodes(c cart.Cart) []string {
+	items := c.GetItems()
+	codes := make([]string, len(items))
+	for i, item := range items {
+		codes[i] = item.ID
+	}
+	return codes
+}
+
+// DeleteCart deletes a cart, retrying when a concurrent update saved it after the preconditions were checked
+func (s AppService) DeleteCart(id int64, conds ...Precondition) error {
 	if s.isNotReady() {
 		return ErrNotInitialized
 	}
-	s.CartDB.Delete(id)
+	for i := 0; i < maxUpdateAttempts; i++ {
+		c, version := s.CartDB.GetVersioned(id)
+		if !satisfied(conds, CartVersion{Version: version, ModifiedAt: c.GetModifiedAt()}) {
+			return ErrPreconditionFailed
+		}
+		err := s.CartDB.DeleteIfVersion(id, version)
+		if err == nil {
+			if c != cart.DummyCart {
+				s.publish(publisher.Event{Type: publisher.CartDeleted, CartID: id})
+			}
+			return nil
+		}
+		if err != cart.ErrVersionConflict {
+			return ErrCartStore
+		}
+	}
+	return ErrConcurrentModification
+}
+
+// ExpireCarts removes the carts not modified within the cart TTL returning their IDs
+func (s AppService) ExpireCarts() ([]int64, error) {
+	if s.isNotReady() {
+		return nil, ErrNotInitialized
+	}
+	if s.CartTTL <= 0 {
+		return nil, nil
+	}
+	ids, err := s.CartDB.Expire(time.Now().Add(-s.CartTTL))
+	for _, id := range ids {
+		s.publish(publisher.Event{Type: publisher.CartExpired, CartID: id})
+	}
+	if err != nil {
+		return ids, ErrCartStore
+	}
+	return ids, nil
+}
+
+// checkLive tells if the cart retrieved from the store is missing or expired, even if not yet removed
+func (s AppService) checkLive(id int64, c cart.Cart) error {
+	if c == cart.DummyCart && s.CartDB.Expired(id) {
+		return ErrCartExpired
+	}
+	if c == cart.DummyCart {
+		return ErrCartNotFound
+	}
+	if s.CartTTL > 0 && time.Since(c.GetModifiedAt()) > s.CartTTL {
+		return ErrCartExpired
+	}
 	return nil
 }
 
+// updateCart applies the change to the stored cart, retrying when a concurrent update saved it first
+// The event is published once the change is saved
+func (s AppService) updateCart(cartID int64, e publisher.Event, conds []Precondition, change func(c cart.Cart) error) error {
+	for i := 0; i < maxUpdateAttempts; i++ {
+		c, version := s.CartDB.GetVersioned(cartID)
+		if err := s.checkLive(cartID, c); err != nil {
+			return err
+		}
+		if !satisfied(conds, CartVersion{Version: version, ModifiedAt: c.GetModifiedAt()}) {
+			return ErrPreconditionFailed
+		}
+		trackPromos := s.Publisher != nil && s.Publisher.Wants(publisher.PromotionApplied)
+		var applied map[int64]promotion.RuleRef
+		if trackPromos {
+			applied = s.appliedRules(c)
+		}
+		if err := change(c); err != nil {
+			return err
+		}
+		err := s.CartDB.SaveIfVersion(c, version)
+		if err == nil {
+			s.publish(e)
+			if trackPromos {
+				s.publishNewPromotions(c, applied)
+			}
+			return nil
+		}
+		if err != cart.ErrVersionConflict {
+			return ErrCartStore
+		}
+	}
+	return ErrConcurrentModification
+}
+
+// publish hands the event to the publisher: the change is already saved so a publishing error does not fail it
+func (s AppService) publish(e publisher.Event) {
+	if s.Publisher != nil {
+		s.Publisher.Publish(e)
+	}
+}
+
+func satisfied(conds []Precondition, v CartVersion) bool {
+	for _, cond := range conds {
+		if !cond(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// appliedRules returns the promotion rules applying to the cart by ID
+func (s AppService) appliedRules(c cart.Cart) map[int64]promotion.RuleRef {
+	promoSet, _ := s.PromEng.ApplyRules(c, s.Catalog.GetPrices(articleCodes(c)))
+	refs := []promotion.RuleRef{promoSet.CartSubtotalDiscountRule, promoSet.ShippingDiscountRule}
+	refs = append(refs, promoSet.CartItemDiscountRules...)
+	refs = append(refs, promoSet.CartPresentRules...)
+	applied := make(map[int64]promotion.RuleRef)
+	for _, r := range refs {
+		if r.ID != 0 {
+			applied[r.ID] = r
+		}
+	}
+	return applied
+}
+
+// publishNewPromotions publishes the promotion rules applying to the cart after a change but not before
+// Rules without a code are identified by their ID
+func (s AppService) publishNewPromotions(c cart.Cart, before map[int64]promotion.RuleRef) {
+	after := s.appliedRules(c)
+	ids := make([]int64, 0, len(after))
+	for id := range after {
+		if _, ok := before[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		code := after[id].Code
+		if code == "" {
+			code = strconv.FormatInt(id, 10)
+		}
+		s.publish(publisher.Event{Type: publisher.PromotionApplied, CartID: c.GetID(), RuleCode: code})
+	}
+}
+
+func degradedReasons(errs map[int64]error) []string {
+	ids := make([]int64, 0, len(errs))
+	for id := range errs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	reasons := make([]string, len(ids))
+	for i, id := range ids {
+		reasons[i] = errs[id].Error()
+	}
+	return reasons
+}
+
 func (s AppService) isNotReady() bool {
 	if s.CartIDG == nil ||
 		s.CartDB == nil ||
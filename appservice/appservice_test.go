@@ -1,13 +1,36 @@
 package appservice
 
 import (
+	"fmt"
 	"shopping-cart-kata/cart"
 	"shopping-cart-kata/catalog"
+	"shopping-cart-kata/money"
 	"shopping-cart-kata/pricedcart"
 	"shopping-cart-kata/promotion"
+	"shopping-cart-kata/publisher"
+	"sync"
 	"testing"
+	"time"
 )
 
+type recordingPublisher struct {
+	events  []publisher.Event
+	ignored publisher.Type
+}
+
+func (p *recordingPublisher) Publish(e publisher.Event) error {
+	p.events = append(p.events, e)
+	return nil
+}
+
+func (p *recordingPublisher) Wants(t publisher.Type) bool {
+	return t != p.ignored
+}
+
+func (p *recordingPublisher) Close() error {
+	return nil
+}
+
 type generator struct {
 	id  int64
 	inc bool
@@ -55,8 +78,8 @@ func TestAddArticleToCart(t *testing.T) {
 		artQty       = 5
 		wrongArtQty1 = 0
 		wrongArtQty2 = -3
-		artUnPrice   = 20.0
 	)
+	artUnPrice := money.New(2000, "EUR")
 	s := appSvcWithoutPromEng(cartID)
 	id, _ := s.CreateCart()
 	if err := s.AddArticleToCart(wrongCartID, wrongArtCod, wrongArtQty1); err != ErrCartNotFound {
@@ -81,12 +104,202 @@ func TestAddArticleToCart(t *testing.T) {
 	pc, _ := s.GetCart(id)
 	c, _ := cart.NewCart(cartID)
 	c.AddArticle(artCod, artQty)
-	exp := pricedcart.NewPricedCart(c, map[string]float64{artCod: artUnPrice})
+	exp := pricedcart.NewPricedCart(c, map[string]money.Money{artCod: artUnPrice})
 	if notEqualPricedCarts(pc, exp) {
 		t.Errorf("Unexpected returned PricedCart:\n%v\ninstead of\n%v", pc, exp)
 	}
 }
 
+func TestRemoveArticleFromCart(t *testing.T) {
+	const cartID = 1
+	s := appSvcWithoutPromEng(cartID)
+	id, _ := s.CreateCart()
+	_ = s.AddArticleToCart(id, "TSHIRT", 2)
+	_ = s.AddArticleToCart(id, "MUG", 1)
+	_ = s.AddArticleToCart(id, "VOUCHER", 1)
+	if err := s.RemoveArticleFromCart(id+1, "TSHIRT"); err != ErrCartNotFound {
+		t.Errorf("Remove article from non existent cart: %v instead of %v", err, ErrCartNotFound)
+	}
+	if err := s.RemoveArticleFromCart(id, "TSHIRT"); err != nil {
+		t.Fatalf("Error removing article %v", err)
+	}
+	if err := s.RemoveArticleFromCart(id, "TSHIRT"); err != ErrArtNotFound {
+		t.Errorf("Remove article not in the cart: %v instead of %v", err, ErrArtNotFound)
+	}
+	if err := s.SetArticleQty(id, "MUG", 0); err != nil {
+		t.Fatalf("Error setting article quantity to zero %v", err)
+	}
+	pc, _ := s.GetCart(id)
+	if items := pc.GetItems(); len(items) != 1 || items[0].ID != "VOUCHER" {
+		t.Errorf("Cart items %v instead of only VOUCHER", items)
+	}
+}
+
+func TestExpiredCart(t *testing.T) {
+	const cartID = 1
+	s := appSvcWithoutPromEng(cartID)
+	s.CartTTL = time.Millisecond
+	id, _ := s.CreateCart()
+	if ids, _ := s.ExpireCarts(); len(ids) != 0 {
+		t.Fatalf("Carts %v expired before their TTL", ids)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := s.GetCart(id); err != ErrCartExpired {
+		t.Errorf("Get cart not yet removed after its TTL: %v instead of %v", err, ErrCartExpired)
+	}
+	if err := s.AddArticleToCart(id, "MUG", 1); err != ErrCartExpired {
+		t.Errorf("Add article to cart after its TTL: %v instead of %v", err, ErrCartExpired)
+	}
+	if ids, _ := s.ExpireCarts(); len(ids) != 1 || ids[0] != id {
+		t.Fatalf("Expired carts %v instead of %d", ids, id)
+	}
+	if _, err := s.GetCart(id); err != ErrCartExpired {
+		t.Errorf("Get removed expired cart: %v instead of %v", err, ErrCartExpired)
+	}
+	if _, err := s.GetCart(id + 1); err != ErrCartNotFound {
+		t.Errorf("Get never existed cart: %v instead of %v", err, ErrCartNotFound)
+	}
+}
+
+func TestGetCartAt(t *testing.T) {
+	const cartID = 1
+	s := appSvcWithoutPromEng(cartID)
+	if _, err := s.GetCartHistory(cartID); err != ErrHistoryUnavailable {
+		t.Errorf("History with state store: %v instead of %v", err, ErrHistoryUnavailable)
+	}
+	s.CartDB = cart.NewEventSourcedStore(cart.NewEventStore())
+	before := time.Now()
+	id, _ := s.CreateCart()
+	_ = s.AddArticleToCart(id, "MUG", 2)
+	at := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	s.Catalog.UpdatePrice("MUG", money.MustParse("8.00", "EUR"))
+	_ = s.SetArticleQty(id, "MUG", 3)
+
+	if _, err := s.GetCartAt(id, before.Add(-time.Second)); err != ErrCartNotFound {
+		t.Errorf("Get cart before its creation: %v instead of %v", err, ErrCartNotFound)
+	}
+	pc, err := s.GetCartAt(id, at)
+	if err != nil {
+		t.Fatalf("Error getting the cart in the past %v", err)
+	}
+	if subTot := pc.GetSubtotal(); subTot != money.MustParse("15.00", "EUR") {
+		t.Errorf("Past subtotal %s instead of 2 MUG at 7.50", subTot)
+	}
+	pc, _ = s.GetCart(id)
+	if subTot := pc.GetSubtotal(); subTot != money.MustParse("24.00", "EUR") {
+		t.Errorf("Current subtotal %s instead of 3 MUG at 8.00", subTot)
+	}
+	events, err := s.GetCartHistory(id)
+	if err != nil || len(events) != 3 || events[2].Type != cart.QuantityChanged {
+		t.Errorf("History %v, %v instead of creation, addition and quantity change", events, err)
+	}
+}
+
+func TestPreconditions(t *testing.T) {
+	const cartID = 1
+	s := appSvcWithoutPromEng(cartID)
+	id, _ := s.CreateCart()
+	v, err := s.GetCartVersion(id)
+	if err != nil || v.Version != 1 {
+		t.Fatalf("Version %v, %v instead of 1 after creation", v, err)
+	}
+	isVersion := func(exp int64) Precondition {
+		return func(v CartVersion) bool { return v.Version == exp }
+	}
+	if err := s.AddArticleToCart(id, "MUG", 1, isVersion(1)); err != nil {
+		t.Fatalf("Error adding article with a satisfied precondition %v", err)
+	}
+	if err := s.SetArticleQty(id, "MUG", 2, isVersion(1)); err != ErrPreconditionFailed {
+		t.Errorf("Set quantity with a stale version: %v instead of %v", err, ErrPreconditionFailed)
+	}
+	if err := s.DeleteCart(id, isVersion(1)); err != ErrPreconditionFailed {
+		t.Errorf("Delete with a stale version: %v instead of %v", err, ErrPreconditionFailed)
+	}
+	pc, v, _ := s.GetVersionedCart(id)
+	if v.Version != 2 || pc.GetQuantity() != 1 {
+		t.Errorf("Cart %v with version %d changed by failed preconditions", pc, v.Version)
+	}
+}
+
+func TestPublishEvents(t *testing.T) {
+	const cartID = 1
+	s := appSvcWithoutPromEng(cartID)
+	s.CartTTL = time.Hour
+	p := &recordingPublisher{}
+	s.Publisher = p
+	id, _ := s.CreateCart()
+	_ = s.AddArticleToCart(id, "MUG", 2)
+	_ = s.AddArticleToCart(id, "MUG", 2)
+	_ = s.SetArticleQty(id, "MUG", 3)
+	_ = s.AddArticleToCart(id, "TSHIRT", 1)
+	_ = s.SetArticleQty(id, "TSHIRT", 0)
+	_ = s.DeleteCart(id)
+	_ = s.DeleteCart(id)
+	exp := []publisher.Event{
+		{Type: publisher.CartCreated, CartID: id},
+		{Type: publisher.ArticleAdded, CartID: id, ArticleID: "MUG", Quantity: 2},
+		{Type: publisher.QuantityChanged, CartID: id, ArticleID: "MUG", Quantity: 3},
+		{Type: publisher.ArticleAdded, CartID: id, ArticleID: "TSHIRT", Quantity: 1},
+		{Type: publisher.ArticleRemoved, CartID: id, ArticleID: "TSHIRT"},
+		{Type: publisher.CartDeleted, CartID: id},
+	}
+	if len(p.events) != len(exp) {
+		t.Fatalf("Published events %v instead of %v", p.events, exp)
+	}
+	for i, e := range p.events {
+		if e != exp[i] {
+			t.Errorf("Published event %d %v instead of %v", i, e, exp[i])
+		}
+	}
+
+	p.events = nil
+	s.CartTTL = time.Millisecond
+	id, _ = s.CreateCart()
+	time.Sleep(5 * time.Millisecond)
+	_, _ = s.ExpireCarts()
+	if len(p.events) != 2 || p.events[1] != (publisher.Event{Type: publisher.CartExpired, CartID: id}) {
+		t.Errorf("Published events %v instead of the creation and expiration", p.events)
+	}
+}
+
+func TestPublishPromotionApplied(t *testing.T) {
+	const cartID = 1
+	s := appSvcWithPromEng(cartID)
+	p := &recordingPublisher{}
+	s.Publisher = p
+	id, _ := s.CreateCart()
+	_ = s.AddArticleToCart(id, "VOUCHER", 1)
+	_ = s.SetArticleQty(id, "VOUCHER", 2)
+	_ = s.SetArticleQty(id, "VOUCHER", 3)
+	var promos []publisher.Event
+	for _, e := range p.events {
+		if e.Type == publisher.PromotionApplied {
+			promos = append(promos, e)
+		}
+	}
+	if len(promos) != 1 || promos[0].RuleCode == "" || promos[0].CartID != id {
+		t.Errorf("Promotion applied events %v instead of one when the second voucher is added", promos)
+	}
+}
+
+func TestPromotionsNotTrackedIfNotWanted(t *testing.T) {
+	const cartID = 1
+	s := appSvcWithoutPromEng(cartID)
+	applied := 0
+	counting := func(c cart.Cart, prices map[string]money.Money) []interface{} {
+		applied++
+		return nil
+	}
+	s.PromEng.AddRule(&counting)
+	s.Publisher = &recordingPublisher{ignored: publisher.PromotionApplied}
+	id, _ := s.CreateCart()
+	_ = s.AddArticleToCart(id, "VOUCHER", 1)
+	if applied != 0 {
+		t.Errorf("Rules applied %d times to change a cart without promotion subscribers", applied)
+	}
+}
+
 func TestDeleteCart(t *testing.T) {
 	const cartID = 1
 	s := appSvcWithoutPromEng(cartID)
@@ -100,11 +313,44 @@ func TestDeleteCart(t *testing.T) {
 	}
 }
 
+// racingStore saves the cart again right before the first conditional delete, as a concurrent update would
+type racingStore struct {
+	cart.Store
+	raced bool
+}
+
+func (s *racingStore) DeleteIfVersion(id int64, version int64) error {
+	if !s.raced {
+		s.raced = true
+		s.Save(s.Get(id))
+	}
+	return s.Store.DeleteIfVersion(id, version)
+}
+
+func TestDeleteCartIsConditionalToTheDeletedVersion(t *testing.T) {
+	const cartID = 1
+	s := appSvcWithoutPromEng(cartID)
+	s.CartDB = &racingStore{Store: cart.NewStore()}
+	id, _ := s.CreateCart()
+	isVersion := func(exp int64) Precondition {
+		return func(v CartVersion) bool { return v.Version == exp }
+	}
+	if err := s.DeleteCart(id, isVersion(1)); err != ErrPreconditionFailed {
+		t.Errorf("Delete of a cart changed after the check: %v instead of %v", err, ErrPreconditionFailed)
+	}
+	if _, v, err := s.GetVersionedCart(id); err != nil || v.Version != 2 {
+		t.Errorf("Cart with version %d, %v instead of the concurrent change", v.Version, err)
+	}
+	if err := s.DeleteCart(id, isVersion(2)); err != nil {
+		t.Errorf("Error deleting the current version %v", err)
+	}
+}
+
 func TestVoucherTshirtMug(t *testing.T) {
 	const (
-		cartID    = 1
-		expSubTot = 32.5
+		cartID = 1
 	)
+	expSubTot := money.New(3250, "EUR")
 	s := appSvcWithPromEng(cartID)
 	id, _ := s.CreateCart()
 	_ = s.AddArticleToCart(cartID, "VOUCHER", 1)
@@ -112,15 +358,15 @@ func TestVoucherTshirtMug(t *testing.T) {
 	_ = s.AddArticleToCart(cartID, "MUG", 1)
 	pc, _ := s.GetCart(id)
 	if subTot := pc.GetSubtotal(); subTot != expSubTot {
-		t.Errorf("Subtotal for VOUCHER, TSHIRT, MUG %g insteaf of %g", subTot, expSubTot)
+		t.Errorf("Subtotal for VOUCHER, TSHIRT, MUG %s insteaf of %s", subTot, expSubTot)
 	}
 }
 
 func Test2VoucherTshirt(t *testing.T) {
 	const (
-		cartID    = 1
-		expSubTot = 25.0
+		cartID = 1
 	)
+	expSubTot := money.New(2500, "EUR")
 	s := appSvcWithPromEng(cartID)
 	id, _ := s.CreateCart()
 	_ = s.AddArticleToCart(cartID, "VOUCHER", 1)
@@ -128,15 +374,15 @@ func Test2VoucherTshirt(t *testing.T) {
 	_ = s.SetArticleQty(cartID, "VOUCHER", 2)
 	pc, _ := s.GetCart(id)
 	if subTot := pc.GetSubtotal(); subTot != expSubTot {
-		t.Errorf("Subtotal for 2 VOUCHER, TSHIRT %g insteaf of %g", subTot, expSubTot)
+		t.Errorf("Subtotal for 2 VOUCHER, TSHIRT %s insteaf of %s", subTot, expSubTot)
 	}
 }
 
 func TestVoucher4Tshirt(t *testing.T) {
 	const (
-		cartID    = 1
-		expSubTot = 81.0
+		cartID = 1
 	)
+	expSubTot := money.New(8100, "EUR")
 	s := appSvcWithPromEng(cartID)
 	id, _ := s.CreateCart()
 	_ = s.AddArticleToCart(cartID, "TSHIRT", 1)
@@ -146,15 +392,15 @@ func TestVoucher4Tshirt(t *testing.T) {
 	_ = s.SetArticleQty(cartID, "TSHIRT", 4)
 	pc, _ := s.GetCart(id)
 	if subTot := pc.GetSubtotal(); subTot != expSubTot {
-		t.Errorf("Subtotal for VOUCHER, 4 TSHIRT %g insteaf of %g", subTot, expSubTot)
+		t.Errorf("Subtotal for VOUCHER, 4 TSHIRT %s insteaf of %s", subTot, expSubTot)
 	}
 }
 
 func Test3Voucher3TshirtMug(t *testing.T) {
 	const (
-		cartID    = 1
-		expSubTot = 74.5
+		cartID = 1
 	)
+	expSubTot := money.New(7450, "EUR")
 	s := appSvcWithPromEng(cartID)
 	id, _ := s.CreateCart()
 	_ = s.AddArticleToCart(cartID, "VOUCHER", 1)
@@ -166,7 +412,107 @@ func Test3Voucher3TshirtMug(t *testing.T) {
 	_ = s.SetArticleQty(cartID, "TSHIRT", 3)
 	pc, _ := s.GetCart(id)
 	if subTot := pc.GetSubtotal(); subTot != expSubTot {
-		t.Errorf("Subtotal for 3 VOUCHER, 3 TSHIRT, MUG %g insteaf of %g", subTot, expSubTot)
+		t.Errorf("Subtotal for 3 VOUCHER, 3 TSHIRT, MUG %s insteaf of %s", subTot, expSubTot)
+	}
+}
+
+func TestFailingPromoRulePolicies(t *testing.T) {
+	const cartID = 1
+	s := appSvcWithPromEng(cartID)
+	broken := func(c cart.Cart, prices map[string]money.Money) []interface{} {
+		return []interface{}{nil}
+	}
+	s.PromEng.AddRule(&broken)
+	id, _ := s.CreateCart()
+	_ = s.AddArticleToCart(cartID, "VOUCHER", 2)
+	if _, err := s.GetCart(id); err != ErrPromoRulesApplication {
+		t.Errorf("Get cart with failing rule: %v instead of %v", err, ErrPromoRulesApplication)
+	}
+	s.PromoErrPolicy = SkipFailingPromo
+	pc, err := s.GetCart(id)
+	if err != nil {
+		t.Fatalf("Error getting the cart %v", err)
+	}
+	if reasons := pc.GetDegradedReasons(); len(reasons) != 1 {
+		t.Errorf("Degraded reasons %v instead of the failing rule", reasons)
+	}
+	if subTot := pc.GetSubtotal(); subTot != money.New(500, "EUR") {
+		t.Errorf("Subtotal for 2 VOUCHER %s instead of 5.00 EUR", subTot)
+	}
+}
+
+func TestPromoInOtherCurrencyFollowsPolicy(t *testing.T) {
+	const cartID = 1
+	s := appSvcWithPromEng(cartID)
+	usdOff := func(c cart.Cart, prices map[string]money.Money) []interface{} {
+		return []interface{}{promotion.CartSubtotalDiscount{Discount: promotion.Discount{Mode: promotion.Amount, Value: money.New(100, "USD")}}}
+	}
+	s.PromEng.AddRule(&usdOff)
+	id, _ := s.CreateCart()
+	_ = s.AddArticleToCart(cartID, "MUG", 1)
+	if _, err := s.GetCart(id); err != ErrPromoRulesApplication {
+		t.Errorf("Get cart with a USD discount: %v instead of %v", err, ErrPromoRulesApplication)
+	}
+	s.PromoErrPolicy = SkipFailingPromo
+	pc, err := s.GetCart(id)
+	if err != nil {
+		t.Fatalf("Error getting the cart %v", err)
+	}
+	if reasons := pc.GetDegradedReasons(); len(reasons) != 1 {
+		t.Errorf("Degraded reasons %v instead of the USD rule", reasons)
+	}
+}
+
+func TestConcurrentAddArticleToCart(t *testing.T) {
+	stores := map[string]cart.Store{
+		"state":  cart.NewStore(),
+		"events": cart.NewEventSourcedStore(cart.NewEventStore()),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) { concurrentAddArticleToCart(t, store) })
+	}
+}
+
+func concurrentAddArticleToCart(t *testing.T, store cart.Store) {
+	const (
+		cartID   = 1
+		articles = 50
+	)
+	s := appSvcWithoutPromEng(cartID)
+	s.CartDB = store
+	for i := 0; i < articles; i++ {
+		s.Catalog.AddArticle(catalog.Article{Code: fmt.Sprintf("ART%02d", i), Price: money.MustParse("1.00", "EUR")})
+	}
+	id, _ := s.CreateCart()
+	var wg sync.WaitGroup
+	errs := make([]error, articles)
+	for i := 0; i < articles; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.AddArticleToCart(id, fmt.Sprintf("ART%02d", i), 1)
+		}(i)
+	}
+	wg.Wait()
+	added := make(map[string]bool)
+	for i, err := range errs {
+		switch err {
+		case nil:
+			added[fmt.Sprintf("ART%02d", i)] = true
+		case ErrConcurrentModification:
+		default:
+			t.Errorf("Error %v adding article %d", err, i)
+		}
+	}
+	c := s.CartDB.Get(id)
+	items := c.GetItems()
+	if len(items) != len(added) {
+		t.Errorf("%d items in the cart instead of the %d successfully added", len(items), len(added))
+	}
+	for _, item := range items {
+		if !added[item.ID] {
+			t.Errorf("Article %s in the cart but not successfully added", item.ID)
+		}
 	}
 }
 
@@ -190,9 +536,9 @@ func appSvcWithPromEng(cartID int64) AppService {
 
 func defaultCatalog() catalog.Catalog {
 	c := catalog.NewCatalog()
-	c.AddArticle(catalog.Article{Code: "VOUCHER", Name: "CompanyName Voucher", Price: 5.0})
-	c.AddArticle(catalog.Article{Code: "TSHIRT", Name: "CompanyName T-Shirt", Price: 20.0})
-	c.AddArticle(catalog.Article{Code: "MUG", Name: "CompanyName Coffee Mug", Price: 7.5})
+	c.AddArticle(catalog.Article{Code: "VOUCHER", Name: "CompanyName Voucher", Price: money.MustParse("5.00", "EUR")})
+	c.AddArticle(catalog.Article{Code: "TSHIRT", Name: "CompanyName T-Shirt", Price: money.MustParse("20.00", "EUR")})
+	c.AddArticle(catalog.Article{Code: "MUG", Name: "CompanyName Coffee Mug", Price: money.MustParse("7.50", "EUR")})
 	return c
 }
 
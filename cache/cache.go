@@ -1,9 +1,10 @@
 package cache
 
+import "sync/atomic"
+
 // Etagger represents a type that has etag
 type Etagger interface {
 	GetEtag() string
-	ComputeEtag()
 }
 
 // Cache represents the cache for cart resources
@@ -11,4 +12,41 @@ type Cache interface {
 	GetByEtagWithID(etag string, wid string) (Etagger, bool)
 	AddOrReplace(wid string, e Etagger)
 	Remove(wid string)
+	Clear()
+	Stats() Stats
+}
+
+// Stats are the counters of a cache
+type Stats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+	Entries     int    `json:"entries"`
+}
+
+// counters are updated atomically so that lookups can share a read lock
+type counters struct {
+	hits        uint64
+	misses      uint64
+	evictions   uint64
+	expirations uint64
+}
+
+func (c *counters) hit(ok bool) {
+	if ok {
+		atomic.AddUint64(&c.hits, 1)
+		return
+	}
+	atomic.AddUint64(&c.misses, 1)
+}
+
+func (c *counters) stats(entries int) Stats {
+	return Stats{
+		Hits:        atomic.LoadUint64(&c.hits),
+		Misses:      atomic.LoadUint64(&c.misses),
+		Evictions:   atomic.LoadUint64(&c.evictions),
+		Expirations: atomic.LoadUint64(&c.expirations),
+		Entries:     entries,
+	}
 }
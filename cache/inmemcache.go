@@ -2,9 +2,10 @@ package cache
 
 import "sync"
 
-// InMemCache is an in memory cache
+// InMemCache is an in memory cache without size bound
 type InMemCache struct {
 	sync.RWMutex
+	counters
 	entriesByID    map[string]Etagger
 	entryIdsByEtag map[string]string
 }
@@ -24,13 +25,12 @@ func (c *InMemCache) GetByEtagWithID(etag string, wid string) (Etagger, bool) {
 	id, ok := c.entryIdsByEtag[etag]
 	var e Etagger
 	if !ok || id != wid {
+		c.hit(false)
 		return e, false
 	}
 	e, ok = c.entriesByID[id]
-	if !ok {
-		return e, false
-	}
-	return e, true
+	c.hit(ok)
+	return e, ok
 }
 
 // AddOrReplace adds or replaces an entry
@@ -49,9 +49,24 @@ func (c *InMemCache) Remove(wid string) {
 	remove(c, wid)
 }
 
+// Clear removes all entries
+func (c *InMemCache) Clear() {
+	c.Lock()
+	defer c.Unlock()
+	c.entriesByID = make(map[string]Etagger)
+	c.entryIdsByEtag = make(map[string]string)
+}
+
+// Stats returns the counters of the cache
+func (c *InMemCache) Stats() Stats {
+	c.RLock()
+	defer c.RUnlock()
+	return c.stats(len(c.entriesByID))
+}
+
 func remove(c *InMemCache, wid string) {
 	e, ok := c.entriesByID[wid]
-	if ok {
+	if ok && c.entryIdsByEtag[e.GetEtag()] == wid {
 		delete(c.entryIdsByEtag, e.GetEtag())
 	}
 	delete(c.entriesByID, wid)
@@ -69,6 +69,40 @@ func TestRemove(t *testing.T) {
 	}
 }
 
+func TestClear(t *testing.T) {
+	const (
+		id1   = "myID1"
+		id2   = "myID2"
+		value = "myValue"
+	)
+	e1 := &etagger{ID: id1, Value: value}
+	e1.ComputeEtag()
+	e2 := &etagger{ID: id2, Value: value}
+	e2.ComputeEtag()
+	c := NewCache()
+	c.AddOrReplace(id1, e1)
+	c.AddOrReplace(id2, e2)
+	c.Clear()
+	if _, ok := c.GetByEtagWithID(e1.etag, id1); ok {
+		t.Errorf("Cache hit on cleared entry %v", e1)
+	}
+	if _, ok := c.GetByEtagWithID(e2.etag, id2); ok {
+		t.Errorf("Cache hit on cleared entry %v", e2)
+	}
+}
+
+func TestRemoveKeepsOtherEntryWithSameEtag(t *testing.T) {
+	e1 := &etagger{etag: `"1-0a1b2c3d"`}
+	e2 := &etagger{etag: e1.etag}
+	c := NewCache()
+	c.AddOrReplace("id1", e1)
+	c.AddOrReplace("id2", e2)
+	c.Remove("id1")
+	if _, ok := c.GetByEtagWithID(e2.etag, "id2"); !ok {
+		t.Errorf("Removing an entry dropped another one with the same ETag")
+	}
+}
+
 type etagger struct {
 	ID    string
 	Value string
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var now = time.Now
+
+// LRUCache is an in memory cache holding at most capacity entries, evicting the least recently used
+// With a TTL entries expire that long after being added
+type LRUCache struct {
+	sync.Mutex
+	counters
+	capacity       int
+	ttl            time.Duration
+	order          *list.List
+	entriesByID    map[string]*list.Element
+	entryIdsByEtag map[string]string
+}
+
+type lruEntry struct {
+	wid       string
+	e         Etagger
+	expiresAt time.Time
+}
+
+// NewLRUCache creates a cache bounded to capacity entries (at least 1) whose entries expire after ttl (0 means never)
+func NewLRUCache(capacity int, ttl time.Duration) Cache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	c := &LRUCache{capacity: capacity, ttl: ttl}
+	c.reset()
+	return c
+}
+
+// GetByEtagWithID get an entry by etags if its id matches the one provided and it is not expired
+func (c *LRUCache) GetByEtagWithID(etag string, wid string) (Etagger, bool) {
+	c.Lock()
+	defer c.Unlock()
+	var e Etagger
+	id, ok := c.entryIdsByEtag[etag]
+	if !ok || id != wid {
+		c.hit(false)
+		return e, false
+	}
+	el := c.entriesByID[id]
+	entry := el.Value.(*lruEntry)
+	if c.ttl > 0 && !now().Before(entry.expiresAt) {
+		c.removeElement(el)
+		atomic.AddUint64(&c.expirations, 1)
+		c.hit(false)
+		return e, false
+	}
+	c.order.MoveToFront(el)
+	c.hit(true)
+	return entry.e, true
+}
+
+// AddOrReplace adds or replaces an entry evicting the least recently used when the cache is full
+func (c *LRUCache) AddOrReplace(wid string, e Etagger) {
+	c.Lock()
+	defer c.Unlock()
+	if el, ok := c.entriesByID[wid]; ok {
+		c.removeElement(el)
+	}
+	for c.order.Len() >= c.capacity {
+		c.removeElement(c.order.Back())
+		atomic.AddUint64(&c.evictions, 1)
+	}
+	entry := &lruEntry{wid: wid, e: e}
+	if c.ttl > 0 {
+		entry.expiresAt = now().Add(c.ttl)
+	}
+	c.entriesByID[wid] = c.order.PushFront(entry)
+	c.entryIdsByEtag[e.GetEtag()] = wid
+}
+
+// Remove removes an entry
+func (c *LRUCache) Remove(wid string) {
+	c.Lock()
+	defer c.Unlock()
+	if el, ok := c.entriesByID[wid]; ok {
+		c.removeElement(el)
+	}
+}
+
+// Clear removes all entries
+func (c *LRUCache) Clear() {
+	c.Lock()
+	defer c.Unlock()
+	c.reset()
+}
+
+// Stats returns the counters of the cache
+func (c *LRUCache) Stats() Stats {
+	c.Lock()
+	defer c.Unlock()
+	return c.stats(c.order.Len())
+}
+
+func (c *LRUCache) reset() {
+	c.order = list.New()
+	c.entriesByID = make(map[string]*list.Element)
+	c.entryIdsByEtag = make(map[string]string)
+}
+
+func (c *LRUCache) removeElement(el *list.Element) {
+	entry := c.order.Remove(el).(*lruEntry)
+	delete(c.entriesByID, entry.wid)
+	if c.entryIdsByEtag[entry.e.GetEtag()] == entry.wid {
+		delete(c.entryIdsByEtag, entry.e.GetEtag())
+	}
+}
@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func newEtagger(id string, value string) *etagger {
+	e := &etagger{ID: id, Value: value}
+	e.ComputeEtag()
+	return e
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRUCache(2, 0)
+	e1, e2, e3 := newEtagger("id1", "v"), newEtagger("id2", "v"), newEtagger("id3", "v")
+	c.AddOrReplace("id1", e1)
+	c.AddOrReplace("id2", e2)
+	c.GetByEtagWithID(e1.etag, "id1")
+	c.AddOrReplace("id3", e3)
+	if _, ok := c.GetByEtagWithID(e2.etag, "id2"); ok {
+		t.Errorf("Least recently used entry %v not evicted", e2)
+	}
+	if _, ok := c.GetByEtagWithID(e1.etag, "id1"); !ok {
+		t.Errorf("Recently used entry %v evicted", e1)
+	}
+	if _, ok := c.GetByEtagWithID(e3.etag, "id3"); !ok {
+		t.Errorf("Added entry %v evicted", e3)
+	}
+	exp := Stats{Hits: 3, Misses: 1, Evictions: 1, Entries: 2}
+	if s := c.Stats(); s != exp {
+		t.Errorf("Stats %+v instead of %+v", s, exp)
+	}
+}
+
+func TestLRUReplaceDoesNotEvict(t *testing.T) {
+	c := NewLRUCache(2, 0)
+	e1, e2 := newEtagger("id1", "v1"), newEtagger("id2", "v")
+	c.AddOrReplace("id1", e1)
+	c.AddOrReplace("id2", e2)
+	e1bis := newEtagger("id1", "v2")
+	c.AddOrReplace("id1", e1bis)
+	if _, ok := c.GetByEtagWithID(e1.etag, "id1"); ok {
+		t.Errorf("Cache hit on replaced entry %v", e1)
+	}
+	if _, ok := c.GetByEtagWithID(e2.etag, "id2"); !ok {
+		t.Errorf("Entry %v evicted by a replacement", e2)
+	}
+	if s := c.Stats(); s.Evictions != 0 || s.Entries != 2 {
+		t.Errorf("Stats %+v after a replacement", s)
+	}
+}
+
+func TestLRUExpiration(t *testing.T) {
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	c := NewLRUCache(10, time.Minute)
+	e := newEtagger("id", "v")
+	c.AddOrReplace("id", e)
+	clock = clock.Add(59 * time.Second)
+	if _, ok := c.GetByEtagWithID(e.etag, "id"); !ok {
+		t.Errorf("Entry %v expired before its TTL", e)
+	}
+	clock = clock.Add(time.Second)
+	if _, ok := c.GetByEtagWithID(e.etag, "id"); ok {
+		t.Errorf("Cache hit on entry %v after its TTL", e)
+	}
+	if s := c.Stats(); s.Expirations != 1 || s.Entries != 0 {
+		t.Errorf("Stats %+v after an expiration", s)
+	}
+}
+
+func TestInMemCacheStats(t *testing.T) {
+	c := NewCache()
+	e := newEtagger("id", "v")
+	c.GetByEtagWithID(e.etag, "id")
+	c.AddOrReplace("id", e)
+	c.GetByEtagWithID(e.etag, "id")
+	c.GetByEtagWithID(e.etag, "other")
+	exp := Stats{Hits: 1, Misses: 2, Entries: 1}
+	if s := c.Stats(); s != exp {
+		t.Errorf("Stats %+v instead of %+v", s, exp)
+	}
+}
+
+// benchmarkCache mixes lookups (90%) and additions over more keys than the bounded caches hold
+func benchmarkCache(b *testing.B, c Cache) {
+	const keys = 2048
+	entries := make([]*etagger, keys)
+	for i := range entries {
+		entries[i] = newEtagger(fmt.Sprintf("id%d", i), "v")
+		c.AddOrReplace(entries[i].ID, entries[i])
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			e := entries[r.Intn(keys)]
+			if r.Intn(10) == 0 {
+				c.AddOrReplace(e.ID, e)
+				continue
+			}
+			if _, ok := c.GetByEtagWithID(e.etag, e.ID); !ok {
+				c.AddOrReplace(e.ID, e)
+			}
+		}
+	})
+}
+
+func BenchmarkInMemCache(b *testing.B) {
+	benchmarkCache(b, NewCache())
+}
+
+func BenchmarkLRUCache(b *testing.B) {
+	benchmarkCache(b, NewLRUCache(4096, 0))
+}
+
+func BenchmarkLRUCacheEvicting(b *testing.B) {
+	benchmarkCache(b, NewLRUCache(1024, 0))
+}
+
+func BenchmarkShardedInMemCache(b *testing.B) {
+	benchmarkCache(b, NewShardedCache(64, NewCache))
+}
+
+func BenchmarkShardedLRUCache(b *testing.B) {
+	benchmarkCache(b, NewShardedCache(64, func() Cache { return NewLRUCache(64, 0) }))
+}
@@ -0,0 +1,62 @@
+package cache
+
+import "hash/fnv"
+
+type shardedCache struct {
+	shards []Cache
+}
+
+// NewShardedCache creates a cache split into shards created by newShard, each with its own lock, hashed by cart id
+// Bounded shards hold each their capacity so the whole cache holds shards times as much
+func NewShardedCache(shards int, newShard func() Cache) Cache {
+	if shards < 1 {
+		shards = 1
+	}
+	c := &shardedCache{shards: make([]Cache, shards)}
+	for i := range c.shards {
+		c.shards[i] = newShard()
+	}
+	return c
+}
+
+// GetByEtagWithID get an entry by etags if its id matches the one provided
+func (c *shardedCache) GetByEtagWithID(etag string, wid string) (Etagger, bool) {
+	return c.shard(wid).GetByEtagWithID(etag, wid)
+}
+
+// AddOrReplace adds or replaces an entry
+func (c *shardedCache) AddOrReplace(wid string, e Etagger) {
+	c.shard(wid).AddOrReplace(wid, e)
+}
+
+// Remove removes an entry
+func (c *shardedCache) Remove(wid string) {
+	c.shard(wid).Remove(wid)
+}
+
+// Clear removes all entries, one shard at a time
+func (c *shardedCache) Clear() {
+	for _, s := range c.shards {
+		s.Clear()
+	}
+}
+
+// Stats returns the sum of the counters of the shards
+func (c *shardedCache) Stats() Stats {
+	var total Stats
+	for _, s := range c.shards {
+		st := s.Stats()
+		total.Hits += st.Hits
+		total.Misses += st.Misses
+		total.Evictions += st.Evictions
+		total.Expirations += st.Expirations
+		total.Entries += st.Entries
+	}
+	return total
+}
+
+func (c *shardedCache) shard(wid string) Cache {
+	h := fnv.New32a()
+	h.Write([]byte(wid))
+	return c.shards[h.Sum32()%uint32(len(c.shards))]
+}
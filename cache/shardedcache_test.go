@@ -0,0 +1,32 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShardedCache(t *testing.T) {
+	c := NewShardedCache(4, NewCache)
+	entries := make([]*etagger, 20)
+	for i := range entries {
+		entries[i] = newEtagger(fmt.Sprintf("id%d", i), "v")
+		c.AddOrReplace(entries[i].ID, entries[i])
+	}
+	for _, e := range entries {
+		if _, ok := c.GetByEtagWithID(e.etag, e.ID); !ok {
+			t.Errorf("Cache miss on stored entry %v", e)
+		}
+	}
+	c.Remove(entries[0].ID)
+	if _, ok := c.GetByEtagWithID(entries[0].etag, entries[0].ID); ok {
+		t.Errorf("Cache hit on removed entry %v", entries[0])
+	}
+	exp := Stats{Hits: 20, Misses: 1, Entries: 19}
+	if s := c.Stats(); s != exp {
+		t.Errorf("Stats %+v instead of %+v", s, exp)
+	}
+	c.Clear()
+	if s := c.Stats(); s.Entries != 0 {
+		t.Errorf("%d entries left after clearing", s.Entries)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"time"
 )
 
 // ErrNonPositiveID when the cart ID is zero or negative
@@ -25,15 +26,24 @@ type Cart interface {
 	GetItems() []Item
 	AddArticle(id string, quantity int) error
 	SetArticleQty(id string, quantity int) error
+	RemoveArticle(id string) error
+	GetCreatedAt() time.Time
+	GetModifiedAt() time.Time
 }
 
 type cart struct {
-	nItems   int64
-	id       int64
-	quantity int
-	items    map[string]*Item
+	nItems     int64
+	id         int64
+	quantity   int
+	items      map[string]*Item
+	createdAt  time.Time
+	modifiedAt time.Time
+	changes    []Event
 }
 
+// now is the clock stamping cart changes
+var now = time.Now
+
 // DummyCart is the implementation of the null object pattern
 var DummyCart Cart = new(cart)
 
@@ -45,6 +55,7 @@ func NewCart(id int64) (Cart, error) {
 	c := new(cart)
 	c.id = id
 	c.items = make(map[string]*Item)
+	c.raise(Event{Type: CartCreated})
 	return c, nil
 }
 
@@ -56,9 +67,33 @@ func fromCart(c Cart) Cart {
 	for _, i := range c.GetItems() {
 		res.AddArticle(i.ID, i.Quantity)
 	}
+	stamp(res, c.GetCreatedAt(), c.GetModifiedAt())
 	return res
 }
 
+// stamp sets the timestamps of a cart built by this package forgetting the changes that built it
+func stamp(c Cart, createdAt, modifiedAt time.Time) {
+	if res, ok := c.(*cart); ok {
+		res.createdAt, res.modifiedAt = createdAt, modifiedAt
+		res.changes = nil
+	}
+}
+
+// changes returns the events raised by the cart since it was created or rebuilt
+func changes(c Cart) []Event {
+	if res, ok := c.(*cart); ok {
+		return res.changes
+	}
+	return nil
+}
+
+// commit forgets the events raised by the cart once they are stored
+func commit(c Cart) {
+	if res, ok := c.(*cart); ok {
+		res.changes = nil
+	}
+}
+
 func (c *cart) GetID() int64 {
 	return c.id
 }
@@ -68,6 +103,16 @@ func (c *cart) GetQuantity() int {
 	return c.quantity
 }
 
+// GetCreatedAt returns when the cart was created
+func (c *cart) GetCreatedAt() time.Time {
+	return c.createdAt
+}
+
+// GetModifiedAt returns when the cart items were last changed
+func (c *cart) GetModifiedAt() time.Time {
+	return c.modifiedAt
+}
+
 // GetItems returns the cart items sorted by insertion order
 func (c *cart) GetItems() []Item {
 	items := make([]Item, len(c.items))
@@ -88,9 +133,7 @@ func (c *cart) AddArticle(id string, quantity int) error {
 	if _, ok := c.items[id]; ok {
 		return ErrItemAlreadyExistent
 	}
-	c.nItems++
-	c.items[id] = &Item{insID: c.nItems, ID: id, Quantity: quantity}
-	c.quantity += quantity
+	c.raise(Event{Type: ArticleAdded, ArticleID: id, Quantity: quantity})
 	return nil
 }
 
@@ -98,15 +141,53 @@ func (c *cart) SetArticleQty(id string, quantity int) error {
 	if quantity <= 0 {
 		return ErrNonPositiveQuantity
 	}
-	item, ok := c.items[id]
-	if !ok {
+	if _, ok := c.items[id]; !ok {
+		return ErrItemNotExistent
+	}
+	c.raise(Event{Type: QuantityChanged, ArticleID: id, Quantity: quantity})
+	return nil
+}
+
+// RemoveArticle removes the article from the cart
+func (c *cart) RemoveArticle(id string) error {
+	if _, ok := c.items[id]; !ok {
 		return ErrItemNotExistent
 	}
-	c.quantity = c.quantity - item.Quantity + quantity
-	item.Quantity = quantity
+	c.raise(Event{Type: ArticleRemoved, ArticleID: id})
 	return nil
 }
 
+// raise applies a new event to the cart and records it among the changes
+func (c *cart) raise(e Event) {
+	e.CartID = c.id
+	e.At = now()
+	c.apply(e)
+	c.changes = append(c.changes, e)
+}
+
+// apply changes the cart state according to an already validated event
+func (c *cart) apply(e Event) {
+	switch e.Type {
+	case CartCreated:
+		c.createdAt = e.At
+	case ArticleAdded:
+		c.nItems++
+		c.items[e.ArticleID] = &Item{insID: c.nItems, ID: e.ArticleID, Quantity: e.Quantity}
+		c.quantity += e.Quantity
+	case QuantityChanged:
+		if item, ok := c.items[e.ArticleID]; ok {
+			c.quantity = c.quantity - item.Quantity + e.Quantity
+			item.Quantity = e.Quantity
+		}
+	case ArticleRemoved:
+		if item, ok := c.items[e.ArticleID]; ok {
+			c.quantity -= item.Quantity
+			delete(c.items, e.ArticleID)
+		}
+	}
+	c.modifiedAt = e.At
+}
+
 func (c *cart) String() string {
 	return fmt.Sprintf(`{ "id": %d, "quantity": %d, "items": %v}`, c.GetID(), c.GetQuantity(), c.GetItems())
 }
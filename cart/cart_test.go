@@ -1,6 +1,9 @@
 package cart
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestNewCartIsEmpty(t *testing.T) {
 	cart, _ := NewCart(1)
@@ -121,6 +124,53 @@ func TestChangeArticleQuantity(t *testing.T) {
 	}
 }
 
+func TestRemoveArticle(t *testing.T) {
+	const (
+		cartID  = 1
+		artID1  = "article1"
+		artID2  = "article2"
+		artQty1 = 2
+		artQty2 = 3
+	)
+	cart, _ := NewCart(cartID)
+	cart.AddArticle(artID1, artQty1)
+	cart.AddArticle(artID2, artQty2)
+	if err := cart.RemoveArticle(artID1); err != nil {
+		t.Fatalf("Error removing article %v", err)
+	}
+	if cartQty := cart.GetQuantity(); cartQty != artQty2 {
+		t.Errorf("Cart quantity is %d instead of %d", cartQty, artQty2)
+	}
+	if items := cart.GetItems(); len(items) != 1 || items[0].ID != artID2 {
+		t.Errorf("Cart items %v instead of only %s", items, artID2)
+	}
+	if err := cart.RemoveArticle(artID1); err != ErrItemNotExistent {
+		t.Errorf("Remove non existent item: %v instead of %v", err, ErrItemNotExistent)
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := created
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	cart, _ := NewCart(1)
+	for i, change := range []func() error{
+		func() error { return cart.AddArticle("article", 1) },
+		func() error { return cart.SetArticleQty("article", 2) },
+		func() error { return cart.RemoveArticle("article") },
+	} {
+		clock = clock.Add(time.Minute)
+		change()
+		if c, m := cart.GetCreatedAt(), cart.GetModifiedAt(); !c.Equal(created) || !m.Equal(clock) {
+			t.Errorf("Change %d: created at %v and modified at %v instead of %v and %v", i, c, m, created, clock)
+		}
+	}
+	if copied := fromCart(cart); !copied.GetModifiedAt().Equal(clock) {
+		t.Errorf("Copied cart modified at %v instead of %v", copied.GetModifiedAt(), clock)
+	}
+}
+
 func TestAddAlreadyExistentItem(t *testing.T) {
 	const (
 		cartID = 1
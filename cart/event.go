@@ -0,0 +1,66 @@
+package cart
+
+import "time"
+
+// EventType is the kind of a cart domain event
+type EventType string
+
+const (
+	// CartCreated when a cart is created
+	CartCreated EventType = "CartCreated"
+	// ArticleAdded when an article is added with its quantity
+	ArticleAdded EventType = "ArticleAdded"
+	// QuantityChanged when the quantity of an article in the cart is changed
+	QuantityChanged EventType = "QuantityChanged"
+	// ArticleRemoved when an article is removed from the cart
+	ArticleRemoved EventType = "ArticleRemoved"
+	// CartDeleted when the cart is deleted
+	CartDeleted EventType = "CartDeleted"
+	// CartExpired when the cart is removed because it was not modified within its TTL
+	CartExpired EventType = "CartExpired"
+)
+
+// Event is a change that happened to a cart
+// Version is the position of the event in the cart stream, starting from 1
+type Event struct {
+	CartID    int64     `json:"cartId"`
+	Version   int64     `json:"version"`
+	Type      EventType `json:"type"`
+	ArticleID string    `json:"articleId,omitempty"`
+	Quantity  int       `json:"quantity,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// replay rebuilds a cart from its events: the cart is DummyCart if it never existed or it was removed
+func replay(events []Event) Cart {
+	if len(events) == 0 || events[0].Type != CartCreated {
+		return DummyCart
+	}
+	c := new(cart)
+	c.id = events[0].CartID
+	c.items = make(map[string]*Item)
+	return replayOnto(c, events)
+}
+
+// replayOnto applies the events following its state to the cart: DummyCart if one of them removes it
+func replayOnto(c *cart, events []Event) Cart {
+	for _, e := range events {
+		if e.Type == CartDeleted || e.Type == CartExpired {
+			return DummyCart
+		}
+		c.apply(e)
+	}
+	return c
+}
+
+// clone copies the state of the cart forgetting its changes
+func (c *cart) clone() *cart {
+	res := *c
+	res.items = make(map[string]*Item, len(c.items))
+	for id, i := range c.items {
+		item := *i
+		res.items[id] = &item
+	}
+	res.changes = nil
+	return &res
+}
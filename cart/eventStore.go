@@ -0,0 +1,137 @@
+package cart
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EventStore persists the event streams of the carts
+type EventStore interface {
+	// Append adds the events to the cart stream if it has expectedVersion events, numbering them
+	Append(cartID int64, expectedVersion int64, events []Event) error
+	// Load returns the events of the cart stream in order
+	Load(cartID int64) []Event
+	// LoadFrom returns the events of the cart stream following the given version in order
+	LoadFrom(cartID int64, version int64) []Event
+	// CartIDs returns the IDs of the carts having a stream
+	CartIDs() []int64
+}
+
+// FileEventStore is an EventStore persisting the events into a log file
+type FileEventStore interface {
+	EventStore
+	Close() error
+}
+
+type eventStore struct {
+	sync.RWMutex
+	streams map[int64][]Event
+	log     *wal
+}
+
+// NewEventStore creates an in memory event store
+func NewEventStore() EventStore {
+	return &eventStore{streams: make(map[int64][]Event)}
+}
+
+// NewFileEventStore creates an event store appending the events to the log at path, loading the ones already there
+// Every append is a single log record so a batch torn by a crash at the end of the log is discarded as a whole
+func NewFileEventStore(path string, policy SyncPolicy, syncInterval time.Duration) (FileEventStore, error) {
+	s := &eventStore{streams: make(map[int64][]Event)}
+	l, err := openWAL(path, policy, syncInterval, s.replay)
+	if err != nil {
+		return nil, err
+	}
+	s.log = l
+	return s, nil
+}
+
+// Append adds the events to the cart stream if it has expectedVersion events, numbering them
+// The events are all appended or none is
+func (s *eventStore) Append(cartID int64, expectedVersion int64, events []Event) error {
+	s.Lock()
+	defer s.Unlock()
+	stream := s.streams[cartID]
+	if int64(len(stream)) != expectedVersion {
+		return ErrVersionConflict
+	}
+	batch := make([]Event, len(events))
+	for i, e := range events {
+		e.CartID = cartID
+		e.Version = expectedVersion + int64(i) + 1
+		batch[i] = e
+	}
+	if s.log != nil && len(batch) > 0 {
+		if err := s.log.append(batch); err != nil {
+			return err
+		}
+	}
+	s.streams[cartID] = append(stream, batch...)
+	return nil
+}
+
+// Load returns the events of the cart stream in order
+func (s *eventStore) Load(cartID int64) []Event {
+	return s.LoadFrom(cartID, 0)
+}
+
+// LoadFrom returns the events of the cart stream following the given version in order
+func (s *eventStore) LoadFrom(cartID int64, version int64) []Event {
+	s.RLock()
+	defer s.RUnlock()
+	stream := s.streams[cartID]
+	if version < 0 {
+		version = 0
+	}
+	if version > int64(len(stream)) {
+		version = int64(len(stream))
+	}
+	events := make([]Event, len(stream)-int(version))
+	copy(events, stream[version:])
+	return events
+}
+
+// CartIDs returns the IDs of the carts having a stream
+func (s *eventStore) CartIDs() []int64 {
+	s.RLock()
+	defer s.RUnlock()
+	ids := make([]int64, 0, len(s.streams))
+	for id := range s.streams {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Close flushes and closes the log
+func (s *eventStore) Close() error {
+	if s.log == nil {
+		return nil
+	}
+	return s.log.close()
+}
+
+// replay loads a log record: a batch of events or a single event as written by older versions
+func (s *eventStore) replay(j []byte) error {
+	var batch []Event
+	var err error
+	if len(j) > 0 && j[0] == '[' {
+		err = json.Unmarshal(j, &batch)
+	} else {
+		batch = make([]Event, 1)
+		err = json.Unmarshal(j, &batch[0])
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %v", ErrCorruptedLog, err)
+	}
+	for _, e := range batch {
+		if e.Version != int64(len(s.streams[e.CartID]))+1 {
+			return fmt.Errorf("%v: event %d of cart %d out of sequence", ErrCorruptedLog, e.Version, e.CartID)
+		}
+		s.streams[e.CartID] = append(s.streams[e.CartID], e)
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+package cart
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	walFile      = "carts.wal"
+	snapshotFile = "carts.snapshot"
+)
+
+// FileStoreOptions configures a file store
+type FileStoreOptions struct {
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+	// SnapshotEvery is the number of log records after which a snapshot replaces the log (0 disables snapshots)
+	SnapshotEvery int
+	// SnapshotFailed is told why a snapshot due after a change failed: the change is stored anyway
+	// and the snapshot is attempted again after the next one
+	SnapshotFailed func(err error)
+}
+
+// FileStore is a Store persisting carts into a directory
+type FileStore interface {
+	Store
+	Snapshot() error
+	Close() error
+}
+
+type itemRecord struct {
+	ID       string `json:"id"`
+	Quantity int    `json:"quantity"`
+}
+
+// record holds the whole cart state so that replaying records already in the snapshot is harmless
+type record struct {
+	Op         string       `json:"op"`
+	ID         int64        `json:"id"`
+	Version    int64        `json:"version,omitempty"`
+	Items      []itemRecord `json:"items,omitempty"`
+	CreatedAt  time.Time    `json:"createdAt,omitempty"`
+	ModifiedAt time.Time    `json:"modifiedAt,omitempty"`
+	At         time.Time    `json:"at,omitempty"`
+}
+
+type snapshot struct {
+	Carts   []record `json:"carts"`
+	Expired []record `json:"expired,omitempty"`
+}
+
+type fileStore struct {
+	sync.RWMutex
+	dir     string
+	opts    FileStoreOptions
+	carts   map[int64]entry
+	expired map[int64]time.Time
+	wal     *wal
+	records int
+	closed  bool
+}
+
+// NewFileStore creates a cart store backed by a write-ahead log and a snapshot in dir, recovering their carts
+// A record torn by a crash at the end of the log is discarded
+func NewFileStore(dir string, opts FileStoreOptions) (FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &fileStore{dir: dir, opts: opts, carts: make(map[int64]entry), expired: make(map[int64]time.Time)}
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	w, err := openWAL(filepath.Join(dir, walFile), opts.Sync, opts.SyncInterval, s.replay)
+	if err != nil {
+		return nil, err
+	}
+	s.wal = w
+	return s, nil
+}
+
+// Get retrieves a cart from the store
+func (s *fileStore) Get(id int64) Cart {
+	c, _ := s.GetVersioned(id)
+	return c
+}
+
+// GetVersioned retrieves a cart from the store with its version
+func (s *fileStore) GetVersioned(id int64) (Cart, int64) {
+	s.RLock()
+	defer s.RUnlock()
+	e, ok := s.carts[id]
+	if !ok {
+		return DummyCart, 0
+	}
+	return fromCart(e.cart), e.version
+}
+
+// Save persists a cart into the store
+func (s *fileStore) Save(c Cart) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.save(c, s.carts[c.GetID()].version+1)
+}
+
+// SaveIfVersion persists a cart into the store only if the stored version is the given one
+func (s *fileStore) SaveIfVersion(c Cart, version int64) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.carts[c.GetID()].version != version {
+		return ErrVersionConflict
+	}
+	return s.save(c, version+1)
+}
+
+// Delete remove a cart from the store
+func (s *fileStore) Delete(id int64) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.delete(id)
+}
+
+// DeleteIfVersion removes a cart from the store only if the stored version is the given one
+func (s *fileStore) DeleteIfVersion(id int64, version int64) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.carts[id].version != version {
+		return ErrVersionConflict
+	}
+	return s.delete(id)
+}
+
+func (s *fileStore) delete(id int64) error {
+	if _, ok := s.carts[id]; !ok {
+		return nil
+	}
+	if err := s.append(record{Op: "delete", ID: id}); err != nil {
+		return err
+	}
+	delete(s.carts, id)
+	s.snapshotIfDue()
+	return nil
+}
+
+// Snapshot writes all the carts into the snapshot and empties the log
+func (s *fileStore) Snapshot() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	return s.snapshot()
+}
+
+// Close flushes the log and releases the files
+func (s *fileStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.wal.close()
+}
+
+func (s *fileStore) save(c Cart, version int64) error {
+	if err := s.append(saveRecord(c, version)); err != nil {
+		return err
+	}
+	s.carts[c.GetID()] = entry{cart: fromCart(c), version: version}
+	delete(s.expired, c.GetID())
+	s.snapshotIfDue()
+	return nil
+}
+
+// Expire removes the carts not modified since before returning their IDs
+func (s *fileStore) Expire(before time.Time) ([]int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	for id, at := range s.expired {
+		if at.Before(before) {
+			delete(s.expired, id)
+		}
+	}
+	var ids []int64
+	at := now()
+	for id, e := range s.carts {
+		if !e.cart.GetModifiedAt().Before(before) {
+			continue
+		}
+		if err := s.append(record{Op: "expire", ID: id, At: at}); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+		delete(s.carts, id)
+		s.expired[id] = at
+	}
+	s.snapshotIfDue()
+	return ids, nil
+}
+
+// Expired tells if the cart was removed by an expiration
+func (s *fileStore) Expired(id int64) bool {
+	s.RLock()
+	defer s.RUnlock()
+	_, ok := s.expired[id]
+	return ok
+}
+
+func saveRecord(c Cart, version int64) record {
+	r := record{Op: "save", ID: c.GetID(), Version: version, CreatedAt: c.GetCreatedAt(), ModifiedAt: c.GetModifiedAt()}
+	for _, i := range c.GetItems() {
+		r.Items = append(r.Items, itemRecord{ID: i.ID, Quantity: i.Quantity})
+	}
+	return r
+}
+
+func (s *fileStore) append(r record) error {
+	if s.closed {
+		return ErrStoreClosed
+	}
+	if err := s.wal.append(r); err != nil {
+		return err
+	}
+	s.records++
+	return nil
+}
+
+// snapshotIfDue takes a snapshot when enough records were appended reporting its failure to SnapshotFailed
+// The appended records are already durable, so a failed snapshot does not fail the change
+func (s *fileStore) snapshotIfDue() {
+	if s.opts.SnapshotEvery <= 0 || s.records < s.opts.SnapshotEvery {
+		return
+	}
+	if err := s.snapshot(); err != nil && s.opts.SnapshotFailed != nil {
+		s.opts.SnapshotFailed(err)
+	}
+}
+
+// snapshot atomically replaces the snapshot file and then truncates the log
+// A crash in between only makes the log replay records already in the snapshot
+func (s *fileStore) snapshot() error {
+	var snap snapshot
+	for _, e := range s.carts {
+		snap.Carts = append(snap.Carts, saveRecord(e.cart, e.version))
+	}
+	for id, at := range s.expired {
+		snap.Expired = append(snap.Expired, record{Op: "expire", ID: id, At: at})
+	}
+	j, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, snapshotFile+".tmp")
+	if err := writeFileSync(tmp, j); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, snapshotFile)); err != nil {
+		return err
+	}
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+	if err := s.wal.reset(); err != nil {
+		return err
+	}
+	s.records = 0
+	return nil
+}
+
+func (s *fileStore) loadSnapshot() error {
+	j, err := ioutil.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(j, &snap); err != nil {
+		return fmt.Errorf("%v: %v", ErrCorruptedLog, err)
+	}
+	for _, r := range append(snap.Carts, snap.Expired...) {
+		if err := s.apply(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fileStore) replay(j []byte) error {
+	var r record
+	if err := json.Unmarshal(j, &r); err != nil {
+		return fmt.Errorf("%v: %v", ErrCorruptedLog, err)
+	}
+	s.records++
+	return s.apply(r)
+}
+
+func (s *fileStore) apply(r record) error {
+	switch r.Op {
+	case "save":
+		c, err := NewCart(r.ID)
+		if err != nil {
+			return fmt.Errorf("%v: %v", ErrCorruptedLog, err)
+		}
+		for _, i := range r.Items {
+			if err := c.AddArticle(i.ID, i.Quantity); err != nil {
+				return fmt.Errorf("%v: %v", ErrCorruptedLog, err)
+			}
+		}
+		stamp(c, r.CreatedAt, r.ModifiedAt)
+		s.carts[r.ID] = entry{cart: c, version: r.Version}
+		delete(s.expired, r.ID)
+	case "delete":
+		delete(s.carts, r.ID)
+	case "expire":
+		delete(s.carts, r.ID)
+		s.expired[r.ID] = r.At
+	default:
+		return fmt.Errorf("%v: unknown operation %q", ErrCorruptedLog, r.Op)
+	}
+	return nil
+}
+
+func writeFileSync(name string, data []byte) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
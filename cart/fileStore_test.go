@@ -0,0 +1,283 @@
+package cart
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempStoreDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cartstore")
+	if err != nil {
+		t.Fatalf("Error creating the store dir %v", err)
+	}
+	return dir
+}
+
+func openFileStore(t *testing.T, dir string, opts FileStoreOptions) FileStore {
+	s, err := NewFileStore(dir, opts)
+	if err != nil {
+		t.Fatalf("Error opening the store %v", err)
+	}
+	return s
+}
+
+func TestFileStoreReload(t *testing.T) {
+	dir := tempStoreDir(t)
+	defer os.RemoveAll(dir)
+	for _, every := range []int{0, 2} {
+		os.RemoveAll(dir)
+		s := openFileStore(t, dir, FileStoreOptions{SnapshotEvery: every})
+		for id := int64(1); id <= 3; id++ {
+			c, _ := NewCart(id)
+			c.AddArticle("article1", int(id))
+			s.Save(c)
+		}
+		c := s.Get(2)
+		c.AddArticle("article2", 5)
+		s.SaveIfVersion(c, 1)
+		s.Delete(3)
+		s.Close()
+
+		s = openFileStore(t, dir, FileStoreOptions{SnapshotEvery: every})
+		if c, v := s.GetVersioned(2); v != 2 || c.GetQuantity() != 7 || len(c.GetItems()) != 2 {
+			t.Errorf("Snapshot every %d: reloaded cart %v with version %d", every, c, v)
+		}
+		if c := s.Get(1); c.GetQuantity() != 1 {
+			t.Errorf("Snapshot every %d: reloaded cart %v", every, c)
+		}
+		if c := s.Get(3); c != DummyCart {
+			t.Errorf("Snapshot every %d: reloaded deleted cart %v", every, c)
+		}
+		s.Close()
+	}
+}
+
+func TestFileStoreExpire(t *testing.T) {
+	dir := tempStoreDir(t)
+	defer os.RemoveAll(dir)
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	s := openFileStore(t, dir, FileStoreOptions{})
+	testExpire(t, s, func() { clock = clock.Add(time.Hour) })
+	s.Close()
+
+	s = openFileStore(t, dir, FileStoreOptions{SnapshotEvery: 1})
+	c := s.Get(2)
+	if !c.GetCreatedAt().Equal(time.Date(2026, 1, 1, 1, 0, 0, 0, time.UTC)) {
+		t.Errorf("Reloaded cart created at %v", c.GetCreatedAt())
+	}
+	if s.Expired(2) || s.Get(1) != DummyCart {
+		t.Errorf("Reloaded cart 2 expired or cart 1 not expired")
+	}
+	s.Expire(clock.Add(time.Hour))
+	s.Close()
+	s = openFileStore(t, dir, FileStoreOptions{})
+	defer s.Close()
+	if !s.Expired(2) || s.Get(2) != DummyCart {
+		t.Errorf("Cart expired before the snapshot not reloaded as expired")
+	}
+}
+
+func TestFileStoreDiscardsTornRecord(t *testing.T) {
+	dir := tempStoreDir(t)
+	defer os.RemoveAll(dir)
+	s := openFileStore(t, dir, FileStoreOptions{})
+	c, _ := NewCart(1)
+	c.AddArticle("article1", 1)
+	s.Save(c)
+	s.Close()
+	f, _ := os.OpenFile(filepath.Join(dir, walFile), os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`0badc0de {"op":"save","id":1,"version":2,"items":[{"id":"art`)
+	f.Close()
+
+	s = openFileStore(t, dir, FileStoreOptions{})
+	if c, v := s.GetVersioned(1); v != 1 || c.GetQuantity() != 1 {
+		t.Errorf("Reloaded cart %v with version %d instead of the one before the torn record", c, v)
+	}
+	c.SetArticleQty("article1", 2)
+	if err := s.SaveIfVersion(c, 1); err != nil {
+		t.Fatalf("Error saving after recovery %v", err)
+	}
+	s.Close()
+	s = openFileStore(t, dir, FileStoreOptions{})
+	defer s.Close()
+	if c, v := s.GetVersioned(1); v != 2 || c.GetQuantity() != 2 {
+		t.Errorf("Cart %v with version %d saved after recovery not reloaded", c, v)
+	}
+}
+
+func TestFileStoreRejectsCorruptedLog(t *testing.T) {
+	dir := tempStoreDir(t)
+	defer os.RemoveAll(dir)
+	s := openFileStore(t, dir, FileStoreOptions{})
+	c, _ := NewCart(1)
+	s.Save(c)
+	s.Save(c)
+	s.Close()
+	wal := filepath.Join(dir, walFile)
+	b, _ := ioutil.ReadFile(wal)
+	b[len(b)/4] ^= 0xff
+	ioutil.WriteFile(wal, b, 0644)
+	if _, err := NewFileStore(dir, FileStoreOptions{}); err == nil || !strings.HasPrefix(err.Error(), ErrCorruptedLog.Error()) {
+		t.Errorf("Error %v instead of %v opening a log corrupted before its end", err, ErrCorruptedLog)
+	}
+}
+
+func TestFileStoreChangesSurviveFailedSnapshots(t *testing.T) {
+	dir := tempStoreDir(t)
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, snapshotFile+".tmp")
+	os.Mkdir(tmp, 0755)
+	var failures int
+	opts := FileStoreOptions{SnapshotEvery: 1, SnapshotFailed: func(error) { failures++ }}
+	s := openFileStore(t, dir, opts)
+	c, _ := NewCart(1)
+	if err := s.Save(c); err != nil || failures != 1 {
+		t.Fatalf("Save with a failed snapshot: %v with %d failures reported", err, failures)
+	}
+	if _, err := s.Expire(now().Add(time.Hour)); err != nil || failures != 2 {
+		t.Fatalf("Expire with a failed snapshot: %v with %d failures reported", err, failures)
+	}
+	os.Remove(tmp)
+	c, _ = NewCart(2)
+	if err := s.Save(c); err != nil || failures != 2 {
+		t.Errorf("Save after the snapshot failures: %v with %d failures reported", err, failures)
+	}
+	s.Close()
+	s = openFileStore(t, dir, opts)
+	defer s.Close()
+	if s.Get(2) == DummyCart || !s.(*fileStore).Expired(1) {
+		t.Errorf("Changes lost after failed snapshots")
+	}
+}
+
+// faultyLog writes only half of the next record and fails, then fails truncating if told so
+type faultyLog struct {
+	*os.File
+	failWrite    bool
+	failTruncate bool
+}
+
+func (f *faultyLog) Write(b []byte) (int, error) {
+	if f.failWrite {
+		f.failWrite = false
+		n, _ := f.File.Write(b[:len(b)/2])
+		return n, errors.New("Disk full")
+	}
+	return f.File.Write(b)
+}
+
+func (f *faultyLog) Truncate(size int64) error {
+	if f.failTruncate {
+		return errors.New("I/O error")
+	}
+	return f.File.Truncate(size)
+}
+
+func TestFileStoreUndoesFailedAppend(t *testing.T) {
+	dir := tempStoreDir(t)
+	defer os.RemoveAll(dir)
+	s := openFileStore(t, dir, FileStoreOptions{})
+	c, _ := NewCart(1)
+	s.Save(c)
+	w := s.(*fileStore).wal
+	fl := &faultyLog{File: w.f.(*os.File), failWrite: true}
+	w.f = fl
+	c.AddArticle("article1", 1)
+	if err := s.Save(c); err == nil {
+		t.Fatalf("Save succeeded with a failed write")
+	}
+	if err := s.Save(c); err != nil {
+		t.Fatalf("Error saving after a failed write undone %v", err)
+	}
+	s.Close()
+	s = openFileStore(t, dir, FileStoreOptions{})
+	defer s.Close()
+	if c, v := s.GetVersioned(1); v != 2 || c.GetQuantity() != 1 {
+		t.Errorf("Reloaded cart %v with version %d instead of the one saved after the failed write", c, v)
+	}
+}
+
+func TestFileStoreRefusesAppendsAfterFailedUndo(t *testing.T) {
+	dir := tempStoreDir(t)
+	defer os.RemoveAll(dir)
+	s := openFileStore(t, dir, FileStoreOptions{})
+	defer s.Close()
+	c, _ := NewCart(1)
+	w := s.(*fileStore).wal
+	w.f = &faultyLog{File: w.f.(*os.File), failWrite: true, failTruncate: true}
+	if err := s.Save(c); err == nil {
+		t.Fatalf("Save succeeded with a failed write")
+	}
+	if err := s.Save(c); err == nil || !strings.HasPrefix(err.Error(), ErrLogFailed.Error()) {
+		t.Errorf("Save after a failed undo: %v instead of %v", err, ErrLogFailed)
+	}
+}
+
+// TestFileStoreCrashRecovery kills a process saving carts and checks every acknowledged save survives
+func TestFileStoreCrashRecovery(t *testing.T) {
+	if dir := os.Getenv("CART_STORE_CRASH_DIR"); dir != "" {
+		saveUntilKilled(dir)
+		return
+	}
+	if testing.Short() {
+		t.Skip("Skipping crash recovery in short mode")
+	}
+	dir := tempStoreDir(t)
+	defer os.RemoveAll(dir)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFileStoreCrashRecovery$")
+	cmd.Env = append(os.Environ(), "CART_STORE_CRASH_DIR="+dir)
+	out, _ := cmd.StdoutPipe()
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Error starting the writer %v", err)
+	}
+	acked := make(map[int64]int64)
+	sc := bufio.NewScanner(out)
+	for n := 0; n < 300 && sc.Scan(); n++ {
+		var id, version int64
+		if _, err := fmt.Sscanf(sc.Text(), "%d %d", &id, &version); err == nil {
+			acked[id] = version
+		}
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+
+	s := openFileStore(t, dir, FileStoreOptions{})
+	defer s.Close()
+	for id, version := range acked {
+		c, v := s.GetVersioned(id)
+		if v < version || c.GetQuantity() != int(v) {
+			t.Errorf("Cart %d reloaded as %v with version %d after version %d was acknowledged", id, c, v, version)
+		}
+	}
+}
+
+func saveUntilKilled(dir string) {
+	s, err := NewFileStore(dir, FileStoreOptions{Sync: SyncAlways, SnapshotEvery: 50})
+	if err != nil {
+		os.Exit(1)
+	}
+	for i := 0; ; i++ {
+		id := int64(i%7 + 1)
+		c, v := s.GetVersioned(id)
+		if c == DummyCart {
+			c, _ = NewCart(id)
+		}
+		c.RemoveArticle("article")
+		c.AddArticle("article", int(v+1))
+		if err := s.SaveIfVersion(c, v); err != nil {
+			os.Exit(1)
+		}
+		os.Stdout.WriteString(strconv.FormatInt(id, 10) + " " + strconv.FormatInt(v+1, 10) + "\n")
+	}
+}
@@ -0,0 +1,82 @@
+package cart
+
+import "time"
+
+type shardedStore struct {
+	shards []Store
+}
+
+// NewShardedStore creates a cart store split into shards, each with its own lock, so that writes to different carts do not serialize
+func NewShardedStore(shards int) Store {
+	if shards < 1 {
+		shards = 1
+	}
+	s := &shardedStore{shards: make([]Store, shards)}
+	for i := range s.shards {
+		s.shards[i] = NewStore()
+	}
+	return s
+}
+
+// Get retrieves a cart from the store
+func (s *shardedStore) Get(id int64) Cart {
+	return s.shard(id).Get(id)
+}
+
+// GetVersioned retrieves a cart from the store with its version
+func (s *shardedStore) GetVersioned(id int64) (Cart, int64) {
+	return s.shard(id).GetVersioned(id)
+}
+
+// Save persists a cart into the store
+func (s *shardedStore) Save(c Cart) error {
+	return s.shard(c.GetID()).Save(c)
+}
+
+// SaveIfVersion persists a cart into the store only if the stored version is the given one
+func (s *shardedStore) SaveIfVersion(c Cart, version int64) error {
+	return s.shard(c.GetID()).SaveIfVersion(c, version)
+}
+
+// Delete remove a cart from the store
+func (s *shardedStore) Delete(id int64) error {
+	return s.shard(id).Delete(id)
+}
+
+// DeleteIfVersion removes a cart from the store only if the stored version is the given one
+func (s *shardedStore) DeleteIfVersion(id int64, version int64) error {
+	return s.shard(id).DeleteIfVersion(id, version)
+}
+
+// Expire removes the carts not modified since before returning their IDs, one shard at a time
+func (s *shardedStore) Expire(before time.Time) ([]int64, error) {
+	var ids []int64
+	for _, sh := range s.shards {
+		expired, err := sh.Expire(before)
+		ids = append(ids, expired...)
+		if err != nil {
+			return ids, err
+		}
+	}
+	return ids, nil
+}
+
+// Expired tells if the cart was removed by an expiration
+func (s *shardedStore) Expired(id int64) bool {
+	return s.shard(id).Expired(id)
+}
+
+func (s *shardedStore) shard(id int64) Store {
+	return s.shards[shardIndex(id, len(s.shards))]
+}
+
+// shardIndex mixes all the bits of the id, since time ordered ids share their high bits and ids of the same node their low ones
+func shardIndex(id int64, shards int) int {
+	x := uint64(id)
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return int(x % uint64(shards))
+}
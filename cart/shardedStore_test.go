@@ -0,0 +1,71 @@
+package cart
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestShardedStore(t *testing.T) {
+	store := NewShardedStore(8)
+	c, _ := NewCart(1)
+	if err := store.SaveIfVersion(c, 0); err != nil {
+		t.Fatalf("Error saving new cart %v", err)
+	}
+	if err := store.SaveIfVersion(c, 0); err != ErrVersionConflict {
+		t.Errorf("Save of stale cart: %v instead of %v", err, ErrVersionConflict)
+	}
+	store.Delete(1)
+	if c := store.Get(1); c != DummyCart {
+		t.Errorf("Deleted cart still in store {%v}", c)
+	}
+}
+
+func TestShardedStoreExpire(t *testing.T) {
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	testExpire(t, NewShardedStore(8), func() { clock = clock.Add(time.Hour) })
+}
+
+func TestShardIndexSpreadsNodeIDs(t *testing.T) {
+	const shards = 16
+	counts := make([]int, shards)
+	for seq := int64(0); seq < 1600; seq++ {
+		// time<<24 | sequence<<16 | node as generated by a single node
+		counts[shardIndex((1000+seq/256)<<24|(seq%256)<<16|1, shards)]++
+	}
+	for i, n := range counts {
+		if n < 50 || n > 150 {
+			t.Errorf("Shard %d got %d of 1600 ids instead of about 100", i, n)
+		}
+	}
+}
+
+// benchmarkStore updates random carts with a compare-and-swap as the app service does
+func benchmarkStore(b *testing.B, store Store) {
+	const carts = 4096
+	for id := int64(1); id <= carts; id++ {
+		c, _ := NewCart(id)
+		c.AddArticle("article", 1)
+		store.Save(c)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			id := r.Int63n(carts) + 1
+			c, v := store.GetVersioned(id)
+			c.SetArticleQty("article", int(v%10)+1)
+			store.SaveIfVersion(c, v)
+		}
+	})
+}
+
+func BenchmarkStore(b *testing.B) {
+	benchmarkStore(b, NewStore())
+}
+
+func BenchmarkShardedStore(b *testing.B) {
+	benchmarkStore(b, NewShardedStore(64))
+}
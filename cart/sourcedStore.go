@@ -0,0 +1,192 @@
+package cart
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+var errNotRemoved = errors.New("Cart not removed")
+
+// EventSourcedStore is a Store rebuilding the carts from their events
+// The version of a cart is the number of events in its stream and expired carts are never forgotten
+type EventSourcedStore interface {
+	Store
+	History(id int64) []Event
+	GetAt(id int64, at time.Time) Cart
+	Close() error
+}
+
+// snapshotEvery is the number of events replayed after which the state of a cart is snapshotted
+var snapshotEvery = 100
+
+// cartState is a snapshot of a cart stream: the cart is nil if the stream ended with its removal
+type cartState struct {
+	cart    *cart
+	version int64
+}
+
+type sourcedStore struct {
+	sync.Mutex
+	events   EventStore
+	snapLock sync.Mutex
+	snaps    map[int64]cartState
+}
+
+// NewEventSourcedStore creates a cart store on top of an event store
+// The carts are rebuilt from their last snapshot, kept in memory, replaying only the events following it
+func NewEventSourcedStore(events EventStore) EventSourcedStore {
+	return &sourcedStore{events: events, snaps: make(map[int64]cartState)}
+}
+
+// Get retrieves a cart from the store
+func (s *sourcedStore) Get(id int64) Cart {
+	c, _ := s.GetVersioned(id)
+	return c
+}
+
+// GetVersioned retrieves a cart from the store with its version
+func (s *sourcedStore) GetVersioned(id int64) (Cart, int64) {
+	c, v := s.rebuild(id)
+	if c == DummyCart {
+		return DummyCart, 0
+	}
+	return c, v
+}
+
+// Save appends the changes of the cart to its stream
+func (s *sourcedStore) Save(c Cart) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.append(c, int64(len(s.events.Load(c.GetID()))))
+}
+
+// SaveIfVersion appends the changes of the cart to its stream only if the stream has version events
+func (s *sourcedStore) SaveIfVersion(c Cart, version int64) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.append(c, version)
+}
+
+// Delete records the deletion of an existing cart
+func (s *sourcedStore) Delete(id int64) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.delete(id)
+}
+
+// DeleteIfVersion records the deletion of an existing cart only if its version is the given one
+func (s *sourcedStore) DeleteIfVersion(id int64, version int64) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, v := s.GetVersioned(id); v != version {
+		return ErrVersionConflict
+	}
+	return s.delete(id)
+}
+
+func (s *sourcedStore) delete(id int64) error {
+	if err := s.remove(id, CartDeleted, func(Cart) bool { return true }); err != errNotRemoved {
+		return err
+	}
+	return nil
+}
+
+// Expire records the expiration of the carts not modified since before returning their IDs
+func (s *sourcedStore) Expire(before time.Time) ([]int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	var ids []int64
+	expired := func(c Cart) bool { return c.GetModifiedAt().Before(before) }
+	for _, id := range s.events.CartIDs() {
+		if err := s.remove(id, CartExpired, expired); err == errNotRemoved {
+			continue
+		} else if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Expired tells if the cart was removed by an expiration
+func (s *sourcedStore) Expired(id int64) bool {
+	events := s.events.Load(id)
+	return len(events) > 0 && events[len(events)-1].Type == CartExpired
+}
+
+// History returns the events that led the cart to its state
+func (s *sourcedStore) History(id int64) []Event {
+	return s.events.Load(id)
+}
+
+// GetAt rebuilds the cart as it was at the given moment: DummyCart if it did not exist then
+func (s *sourcedStore) GetAt(id int64, at time.Time) Cart {
+	events := s.events.Load(id)
+	n := sort.Search(len(events), func(i int) bool { return events[i].At.After(at) })
+	return replay(events[:n])
+}
+
+// Close releases the event store
+func (s *sourcedStore) Close() error {
+	if c, ok := s.events.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (s *sourcedStore) append(c Cart, version int64) error {
+	if err := s.events.Append(c.GetID(), version, changes(c)); err != nil {
+		return err
+	}
+	commit(c)
+	return nil
+}
+
+// remove ends the stream of an existing cart satisfying the condition with an event of the given type
+func (s *sourcedStore) remove(id int64, t EventType, cond func(Cart) bool) error {
+	c, v := s.rebuild(id)
+	if c == DummyCart || !cond(c) {
+		return errNotRemoved
+	}
+	e := Event{Type: t, At: now()}
+	return s.events.Append(id, v, []Event{e})
+}
+
+// rebuild applies the events following the last snapshot of the cart to a copy of it returning the stream version
+// The result is snapshotted when the stream ended or enough events were replayed
+func (s *sourcedStore) rebuild(id int64) (Cart, int64) {
+	s.snapLock.Lock()
+	snap := s.snaps[id]
+	s.snapLock.Unlock()
+	if snap.version > 0 && snap.cart == nil {
+		return DummyCart, snap.version
+	}
+	events := s.events.LoadFrom(id, snap.version)
+	var c Cart
+	if snap.cart == nil {
+		c = replay(events)
+	} else {
+		c = replayOnto(snap.cart.clone(), events)
+	}
+	v := snap.version + int64(len(events))
+	if c == DummyCart && v > 0 || len(events) >= snapshotEvery {
+		s.snapshot(id, c, v)
+	}
+	return c, v
+}
+
+// snapshot records the state of the cart at version unless a later one is already recorded
+func (s *sourcedStore) snapshot(id int64, c Cart, version int64) {
+	state := cartState{version: version}
+	if c != DummyCart {
+		state.cart = c.(*cart).clone()
+	}
+	s.snapLock.Lock()
+	defer s.snapLock.Unlock()
+	if s.snaps[id].version < version {
+		s.snaps[id] = state
+	}
+}
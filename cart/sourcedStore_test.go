@@ -0,0 +1,214 @@
+package cart
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventSourcedStoreHistory(t *testing.T) {
+	const cartID = 1
+	store := NewEventSourcedStore(NewEventStore())
+	c, _ := NewCart(cartID)
+	store.Save(c)
+	c, v := store.GetVersioned(cartID)
+	c.AddArticle("article1", 2)
+	c.AddArticle("article2", 1)
+	if err := store.SaveIfVersion(c, v); err != nil {
+		t.Fatalf("Error saving the cart %v", err)
+	}
+	stale, _ := NewCart(cartID)
+	stale.AddArticle("article3", 1)
+	if err := store.SaveIfVersion(stale, v); err != ErrVersionConflict {
+		t.Errorf("Save of stale cart: %v instead of %v", err, ErrVersionConflict)
+	}
+	c, v = store.GetVersioned(cartID)
+	c.SetArticleQty("article1", 5)
+	c.RemoveArticle("article2")
+	store.SaveIfVersion(c, v)
+
+	c, v = store.GetVersioned(cartID)
+	if v != 5 || c.GetQuantity() != 5 || len(c.GetItems()) != 1 || c.GetItems()[0].ID != "article1" {
+		t.Errorf("Rebuilt cart %v with version %d", c, v)
+	}
+	store.Delete(cartID)
+	if c := store.Get(cartID); c != DummyCart {
+		t.Errorf("Deleted cart rebuilt as %v", c)
+	}
+	exp := []EventType{CartCreated, ArticleAdded, ArticleAdded, QuantityChanged, ArticleRemoved, CartDeleted}
+	history := store.History(cartID)
+	if len(history) != len(exp) {
+		t.Fatalf("History %v instead of %v", history, exp)
+	}
+	for i, e := range history {
+		if e.Type != exp[i] || e.Version != int64(i+1) || e.CartID != cartID {
+			t.Errorf("Event %d is %v instead of %s with version %d", i, e, exp[i], i+1)
+		}
+	}
+}
+
+func TestEventSourcedStoreGetAt(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := start
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	store := NewEventSourcedStore(NewEventStore())
+	c, _ := NewCart(1)
+	store.Save(c)
+	clock = clock.Add(time.Hour)
+	c.AddArticle("article1", 2)
+	store.Save(c)
+	clock = clock.Add(time.Hour)
+	store.Delete(1)
+	for at, exp := range map[time.Duration]int{-time.Minute: -1, 0: 0, 90 * time.Minute: 2, 3 * time.Hour: -1} {
+		c := store.GetAt(1, start.Add(at))
+		if exp < 0 && c != DummyCart || exp >= 0 && (c == DummyCart || c.GetQuantity() != exp) {
+			t.Errorf("Cart at %v is %v instead of having quantity %d", at, c, exp)
+		}
+	}
+}
+
+func TestEventSourcedStoreExpire(t *testing.T) {
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	store := NewEventSourcedStore(NewEventStore())
+	old, _ := NewCart(1)
+	store.Save(old)
+	clock = clock.Add(time.Hour)
+	recent, _ := NewCart(2)
+	store.Save(recent)
+	ids, err := store.Expire(recent.GetModifiedAt())
+	if err != nil || len(ids) != 1 || ids[0] != 1 {
+		t.Fatalf("Expired carts %v, %v instead of 1", ids, err)
+	}
+	if store.Get(1) != DummyCart || !store.Expired(1) || store.Expired(2) {
+		t.Errorf("Cart 1 not expired or cart 2 expired")
+	}
+	if ids, _ := store.Expire(clock.Add(time.Hour)); len(ids) != 1 || ids[0] != 2 || !store.Expired(1) {
+		t.Errorf("Expired carts %v instead of 2 keeping cart 1 expired", ids)
+	}
+}
+
+func TestFileEventStoreReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cartevents")
+	if err != nil {
+		t.Fatalf("Error creating the store dir %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.log")
+	es, err := NewFileEventStore(path, SyncAlways, 0)
+	if err != nil {
+		t.Fatalf("Error opening the event store %v", err)
+	}
+	store := NewEventSourcedStore(es)
+	c, _ := NewCart(1)
+	c.AddArticle("article1", 3)
+	store.Save(c)
+	store.Close()
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`0badc0de {"cartId":1,"version":3,"type":"Article`)
+	f.Close()
+
+	es, err = NewFileEventStore(path, SyncAlways, 0)
+	if err != nil {
+		t.Fatalf("Error reopening the event store %v", err)
+	}
+	store = NewEventSourcedStore(es)
+	defer store.Close()
+	if c, v := store.GetVersioned(1); v != 2 || c.GetQuantity() != 3 {
+		t.Errorf("Reloaded cart %v with version %d", c, v)
+	}
+	if h := store.History(1); len(h) != 2 || h[1].Type != ArticleAdded || h[1].ArticleID != "article1" {
+		t.Errorf("Reloaded history %v", h)
+	}
+}
+
+func TestEventSourcedStoreSnapshots(t *testing.T) {
+	every := snapshotEvery
+	snapshotEvery = 2
+	defer func() { snapshotEvery = every }()
+	events := NewEventStore()
+	store := NewEventSourcedStore(events)
+	c, _ := NewCart(1)
+	store.Save(c)
+	for i, qty := range []int{1, 2, 3, 4, 5} {
+		c, v := store.GetVersioned(1)
+		if exp := events.Load(1); !reflect.DeepEqual(c, replay(exp)) || v != int64(len(exp)) {
+			t.Fatalf("Cart rebuilt as %v with version %d instead of %v with version %d", c, v, replay(exp), len(exp))
+		}
+		c.AddArticle(fmt.Sprintf("article%d", i), qty)
+		c.SetArticleQty("article0", qty)
+		store.SaveIfVersion(c, v)
+	}
+	snap := store.(*sourcedStore).snaps[1]
+	if snap.cart == nil || snap.version < 5 {
+		t.Fatalf("Snapshot %v after 11 events", snap)
+	}
+	c, v := store.GetVersioned(1)
+	c.RemoveArticle("article0")
+	if c, _ := store.GetVersioned(1); v != 11 || c.GetQuantity() != 19 || len(c.GetItems()) != 5 {
+		t.Errorf("Cart rebuilt as %v with version %d changing an unsaved copy", c, v)
+	}
+	store.Delete(1)
+	if c, v := store.GetVersioned(1); c != DummyCart || v != 0 {
+		t.Errorf("Deleted cart rebuilt as %v with version %d", c, v)
+	}
+	if snap := store.(*sourcedStore).snaps[1]; snap.cart != nil || snap.version != 12 {
+		t.Errorf("Snapshot %v of a deleted cart", snap)
+	}
+	if ids, err := store.Expire(time.Now().Add(time.Hour)); err != nil || len(ids) != 0 || store.Expired(1) {
+		t.Errorf("Expired carts %v, %v after the deletion", ids, err)
+	}
+}
+
+func TestFileEventStoreAppendIsAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cartevents")
+	if err != nil {
+		t.Fatalf("Error creating the store dir %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.log")
+	es, err := NewFileEventStore(path, SyncAlways, 0)
+	if err != nil {
+		t.Fatalf("Error opening the event store %v", err)
+	}
+	store := NewEventSourcedStore(es)
+	c, _ := NewCart(1)
+	c.AddArticle("article1", 3)
+	w := es.(*eventStore).log
+	w.f = &faultyLog{File: w.f.(*os.File), failWrite: true}
+	if err := store.Save(c); err == nil {
+		t.Fatalf("Save succeeded with a failed write")
+	}
+	if h := store.History(1); len(h) != 0 {
+		t.Fatalf("History %v after a failed save", h)
+	}
+	if err := store.Save(c); err != nil {
+		t.Fatalf("Error saving after a failed write %v", err)
+	}
+	c.AddArticle("article2", 1)
+	c.AddArticle("article3", 1)
+	store.Save(c)
+	store.Close()
+	j, _ := ioutil.ReadFile(path)
+	if n := strings.Count(string(j), "\n"); n != 2 {
+		t.Fatalf("%d log records instead of one per save", n)
+	}
+	ioutil.WriteFile(path, j[:len(j)-10], 0644)
+
+	es, err = NewFileEventStore(path, SyncAlways, 0)
+	if err != nil {
+		t.Fatalf("Error reopening the event store %v", err)
+	}
+	store = NewEventSourcedStore(es)
+	defer store.Close()
+	if c, v := store.GetVersioned(1); v != 2 || c.GetQuantity() != 3 {
+		t.Errorf("Reloaded cart %v with version %d instead of the one before the torn save", c, v)
+	}
+}
@@ -1,46 +1,131 @@
 package cart
 
-import "sync"
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrVersionConflict when the stored cart version is not the expected one
+var ErrVersionConflict = errors.New("Cart version does not match")
 
 // Store handles carts
+// Every save increments the cart version: a cart never saved has version 0
+// Expired carts leave a tombstone, kept at least until the following expiration that finds it older than the cutoff
 type Store interface {
 	Get(id int64) Cart
-	Save(c Cart)
-	Delete(id int64)
+	GetVersioned(id int64) (Cart, int64)
+	Save(c Cart) error
+	SaveIfVersion(c Cart, version int64) error
+	Delete(id int64) error
+	DeleteIfVersion(id int64, version int64) error
+	Expire(before time.Time) ([]int64, error)
+	Expired(id int64) bool
+}
+
+type entry struct {
+	cart    Cart
+	version int64
 }
 
 type store struct {
 	sync.RWMutex
-	carts map[int64]Cart
+	carts   map[int64]entry
+	expired map[int64]time.Time
 }
 
 // NewStore creates a cart store
 func NewStore() Store {
 	s := new(store)
-	s.carts = make(map[int64]Cart)
+	s.carts = make(map[int64]entry)
+	s.expired = make(map[int64]time.Time)
 	return s
 }
 
 // Get retrieves a cart from the store
 func (s *store) Get(id int64) Cart {
+	c, _ := s.GetVersioned(id)
+	return c
+}
+
+// GetVersioned retrieves a cart from the store with its version
+func (s *store) GetVersioned(id int64) (Cart, int64) {
 	s.RLock()
 	defer s.RUnlock()
-	if _, ok := s.carts[id]; !ok {
-		return DummyCart
+	e, ok := s.carts[id]
+	if !ok {
+		return DummyCart, 0
 	}
-	return fromCart(s.carts[id])
+	return fromCart(e.cart), e.version
 }
 
 // Save persists a cart into the store
-func (s *store) Save(c Cart) {
+func (s *store) Save(c Cart) error {
 	s.Lock()
 	defer s.Unlock()
-	s.carts[c.GetID()] = fromCart(c)
+	e := s.carts[c.GetID()]
+	s.carts[c.GetID()] = entry{cart: fromCart(c), version: e.version + 1}
+	delete(s.expired, c.GetID())
+	return nil
+}
+
+// SaveIfVersion persists a cart into the store only if the stored version is the given one
+func (s *store) SaveIfVersion(c Cart, version int64) error {
+	s.Lock()
+	defer s.Unlock()
+	e := s.carts[c.GetID()]
+	if e.version != version {
+		return ErrVersionConflict
+	}
+	s.carts[c.GetID()] = entry{cart: fromCart(c), version: e.version + 1}
+	delete(s.expired, c.GetID())
+	return nil
 }
 
 // Delete remove a cart from the store
-func (s *store) Delete(id int64) {
+func (s *store) Delete(id int64) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.carts, id)
+	return nil
+}
+
+// DeleteIfVersion removes a cart from the store only if the stored version is the given one
+func (s *store) DeleteIfVersion(id int64, version int64) error {
 	s.Lock()
 	defer s.Unlock()
+	if s.carts[id].version != version {
+		return ErrVersionConflict
+	}
 	delete(s.carts, id)
+	return nil
+}
+
+// Expire removes the carts not modified since before returning their IDs
+func (s *store) Expire(before time.Time) ([]int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	for id, at := range s.expired {
+		if at.Before(before) {
+			delete(s.expired, id)
+		}
+	}
+	var ids []int64
+	at := now()
+	for id, e := range s.carts {
+		if e.cart.GetModifiedAt().Before(before) {
+			ids = append(ids, id)
+			delete(s.carts, id)
+			s.expired[id] = at
+		}
+	}
+	return ids, nil
+}
+
+// Expired tells if the cart was removed by an expiration
+func (s *store) Expired(id int64) bool {
+	s.RLock()
+	defer s.RUnlock()
+	_, ok := s.expired[id]
+	return ok
 }
@@ -1,6 +1,10 @@
 package cart
 
-import "testing"
+import (
+	"os"
+	"testing"
+	"time"
+)
 
 func TestSaveNewCart(t *testing.T) {
 	const (
@@ -35,3 +39,104 @@ func TestDeleteExistentCart(t *testing.T) {
 		t.Errorf("Deleted cart still in store {%v}", cart)
 	}
 }
+
+func TestSaveIfVersion(t *testing.T) {
+	const cartID = 1
+	store := NewStore()
+	c, _ := NewCart(cartID)
+	if err := store.SaveIfVersion(c, 1); err != ErrVersionConflict {
+		t.Errorf("Save of new cart with version 1: %v instead of %v", err, ErrVersionConflict)
+	}
+	if err := store.SaveIfVersion(c, 0); err != nil {
+		t.Fatalf("Error saving new cart %v", err)
+	}
+	c1, v1 := store.GetVersioned(cartID)
+	c2, v2 := store.GetVersioned(cartID)
+	c1.AddArticle("article1", 1)
+	c2.AddArticle("article2", 1)
+	if err := store.SaveIfVersion(c1, v1); err != nil {
+		t.Fatalf("Error saving cart with current version %v", err)
+	}
+	if err := store.SaveIfVersion(c2, v2); err != ErrVersionConflict {
+		t.Errorf("Save of stale cart: %v instead of %v", err, ErrVersionConflict)
+	}
+	if c, v := store.GetVersioned(cartID); v != 2 || len(c.GetItems()) != 1 || c.GetItems()[0].ID != "article1" {
+		t.Errorf("Stored cart %v with version %d instead of the first saved one with version 2", c, v)
+	}
+}
+
+func TestDeleteIfVersion(t *testing.T) {
+	dir := tempStoreDir(t)
+	defer os.RemoveAll(dir)
+	fs := openFileStore(t, dir, FileStoreOptions{})
+	defer fs.Close()
+	stores := map[string]Store{
+		"memory":  NewStore(),
+		"sharded": NewShardedStore(4),
+		"file":    fs,
+		"events":  NewEventSourcedStore(NewEventStore()),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) { testDeleteIfVersion(t, store) })
+	}
+}
+
+func testDeleteIfVersion(t *testing.T, store Store) {
+	const cartID = 1
+	c, _ := NewCart(cartID)
+	store.Save(c)
+	c, v := store.GetVersioned(cartID)
+	c.AddArticle("article1", 1)
+	store.Save(c)
+	if err := store.DeleteIfVersion(cartID, v); err != ErrVersionConflict {
+		t.Errorf("Delete of stale cart: %v instead of %v", err, ErrVersionConflict)
+	}
+	if c := store.Get(cartID); c == DummyCart {
+		t.Fatal("Cart deleted with a stale version")
+	}
+	if err := store.DeleteIfVersion(cartID, v+1); err != nil {
+		t.Errorf("Error deleting cart with current version %v", err)
+	}
+	if c, v := store.GetVersioned(cartID); c != DummyCart || v != 0 {
+		t.Errorf("Deleted cart %v with version %d still in store", c, v)
+	}
+	if err := store.DeleteIfVersion(cartID, 0); err != nil {
+		t.Errorf("Error deleting missing cart %v", err)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	testExpire(t, NewStore(), func() { clock = clock.Add(time.Hour) })
+}
+
+func testExpire(t *testing.T, store Store, tick func()) {
+	old, _ := NewCart(1)
+	store.Save(old)
+	tick()
+	recent, _ := NewCart(2)
+	store.Save(recent)
+	cutoff := recent.GetModifiedAt()
+	ids, err := store.Expire(cutoff)
+	if err != nil || len(ids) != 1 || ids[0] != 1 {
+		t.Fatalf("Expired carts %v, %v instead of 1", ids, err)
+	}
+	if store.Get(1) != DummyCart || !store.Expired(1) {
+		t.Errorf("Expired cart still in store or not flagged as expired")
+	}
+	if store.Get(2) == DummyCart || store.Expired(2) {
+		t.Errorf("Recent cart expired")
+	}
+	tick()
+	tick()
+	if ids, _ := store.Expire(cutoff); len(ids) != 0 || !store.Expired(1) {
+		t.Errorf("Expire with the same cutoff: carts %v expired, tombstone of 1 kept %t", ids, store.Expired(1))
+	}
+	recent.AddArticle("article", 1)
+	store.Save(recent)
+	if ids, _ := store.Expire(cutoff.Add(time.Hour)); len(ids) != 0 || store.Expired(1) {
+		t.Errorf("Carts %v expired, tombstone of cart 1 kept %t after its TTL", ids, store.Expired(1))
+	}
+}
@@ -0,0 +1,205 @@
+package cart
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrCorruptedLog when the log contains an invalid record that is not the last one
+var ErrCorruptedLog = errors.New("Cart log is corrupted")
+
+// ErrStoreClosed when the store is used after being closed
+var ErrStoreClosed = errors.New("Cart store is closed")
+
+// ErrLogFailed when the log cannot be appended anymore because a failed write could not be undone
+var ErrLogFailed = errors.New("Cart log failed")
+
+// SyncPolicy tells when a log is flushed to disk
+type SyncPolicy int
+
+const (
+	// SyncAlways flushes every record before the write returns
+	SyncAlways SyncPolicy = iota
+	// SyncPeriodic flushes the records every sync interval: a crash loses at most the last interval
+	SyncPeriodic
+	// SyncNever leaves flushing to the operating system
+	SyncNever
+)
+
+// logFile is the file underlying a log
+type logFile interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+// wal is an append-only file of JSON records, one per line prefixed by its CRC32
+// A failed append is undone truncating the log to its previous size: if even that fails the log refuses any other append
+type wal struct {
+	sync.Mutex
+	f      logFile
+	size   int64
+	policy SyncPolicy
+	closed bool
+	failed error
+	done   chan struct{}
+}
+
+// openWAL replays the records of the log at path truncating a record torn by a crash at its end
+func openWAL(path string, policy SyncPolicy, interval time.Duration, replay func(j []byte) error) (*wal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	valid, err := replayLines(f, replay)
+	if err == nil {
+		err = f.Truncate(valid)
+	}
+	if err == nil {
+		_, err = f.Seek(valid, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w := &wal{f: f, size: valid, policy: policy, done: make(chan struct{})}
+	if policy == SyncPeriodic && interval > 0 {
+		go w.syncPeriodically(interval)
+	}
+	return w, nil
+}
+
+// replayLines returns the length of the valid records
+func replayLines(f io.Reader, replay func(j []byte) error) (int64, error) {
+	var valid int64
+	rd := bufio.NewReader(f)
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return valid, nil
+		}
+		if err != nil && err != io.EOF {
+			return valid, err
+		}
+		j, ok := parseLine(line)
+		if !ok {
+			if _, err := rd.Peek(1); err != io.EOF {
+				return valid, fmt.Errorf("%v: invalid record at offset %d", ErrCorruptedLog, valid)
+			}
+			return valid, nil
+		}
+		if err := replay(j); err != nil {
+			return valid, err
+		}
+		valid += int64(len(line))
+	}
+}
+
+func parseLine(line []byte) ([]byte, bool) {
+	if len(line) < 10 || line[len(line)-1] != '\n' || line[8] != ' ' {
+		return nil, false
+	}
+	j := bytes.TrimSuffix(line[9:], []byte("\n"))
+	var sum uint32
+	if _, err := fmt.Sscanf(string(line[:8]), "%08x", &sum); err != nil || sum != crc32.ChecksumIEEE(j) {
+		return nil, false
+	}
+	return j, true
+}
+
+func (w *wal) append(v interface{}) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		return ErrStoreClosed
+	}
+	if w.failed != nil {
+		return fmt.Errorf("%v: %v", ErrLogFailed, w.failed)
+	}
+	line := []byte(fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(j), j))
+	_, err = w.f.Write(line)
+	if err == nil && w.policy == SyncAlways {
+		err = w.f.Sync()
+	}
+	if err != nil {
+		w.rollback()
+		return err
+	}
+	w.size += int64(len(line))
+	return nil
+}
+
+// rollback truncates the log to the size before a failed append marking it failed if not possible
+func (w *wal) rollback() {
+	err := w.f.Truncate(w.size)
+	if err == nil {
+		_, err = w.f.Seek(w.size, io.SeekStart)
+	}
+	if err == nil && w.policy == SyncAlways {
+		err = w.f.Sync()
+	}
+	if err != nil {
+		w.failed = err
+	}
+}
+
+// reset empties the log
+func (w *wal) reset() error {
+	w.Lock()
+	defer w.Unlock()
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w.size = 0
+	return w.f.Sync()
+}
+
+// close flushes and closes the log
+func (w *wal) close() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+	if err := w.f.Sync(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+func (w *wal) syncPeriodically(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			w.Lock()
+			if !w.closed {
+				w.f.Sync()
+			}
+			w.Unlock()
+		case <-w.done:
+			return
+		}
+	}
+}
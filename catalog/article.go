@@ -1,16 +1,19 @@
 package catalog
 
-import "fmt"
+import (
+	"fmt"
+	"shopping-cart-kata/money"
+)
 
 // Article represents a catalog item
 type Article struct {
 	Code  string
 	Name  string
-	Price float64
+	Price money.Money
 }
 
 var DummyArticle Article
 
 func (a Article) String() string {
-	return fmt.Sprintf(`{ "code": %q, "name": %s, "price": %g }`, a.Code, a.Name, a.Price)
+	return fmt.Sprintf(`{ "code": %q, "name": %s, "price": "%s" }`, a.Code, a.Name, a.Price)
 }
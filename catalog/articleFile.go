@@ -0,0 +1,39 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrArticlesDecode when the articles cannot be decoded
+var ErrArticlesDecode = errors.New("Articles cannot be decoded")
+
+// ErrInvalidArticle when an article has no code, a duplicated code, a negative price or one in another currency
+var ErrInvalidArticle = errors.New("Article is not valid")
+
+// ParseArticles decodes and validates a JSON array of articles, the format of the catalog route of the web API
+func ParseArticles(r io.Reader) ([]Article, error) {
+	var arts []Article
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&arts); err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrArticlesDecode, err)
+	}
+	codes := make(map[string]bool, len(arts))
+	for i, a := range arts {
+		switch {
+		case a.Code == "":
+			return nil, fmt.Errorf("%v: article %d has no code", ErrInvalidArticle, i+1)
+		case codes[a.Code]:
+			return nil, fmt.Errorf("%v: code %q is duplicated", ErrInvalidArticle, a.Code)
+		case a.Price.Amount() < 0:
+			return nil, fmt.Errorf("%v: price of %q is negative", ErrInvalidArticle, a.Code)
+		case a.Price.Currency() != arts[0].Price.Currency():
+			return nil, fmt.Errorf("%v: price of %q is not in %s", ErrInvalidArticle, a.Code, arts[0].Price.Currency())
+		}
+		codes[a.Code] = true
+	}
+	return arts, nil
+}
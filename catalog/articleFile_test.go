@@ -0,0 +1,30 @@
+package catalog
+
+import (
+	"shopping-cart-kata/money"
+	"strings"
+	"testing"
+)
+
+func TestParseArticles(t *testing.T) {
+	arts, err := ParseArticles(strings.NewReader(`[
+		{ "Code": "MUG", "Name": "Coffee Mug", "Price": { "amount": "7.50", "currency": "EUR" } },
+		{ "Code": "VOUCHER", "Name": "Voucher", "Price": { "amount": "5.00", "currency": "EUR" } }
+	]`))
+	if err != nil || len(arts) != 2 || arts[0].Code != "MUG" || arts[0].Price.Cmp(money.MustParse("7.50", "EUR")) != 0 {
+		t.Fatalf("Parsed %v, %v", arts, err)
+	}
+	invalid := []string{
+		`{ "Code": "MUG" }`,
+		`[{ "Code": "MUG", "Color": "white" }]`,
+		`[{ "Name": "Coffee Mug" }]`,
+		`[{ "Code": "MUG" }, { "Code": "MUG" }]`,
+		`[{ "Code": "MUG", "Price": { "amount": "-1.00", "currency": "EUR" } }]`,
+		`[{ "Code": "MUG", "Price": { "amount": "7.50", "currency": "EUR" } }, { "Code": "HAT", "Price": { "amount": "9.00", "currency": "USD" } }]`,
+	}
+	for _, doc := range invalid {
+		if _, err := ParseArticles(strings.NewReader(doc)); err == nil {
+			t.Errorf("No error parsing %s", doc)
+		}
+	}
+}
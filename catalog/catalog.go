@@ -1,24 +1,50 @@
 package catalog
 
-import "sync"
+import (
+	"shopping-cart-kata/money"
+	"sort"
+	"sync"
+	"time"
+)
 
 // Catalog represents a catalog
+// GetPricesAt returns the prices at a past moment: articles added after that moment have their first price
+// Prices are recorded in memory only since RecordedSince, the moment the catalog was created or,
+// once the oldest prices of an article are dropped beyond historyLimit, the oldest price kept
 type Catalog interface {
 	AddArticle(Article) bool
+	UpdatePrice(code string, price money.Money) bool
 	GetArticles() []Article
 	GetArticle(code string) (Article, bool)
-	GetPrices(codes []string) map[string]float64
+	GetPrices(codes []string) map[string]money.Money
+	GetPricesAt(codes []string, at time.Time) map[string]money.Money
+	RecordedSince() time.Time
+}
+
+type pricePoint struct {
+	from  time.Time
+	price money.Money
 }
 
 type catalog struct {
 	sync.RWMutex
 	articles map[string]*Article
+	prices   map[string][]pricePoint
+	since    time.Time
 }
 
+// now is the clock stamping price changes
+var now = time.Now
+
+// historyLimit is the number of prices recorded per article
+var historyLimit = 1000
+
 // NewCatalog creates a new catalog
 func NewCatalog() Catalog {
 	c := new(catalog)
 	c.articles = make(map[string]*Article)
+	c.prices = make(map[string][]pricePoint)
+	c.since = now()
 	return c
 }
 
@@ -30,6 +56,27 @@ func (c *catalog) AddArticle(a Article) bool {
 	}
 	art := a
 	c.articles[art.Code] = &art
+	c.prices[art.Code] = []pricePoint{{from: now(), price: art.Price}}
+	return true
+}
+
+// UpdatePrice changes the price of an article keeping the previous ones
+func (c *catalog) UpdatePrice(code string, price money.Money) bool {
+	c.Lock()
+	defer c.Unlock()
+	art, ok := c.articles[code]
+	if !ok {
+		return false
+	}
+	art.Price = price
+	points := append(c.prices[code], pricePoint{from: now(), price: price})
+	if over := len(points) - historyLimit; over > 0 {
+		points = append([]pricePoint(nil), points[over:]...)
+		if points[0].from.After(c.since) {
+			c.since = points[0].from
+		}
+	}
+	c.prices[code] = points
 	return true
 }
 
@@ -58,10 +105,10 @@ func (c *catalog) GetArticle(code string) (Article, bool) {
 }
 
 // GetPrices returns pairs of article id and price
-func (c *catalog) GetPrices(codes []string) map[string]float64 {
+func (c *catalog) GetPrices(codes []string) map[string]money.Money {
 	c.RLock()
 	defer c.RUnlock()
-	res := make(map[string]float64, len(c.articles))
+	res := make(map[string]money.Money, len(c.articles))
 	for _, code := range codes {
 		if art, ok := c.articles[code]; ok {
 			res[code] = art.Price
@@ -69,3 +116,29 @@ func (c *catalog) GetPrices(codes []string) map[string]float64 {
 	}
 	return res
 }
+
+// RecordedSince returns the moment since when the prices are recorded
+func (c *catalog) RecordedSince() time.Time {
+	c.RLock()
+	defer c.RUnlock()
+	return c.since
+}
+
+// GetPricesAt returns pairs of article id and price at the given moment
+func (c *catalog) GetPricesAt(codes []string, at time.Time) map[string]money.Money {
+	c.RLock()
+	defer c.RUnlock()
+	res := make(map[string]money.Money, len(codes))
+	for _, code := range codes {
+		points, ok := c.prices[code]
+		if !ok {
+			continue
+		}
+		i := sort.Search(len(points), func(i int) bool { return points[i].from.After(at) })
+		if i > 0 {
+			i--
+		}
+		res[code] = points[i].price
+	}
+	return res
+}
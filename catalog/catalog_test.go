@@ -1,12 +1,16 @@
 package catalog
 
-import "testing"
+import (
+	"shopping-cart-kata/money"
+	"testing"
+	"time"
+)
 
 func TestRetrievePrices(t *testing.T) {
 	cat := NewCatalog()
-	cat.AddArticle(Article{Code: "VOUCHER", Name: "Voucher", Price: 5.0})
-	cat.AddArticle(Article{Code: "TSHIRT", Name: "T-Shirt", Price: 20.0})
-	cat.AddArticle(Article{Code: "MUG", Name: "Coffee Mug", Price: 7.5})
+	cat.AddArticle(Article{Code: "VOUCHER", Name: "Voucher", Price: money.MustParse("5.00", "EUR")})
+	cat.AddArticle(Article{Code: "TSHIRT", Name: "T-Shirt", Price: money.MustParse("20.00", "EUR")})
+	cat.AddArticle(Article{Code: "MUG", Name: "Coffee Mug", Price: money.MustParse("7.50", "EUR")})
 	codes := []string{"MUG", "VOUCHER"}
 	res := cat.GetPrices(codes)
 	var missing []string
@@ -19,3 +23,51 @@ func TestRetrievePrices(t *testing.T) {
 		t.Errorf("Price not retrieved for article codes {%v}", missing)
 	}
 }
+
+func TestRetrievePricesAt(t *testing.T) {
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	cat := NewCatalog()
+	if !cat.RecordedSince().Equal(clock) {
+		t.Errorf("Prices recorded since %v instead of the catalog creation", cat.RecordedSince())
+	}
+	cat.AddArticle(Article{Code: "MUG", Name: "Coffee Mug", Price: money.MustParse("7.50", "EUR")})
+	clock = clock.Add(time.Hour)
+	if !cat.UpdatePrice("MUG", money.MustParse("8.00", "EUR")) || cat.UpdatePrice("CUP", money.MustParse("1.00", "EUR")) {
+		t.Fatal("Price of existing article not updated or of missing article updated")
+	}
+	for at, exp := range map[time.Duration]string{-time.Hour: "7.50", 30 * time.Minute: "7.50", 2 * time.Hour: "8.00"} {
+		res := cat.GetPricesAt([]string{"MUG"}, clock.Add(at-time.Hour))
+		if res["MUG"] != money.MustParse(exp, "EUR") {
+			t.Errorf("Price %s at %v instead of %s", res["MUG"], at, exp)
+		}
+	}
+	if a, _ := cat.GetArticle("MUG"); a.Price != money.MustParse("8.00", "EUR") {
+		t.Errorf("Article price %s instead of 8.00 EUR", a.Price)
+	}
+}
+
+func TestPriceHistoryIsBounded(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := start
+	now = func() time.Time { return clock }
+	limit := historyLimit
+	defer func() { now, historyLimit = time.Now, limit }()
+	historyLimit = 3
+	cat := NewCatalog()
+	cat.AddArticle(Article{Code: "MUG", Name: "Coffee Mug", Price: money.MustParse("7.50", "EUR")})
+	for i := 1; i <= 5; i++ {
+		clock = clock.Add(time.Hour)
+		cat.UpdatePrice("MUG", money.New(int64(750+i*10), "EUR"))
+	}
+	if h := cat.(*catalog).prices["MUG"]; len(h) != historyLimit {
+		t.Errorf("History of %d prices instead of %d", len(h), historyLimit)
+	}
+	if since := cat.RecordedSince(); !since.Equal(clock.Add(-2 * time.Hour)) {
+		t.Errorf("Recorded since %v instead of the oldest price kept", since)
+	}
+	if p := cat.GetPricesAt([]string{"MUG"}, clock)["MUG"]; p != money.MustParse("8.00", "EUR") {
+		t.Errorf("Price %s instead of the last one", p)
+	}
+}
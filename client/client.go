@@ -0,0 +1,218 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"shopping-cart-kata/catalog"
+	"strings"
+	"time"
+)
+
+// Client calls the cart API
+// Changes are conditional to the given ETag when not empty, GetCart answers ErrNotModified when the cart still matches it
+type Client interface {
+	CreateCart(ctx context.Context) (Cart, error)
+	GetCart(ctx context.Context, id string, etag string) (Cart, error)
+	AddArticle(ctx context.Context, id string, etag string, code string, qty int) (ItemChange, error)
+	SetArticleQuantity(ctx context.Context, id string, etag string, code string, qty int) (ItemChange, error)
+	RemoveArticle(ctx context.Context, id string, etag string, code string) error
+	DeleteCart(ctx context.Context, id string, etag string) error
+	GetArticles(ctx context.Context) ([]catalog.Article, error)
+}
+
+// Options tunes the calls
+// Idempotent calls failing for the network, a server error or a concurrent modification are attempted MaxAttempts times
+// waiting from RetryMin doubling up to RetryMax
+type Options struct {
+	HTTPClient  *http.Client
+	MaxAttempts int
+	RetryMin    time.Duration
+	RetryMax    time.Duration
+	// Payload, when set, receives the body of every successful response as received
+	Payload func(body []byte)
+}
+
+// DefaultOptions are the options used by NewClient when not given
+var DefaultOptions = Options{HTTPClient: http.DefaultClient, MaxAttempts: 3, RetryMin: 100 * time.Millisecond, RetryMax: 2 * time.Second}
+
+type client struct {
+	baseURL string
+	opts    Options
+}
+
+// NewClient creates a client of the cart API at baseURL, e.g. http://127.0.0.1:8000
+func NewClient(baseURL string, opts Options) Client {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = DefaultOptions.HTTPClient
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultOptions.MaxAttempts
+	}
+	if opts.RetryMin <= 0 {
+		opts.RetryMin = DefaultOptions.RetryMin
+	}
+	if opts.RetryMax < opts.RetryMin {
+		opts.RetryMax = opts.RetryMin
+	}
+	return &client{baseURL: strings.TrimRight(baseURL, "/"), opts: opts}
+}
+
+// CreateCart creates an empty cart
+func (c *client) CreateCart(ctx context.Context) (Cart, error) {
+	var crt Cart
+	h, err := c.do(ctx, http.MethodPost, "/carts", nil, "", &crt)
+	if err == nil {
+		setValidators(&crt, h)
+	}
+	return crt, err
+}
+
+// GetCart retrieves a cart unless it still matches etag
+func (c *client) GetCart(ctx context.Context, id string, etag string) (Cart, error) {
+	var crt Cart
+	h, err := c.do(ctx, http.MethodGet, cartPath(id), nil, etag, &crt)
+	if err == nil {
+		setValidators(&crt, h)
+	}
+	return crt, err
+}
+
+// AddArticle adds an article to a cart: ErrConflict if it is already there
+func (c *client) AddArticle(ctx context.Context, id string, etag string, code string, qty int) (ItemChange, error) {
+	var ic ItemChange
+	_, err := c.do(ctx, http.MethodPost, cartPath(id)+"/items", ItemChange{ID: code, Quantity: qty}, etag, &ic)
+	return ic, err
+}
+
+// SetArticleQuantity sets the quantity of an article in a cart
+func (c *client) SetArticleQuantity(ctx context.Context, id string, etag string, code string, qty int) (ItemChange, error) {
+	var ic ItemChange
+	_, err := c.do(ctx, http.MethodPut, cartPath(id)+"/items", ItemChange{ID: code, Quantity: qty}, etag, &ic)
+	return ic, err
+}
+
+// RemoveArticle removes an article from a cart
+func (c *client) RemoveArticle(ctx context.Context, id string, etag string, code string) error {
+	_, err := c.do(ctx, http.MethodDelete, cartPath(id)+"/items/"+url.PathEscape(code), nil, etag, nil)
+	return err
+}
+
+// DeleteCart deletes a cart
+func (c *client) DeleteCart(ctx context.Context, id string, etag string) error {
+	_, err := c.do(ctx, http.MethodDelete, cartPath(id), nil, etag, nil)
+	return err
+}
+
+// GetArticles retrieves the catalog
+func (c *client) GetArticles(ctx context.Context) ([]catalog.Article, error) {
+	var arts []catalog.Article
+	_, err := c.do(ctx, http.MethodGet, "/articles", nil, "", &arts)
+	return arts, err
+}
+
+// do performs a call retrying the idempotent ones and decodes a successful response payload into out
+// The etag is sent in If-None-Match for GET and in If-Match for changes
+func (c *client) do(ctx context.Context, method string, path string, in interface{}, etag string, out interface{}) (http.Header, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return nil, err
+		}
+	}
+	idempotent := method != http.MethodPost
+	wait := c.opts.RetryMin
+	for attempt := 1; ; attempt++ {
+		h, err := c.once(ctx, method, path, body, etag, out)
+		if !idempotent || attempt == c.opts.MaxAttempts || !retriable(ctx, err) {
+			return h, err
+		}
+		if sleep(ctx, wait) != nil {
+			return h, err
+		}
+		wait *= 2
+		if wait > c.opts.RetryMax {
+			wait = c.opts.RetryMax
+		}
+	}
+}
+
+func (c *client) once(ctx context.Context, method string, path string, body []byte, etag string, out interface{}) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if etag != "" && method == http.MethodGet {
+		req.Header.Set("If-None-Match", etag)
+	} else if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	rb, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.Header, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.Header, newAPIError(resp.StatusCode, rb)
+	}
+	if c.opts.Payload != nil {
+		c.opts.Payload(rb)
+	}
+	if out != nil && len(rb) > 0 {
+		if err := json.Unmarshal(rb, out); err != nil {
+			return resp.Header, err
+		}
+	}
+	return resp.Header, nil
+}
+
+// retriable tells if a failed call may succeed when attempted again
+func retriable(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		_, isNetErr := err.(*url.Error)
+		return isNetErr
+	}
+	switch apiErr.StatusCode {
+	case http.StatusConflict, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func setValidators(c *Cart, h http.Header) {
+	c.ETag = h.Get("ETag")
+	if t, err := http.ParseTime(h.Get("Last-Modified")); err == nil {
+		c.LastModified = t
+	}
+}
+
+func cartPath(id string) string {
+	return "/carts/" + url.PathEscape(id)
+}
+
+// sleep waits for the duration returning the context error if done before
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var fastRetries = Options{MaxAttempts: 3, RetryMin: time.Millisecond, RetryMax: 2 * time.Millisecond}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		exp    error
+		msg    string
+	}{
+		{http.StatusNotFound, "", ErrNotFound, ""},
+		{http.StatusGone, `{"error":"The cart expired"}`, ErrGone, "The cart expired"},
+		{http.StatusPreconditionFailed, "", ErrPreconditionFailed, ""},
+		{http.StatusPreconditionRequired, `{"status":428,"detail":"Send If-Match"}`, ErrPreconditionRequired, "Send If-Match"},
+		{http.StatusConflict, `{"id":"MUG","quantity":1}`, ErrConflict, ""},
+		{http.StatusUnprocessableEntity, `{"error":"The article does not exist"}`, ErrValidation, "The article does not exist"},
+		{http.StatusBadRequest, "", ErrValidation, ""},
+		{http.StatusNotImplemented, "", ErrServer, ""},
+		{http.StatusTeapot, "", ErrUnexpectedStatus, ""},
+	}
+	for _, test := range tests {
+		hf := func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}
+		ts := httptest.NewServer(http.HandlerFunc(hf))
+		c := NewClient(ts.URL, fastRetries)
+		_, err := c.AddArticle(context.Background(), "ABC", "", "MUG", 1)
+		apiErr, ok := err.(*APIError)
+		if !ok || !errors.Is(err, test.exp) || apiErr.StatusCode != test.status || apiErr.Message != test.msg {
+			t.Errorf("Status %d answered with error %#v instead of %v with message %q", test.status, err, test.exp, test.msg)
+		}
+		ts.Close()
+	}
+}
+
+func TestRetryIdempotentCalls(t *testing.T) {
+	var calls int32
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1)%3 != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("ETag", `"1-0a1b2c3d"`)
+		w.Write([]byte(`{"id":"ABC","items":[]}`))
+	}
+	ts := httptest.NewServer(http.HandlerFunc(hf))
+	defer ts.Close()
+	c := NewClient(ts.URL, fastRetries)
+	crt, err := c.GetCart(context.Background(), "ABC", "")
+	if err != nil || crt.ID != "ABC" || crt.ETag != `"1-0a1b2c3d"` || calls != 3 {
+		t.Errorf("Cart %+v, %v after %d calls instead of success after 3", crt, err, calls)
+	}
+	calls = 0
+	if _, err := c.CreateCart(context.Background()); !errors.Is(err, ErrServer) || calls != 1 {
+		t.Errorf("Creation retried %d times with error %v", calls, err)
+	}
+}
+
+func TestRetryNetworkErrors(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	baseURL := ts.URL
+	ts.Close()
+	c := NewClient(baseURL, fastRetries)
+	err := c.DeleteCart(context.Background(), "ABC", "")
+	if _, ok := err.(*APIError); err == nil || ok {
+		t.Errorf("Unreachable server answered with error %v", err)
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(hf))
+	defer ts.Close()
+	c := NewClient(ts.URL, Options{MaxAttempts: 100, RetryMin: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.GetArticles(ctx); !errors.Is(err, ErrServer) || time.Since(start) > time.Second {
+		t.Errorf("Cancelled call ended after %v with error %v", time.Since(start), err)
+	}
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrNotFound when the cart, or the article in the cart, does not exist
+var ErrNotFound = errors.New("Not found")
+
+// ErrGone when the cart expired
+var ErrGone = errors.New("The cart expired")
+
+// ErrNotModified when the cart still matches the ETag given to GetCart
+var ErrNotModified = errors.New("The cart was not modified")
+
+// ErrPreconditionFailed when the cart does not match the ETag given to a change
+var ErrPreconditionFailed = errors.New("The cart does not match the ETag")
+
+// ErrPreconditionRequired when the server accepts only changes with an ETag
+var ErrPreconditionRequired = errors.New("An ETag is required to change the cart")
+
+// ErrConflict when the article is already in the cart or the cart is being modified concurrently
+var ErrConflict = errors.New("Conflict with the state of the cart")
+
+// ErrValidation when the request is invalid, e.g. the article does not exist or the quantity is not positive
+var ErrValidation = errors.New("Invalid request")
+
+// ErrServer when the server fails to handle the request
+var ErrServer = errors.New("Server error")
+
+// ErrUnexpectedStatus when the server answers with a status not foreseen by the API
+var ErrUnexpectedStatus = errors.New("Unexpected response status")
+
+var statusErrors = map[int]error{
+	http.StatusNotModified:          ErrNotModified,
+	http.StatusBadRequest:           ErrValidation,
+	http.StatusNotFound:             ErrNotFound,
+	http.StatusConflict:             ErrConflict,
+	http.StatusGone:                 ErrGone,
+	http.StatusPreconditionFailed:   ErrPreconditionFailed,
+	http.StatusUnprocessableEntity:  ErrValidation,
+	http.StatusPreconditionRequired: ErrPreconditionRequired,
+}
+
+// APIError is a response of the API other than success
+// It wraps the error of its condition so that errors.Is(err, ErrNotFound) tells if the cart was not found
+type APIError struct {
+	StatusCode int
+	Message    string
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%v (status %d)", e.Err, e.StatusCode)
+	}
+	return fmt.Sprintf("%v (status %d): %s", e.Err, e.StatusCode, e.Message)
+}
+
+// Unwrap returns the error of the condition
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// newAPIError reads the message of an error or problem details body
+func newAPIError(status int, body []byte) *APIError {
+	var b struct {
+		Error  string `json:"error"`
+		Detail string `json:"detail"`
+	}
+	json.Unmarshal(body, &b)
+	e := &APIError{StatusCode: status, Message: b.Error, Err: statusErrors[status]}
+	if e.Message == "" {
+		e.Message = b.Detail
+	}
+	if e.Err == nil && status >= 500 {
+		e.Err = ErrServer
+	}
+	if e.Err == nil {
+		e.Err = ErrUnexpectedStatus
+	}
+	return e
+}
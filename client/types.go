@@ -0,0 +1,44 @@
+package client
+
+import (
+	"shopping-cart-kata/money"
+	"time"
+)
+
+// Cart is a priced cart with the validators of its representation
+type Cart struct {
+	ID              string             `json:"id"`
+	Subtotal        money.Money        `json:"subTotal"`
+	Items           []Item             `json:"items"`
+	Promotions      []AppliedPromotion `json:"promotions"`
+	URL             string             `json:"url"`
+	Degraded        bool               `json:"degraded"`
+	DegradedReasons []string           `json:"degradedReasons,omitempty"`
+	ETag            string             `json:"-"`
+	LastModified    time.Time          `json:"-"`
+}
+
+// Item is an article in a cart with its prices
+type Item struct {
+	ID         string      `json:"id"`
+	Quantity   int         `json:"quantity"`
+	UnitPrice  money.Money `json:"unitPrice"`
+	TotalPrice money.Money `json:"totalPrice"`
+}
+
+// AppliedPromotion is a saving granted by a promotion rule
+type AppliedPromotion struct {
+	RuleID   int64       `json:"ruleId"`
+	RuleCode string      `json:"ruleCode,omitempty"`
+	Label    string      `json:"label,omitempty"`
+	ItemID   string      `json:"itemId,omitempty"`
+	Quantity int         `json:"quantity"`
+	Saving   money.Money `json:"saving"`
+}
+
+// ItemChange is an article added to a cart or whose quantity was set
+type ItemChange struct {
+	ID       string `json:"id"`
+	Quantity int    `json:"quantity"`
+	CartURL  string `json:"cartUrl,omitempty"`
+}
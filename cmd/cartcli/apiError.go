@@ -1,5 +0,0 @@
-package main
-
-type apiError struct {
-	Msg string `json:"error"`
-}
@@ -1,141 +1,143 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
-	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
+	"shopping-cart-kata/client"
 )
 
-// ErrReqPreparation error on request preparation
-var ErrReqPreparation = errors.New("Error on request preparation")
-
-// ErrReqExecution error on request execution
-var ErrReqExecution = errors.New("Error on request execution")
-
-// ErrRespRead error reading the response body
-var ErrRespRead = errors.New("Error reading response body")
-
-// ErrRespDecode error decoding the response body
-var ErrRespDecode = errors.New("Error decoding the response body")
-
 // App is the client application
 type App struct {
 	BaseURL    string
 	HTTPClient http.Client
+	// Output is the format of the command results, text when empty
+	Output string
+	// Out receives the command results, the standard output when nil
+	Out io.Writer
+
+	conditional bool
+	etags       map[string]string
+	payload     []byte
 }
 
-func (a *App) createCart() (cart, int, string, error) {
-	var c cart
-	url := fmt.Sprintf("%s/carts", a.BaseURL)
-	req, err := http.NewRequest("POST", url, nil)
+// client returns a client of the cart API at the base URL keeping the payload of the last successful response
+func (a *App) client() client.Client {
+	return client.NewClient(a.BaseURL, client.Options{HTTPClient: &a.HTTPClient, Payload: func(body []byte) { a.payload = body }})
+}
+
+func (a *App) createCart() (cart, error) {
+	c, err := a.client().CreateCart(context.Background())
 	if err != nil {
-		return c, 0, "", ErrReqPreparation
+		return cart{}, err
 	}
-	code, msg, err := performReq(a, req, &c)
-	return c, code, msg, err
+	a.rememberETag(c.ID, c.ETag)
+	return fromClientCart(c), nil
 }
 
-func (a *App) addOrUpdateArticle(id string, etag string, aCod string, aQty int) (article, int, string, error) {
-	art, code, msg, err := a.addArticleToCart(id, etag, aCod, aQty)
-	if code != http.StatusConflict {
-		return art, code, msg, err
-	}
-	return a.setArticleQuantity(id, etag, aCod, art.Quantity+aQty)
+// addOrUpdateArticle adds the article to the cart or, when already there, increases its quantity
+func (a *App) addOrUpdateArticle(id string, etag string, aCod string, aQty int) (article, error) {
+	art, err := a.addArticleToCart(id, etag, aCod, aQty)
+	if !errors.Is(err, client.ErrConflict) {
+		return art, err
+	}
+	c, getErr := a.getCart(id, "")
+	if getErr != nil {
+		return art, err
+	}
+	for _, i := range c.Items {
+		if i.ID == aCod {
+			return a.setArticleQuantity(id, etag, aCod, i.Quantity+aQty)
+		}
+	}
+	return art, err
 }
 
-func (a *App) addArticleToCart(id string, etag string, aCod string, aQty int) (article, int, string, error) {
-	var art article
-	url := fmt.Sprintf("%s/carts/%s/items", a.BaseURL, id)
-	j, err := json.Marshal(article{ID: aCod, Quantity: aQty})
-	if err != nil {
-		return art, 0, "", ErrReqPreparation
-	}
-	b := bytes.NewBuffer(j)
-	req, err := http.NewRequest("POST", url, b)
-	if err != nil {
-		return art, 0, "", ErrReqPreparation
-	}
-	if etag != "" {
-		req.Header.Set("If-Match", etag)
-	}
-	code, msg, err := performReq(a, req, &art)
-	return art, code, msg, err
+func (a *App) addArticleToCart(id string, etag string, aCod string, aQty int) (article, error) {
+	var ic client.ItemChange
+	err := a.performChange(id, etag, func(etag string) error {
+		var err error
+		ic, err = a.client().AddArticle(context.Background(), id, etag, aCod, aQty)
+		return err
+	})
+	return article{ID: ic.ID, Quantity: ic.Quantity}, err
 }
 
-func (a *App) setArticleQuantity(id string, etag string, aCod string, aQty int) (article, int, string, error) {
-	var art article
-	url := fmt.Sprintf("%s/carts/%s/items", a.BaseURL, id)
-	j, err := json.Marshal(article{ID: aCod, Quantity: aQty})
-	if err != nil {
-		return art, 0, "", ErrReqPreparation
-	}
-	b := bytes.NewBuffer(j)
-	req, err := http.NewRequest("PUT", url, b)
-	if err != nil {
-		return art, 0, "", ErrReqPreparation
-	}
-	if etag != "" {
-		req.Header.Set("If-Match", etag)
-	}
-	code, msg, err := performReq(a, req, &art)
-	return art, code, msg, err
+func (a *App) setArticleQuantity(id string, etag string, aCod string, aQty int) (article, error) {
+	var ic client.ItemChange
+	err := a.performChange(id, etag, func(etag string) error {
+		var err error
+		ic, err = a.client().SetArticleQuantity(context.Background(), id, etag, aCod, aQty)
+		return err
+	})
+	return article{ID: ic.ID, Quantity: ic.Quantity}, err
 }
 
-func (a *App) getCart(id string, etag string) (cart, int, string, error) {
-	var c cart
-	url := fmt.Sprintf("%s/carts/%s", a.BaseURL, id)
-	req, err := http.NewRequest("GET", url, nil)
+func (a *App) getCart(id string, etag string) (cart, error) {
+	c, err := a.client().GetCart(context.Background(), id, etag)
 	if err != nil {
-		return c, 0, "", ErrReqPreparation
-	}
-	if etag != "" {
-		req.Header.Set("If-None-Match", etag)
+		return cart{}, err
 	}
-	code, msg, err := performReq(a, req, &c)
-	return c, code, msg, err
+	a.rememberETag(id, c.ETag)
+	return fromClientCart(c), nil
 }
 
-func (a *App) deleteCart(id string, etag string) (int, string, error) {
-	url := fmt.Sprintf("%s/carts/%s", a.BaseURL, id)
-	req, err := http.NewRequest("DELETE", url, nil)
-	if err != nil {
-		return 0, "", ErrReqPreparation
-	}
-	if etag != "" {
-		req.Header.Set("If-Match", etag)
-	}
-	return performReq(a, req, nil)
+func (a *App) deleteCart(id string, etag string) error {
+	return a.performChange(id, etag, func(etag string) error {
+		return a.client().DeleteCart(context.Background(), id, etag)
+	})
 }
 
-func performReq(a *App, req *http.Request, i interface{}) (int, string, error) {
-	resp, err := a.HTTPClient.Do(req)
-	if err != nil {
-		return resp.StatusCode, "", ErrReqExecution
-	}
+func (a *App) removeArticleFromCart(id string, etag string, aCod string) error {
+	return a.performChange(id, etag, func(etag string) error {
+		return a.client().RemoveArticle(context.Background(), id, etag, aCod)
+	})
+}
 
-	defer resp.Body.Close()
-	rb, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return resp.StatusCode, "", ErrRespRead
-	}
-	if len(rb) == 0 {
-		return resp.StatusCode, "", nil
-	}
-	var e apiError
-	err1 := json.NewDecoder(bytes.NewReader(rb)).Decode(&e)
-	var err2 error
-	if i != nil {
-		err2 = json.NewDecoder(bytes.NewReader(rb)).Decode(&i)
+// performChange performs a change of the cart conditional to etag when given
+// Once the server requires conditional changes, answering 428, changes without an ETag are sent with the last one seen for the cart,
+// fetching the cart when there is none
+func (a *App) performChange(id string, etag string, change func(etag string) error) error {
+	if etag == "" && a.conditional {
+		if etag = a.etags[id]; etag == "" {
+			c, err := a.getCart(id, "")
+			if err != nil {
+				return err
+			}
+			etag = c.ETag
+		}
+	}
+	err := change(etag)
+	if errors.Is(err, client.ErrPreconditionRequired) && !a.conditional {
+		a.conditional = true
+		return a.performChange(id, "", change)
+	}
+	if err == nil || errors.Is(err, client.ErrPreconditionFailed) {
+		a.forgetETag(id)
+	}
+	return err
+}
+
+func (a *App) rememberETag(id string, etag string) {
+	if etag == "" {
+		return
 	}
-	if err1 != nil && err2 != nil {
-		return resp.StatusCode, "", ErrRespDecode
+	if a.etags == nil {
+		a.etags = make(map[string]string)
 	}
-	if etag := resp.Header.Get("ETag"); err2 == nil && len(etag) != 0 {
-		c := i.(*cart)
-		c.ETag = etag
+	a.etags[id] = etag
+}
+
+func (a *App) forgetETag(id string) {
+	delete(a.etags, id)
+}
+
+// apiMessage returns the message the API answered with, empty if none
+func apiMessage(err error) string {
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Message
 	}
-	return resp.StatusCode, e.Msg, nil
+	return ""
 }
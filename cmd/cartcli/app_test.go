@@ -2,20 +2,24 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"shopping-cart-kata/client"
+	"shopping-cart-kata/money"
 	"testing"
 )
 
 func TestAPIErrors(t *testing.T) {
 	const noMsg = ""
 	someMsg := "Some message"
-	apiErrors(t, http.StatusInternalServerError, someMsg)
-	apiErrors(t, http.StatusBadRequest, someMsg)
-	apiErrors(t, http.StatusUnprocessableEntity, someMsg)
-	apiErrors(t, http.StatusConflict, someMsg)
-	apiErrors(t, http.StatusNotFound, noMsg)
-	apiErrors(t, http.StatusPreconditionFailed, noMsg)
+	apiErrors(t, http.StatusInternalServerError, someMsg, exitServerError)
+	apiErrors(t, http.StatusBadRequest, someMsg, exitInvalid)
+	apiErrors(t, http.StatusUnprocessableEntity, someMsg, exitInvalid)
+	apiErrors(t, http.StatusConflict, someMsg, exitConflict)
+	apiErrors(t, http.StatusNotFound, noMsg, exitNotFound)
+	apiErrors(t, http.StatusPreconditionFailed, noMsg, exitPrecondFail)
 }
 
 func TestCreateCartSuccess(t *testing.T) {
@@ -28,10 +32,10 @@ func TestCreateCartSuccess(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(hf))
 	defer ts.Close()
 	a := &App{BaseURL: ts.URL, HTTPClient: *ts.Client()}
-	c, sc, m, err := a.createCart()
-	if c.String() != crt.String() || sc != sCod || err != nil {
-		t.Errorf("EXPECTED\nCart: %s\nStatus code: %d\nMessage: %s\n\n", crt, sCod, "")
-		t.Errorf("GOT\nCart: %s\nStatus code: %d\nMessage: %s\nError: %s", c, sc, m, err)
+	c, err := a.createCart()
+	if c.String() != crt.String() || err != nil {
+		t.Errorf("EXPECTED\nCart: %s\n\n", crt)
+		t.Errorf("GOT\nCart: %s\nError: %v", c, err)
 	}
 }
 
@@ -71,7 +75,24 @@ func TestDeleteCartSuccessWithIfMatchEtag(t *testing.T) {
 	deleteCartSuccess(t, `W/"123456789"`)
 }
 
-func apiErrors(t *testing.T, sCod int, msg string) {
+func TestRemoveArticleFromCartSuccess(t *testing.T) {
+	sCod := http.StatusNoContent
+	var path string
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.WriteHeader(sCod)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(hf))
+	defer ts.Close()
+	a := &App{BaseURL: ts.URL, HTTPClient: *ts.Client()}
+	err := a.removeArticleFromCart("ABC", "", "MUG")
+	if err != nil || path != "/carts/ABC/items/MUG" {
+		t.Errorf("EXPECTED\nPath: /carts/ABC/items/MUG\n\n")
+		t.Errorf("GOT\nPath: %s\nError: %v", path, err)
+	}
+}
+
+func apiErrors(t *testing.T, sCod int, msg string, exit int) {
 	hf := func(w http.ResponseWriter, r *http.Request) {
 		if msg != "" {
 			respondWithError(w, sCod, msg)
@@ -82,27 +103,19 @@ func apiErrors(t *testing.T, sCod int, msg string) {
 	ts := httptest.NewServer(http.HandlerFunc(hf))
 	defer ts.Close()
 	a := &App{BaseURL: ts.URL, HTTPClient: *ts.Client()}
-
-	c, sc, m, err := a.createCart()
-	if sc != sCod || m != msg || err != nil {
-		t.Errorf("EXPECTED\nStatus code: %d\nMessage: %s\n\n", sCod, msg)
-		t.Errorf("GOT\nCart: %s\nStatus code: %d\nMessage: %s\nError: %s", c, sc, m, err)
-	}
-	art, sc, m, err := a.addArticleToCart("A", "B", "C", 1)
-	if sc != sCod || m != msg || err != nil {
-		t.Errorf("EXPECTED\nStatus code: %d\nMessage: %s\n\n", sCod, msg)
-		t.Errorf("GOT\nArticle: %s\nStatus code: %d\nMessage: %s\nError: %s", art, sc, m, err)
-	}
-	crt, sc, m, err := a.getCart("A", "B")
-	if sc != sCod || m != msg || err != nil {
-		t.Errorf("EXPECTED\nStatus code: %d\nMessage: %s\n\n", sCod, msg)
-		t.Errorf("GOT\nArticle: %s\nStatus code: %d\nMessage: %s\nError: %s", crt, sc, m, err)
-	}
-	sc, m, err = a.deleteCart("A", "B")
-	if sc != sCod || m != msg || err != nil {
-		t.Errorf("EXPECTED\nStatus code: %d\nMessage: %s\n\n", sCod, msg)
-		t.Errorf("GOT\nArticle: %s\nStatus code: %d\nMessage: %s\nError: %s", art, sc, m, err)
+	check := func(op string, err error) {
+		var apiErr *client.APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != sCod || apiMessage(err) != msg || exitCode(err) != exit {
+			t.Errorf("%s: %v instead of status %d with message %q exiting with %d", op, err, sCod, msg, exit)
+		}
 	}
+	_, err := a.createCart()
+	check("Create", err)
+	_, err = a.addArticleToCart("A", "B", "C", 1)
+	check("Add", err)
+	_, err = a.getCart("A", "B")
+	check("Get", err)
+	check("Delete", a.deleteCart("A", "B"))
 }
 
 func addArticleToCartSuccess(t *testing.T, reqEtag string) {
@@ -114,10 +127,10 @@ func addArticleToCartSuccess(t *testing.T, reqEtag string) {
 	ts := httptest.NewServer(http.HandlerFunc(hf))
 	defer ts.Close()
 	a := &App{BaseURL: ts.URL, HTTPClient: *ts.Client()}
-	ar, sc, m, err := a.addArticleToCart("A", "B", art.ID, art.Quantity)
-	if ar.String() != art.String() || sc != sCod || err != nil {
-		t.Errorf("EXPECTED\nCart: %s\nStatus code: %d\nMessage: %s\n\n", art, sCod, "")
-		t.Errorf("GOT\nCart: %s\nStatus code: %d\nMessage: %s\nError: %s", ar, sc, m, err)
+	ar, err := a.addArticleToCart("A", reqEtag, art.ID, art.Quantity)
+	if ar.String() != art.String() || err != nil {
+		t.Errorf("EXPECTED\nArticle: %s\n\n", art)
+		t.Errorf("GOT\nArticle: %s\nError: %v", ar, err)
 	}
 }
 
@@ -130,65 +143,67 @@ func setArticleQtySuccess(t *testing.T, reqEtag string) {
 	ts := httptest.NewServer(http.HandlerFunc(hf))
 	defer ts.Close()
 	a := &App{BaseURL: ts.URL, HTTPClient: *ts.Client()}
-	ar, sc, m, err := a.setArticleQuantity("A", "B", art.ID, art.Quantity)
-	if ar.String() != art.String() || sc != sCod || err != nil {
-		t.Errorf("EXPECTED\nCart: %s\nStatus code: %d\nMessage: %s\n\n", art, sCod, "")
-		t.Errorf("GOT\nCart: %s\nStatus code: %d\nMessage: %s\nError: %s", ar, sc, m, err)
+	ar, err := a.setArticleQuantity("A", reqEtag, art.ID, art.Quantity)
+	if ar.String() != art.String() || err != nil {
+		t.Errorf("EXPECTED\nArticle: %s\n\n", art)
+		t.Errorf("GOT\nArticle: %s\nError: %v", ar, err)
 	}
 }
 
-func addOrUpdateArticle(t *testing.T, reqEtag string) {
-	counter := 0
-	sCod := http.StatusOK
-	art := article{ID: "A", Quantity: 2}
-	expArt := article{ID: art.ID, Quantity: 4}
+func TestAddOrUpdateArticle(t *testing.T) {
+	var put article
 	hf := func(w http.ResponseWriter, r *http.Request) {
-		if counter == 0 {
-			respondWithPayload(w, http.StatusConflict, art, "")
-			counter++
-			return
+		switch r.Method {
+		case "POST":
+			respondWithPayload(w, http.StatusConflict, article{ID: "A", Quantity: 2}, "")
+		case "GET":
+			respondWithPayload(w, http.StatusOK, cart{ID: "B", Items: []item{{ID: "A", Quantity: 3}}}, `"2-0a1b2c3d"`)
+		case "PUT":
+			json.NewDecoder(r.Body).Decode(&put)
+			respondWithPayload(w, http.StatusOK, put, "")
 		}
-		respondWithPayload(w, http.StatusOK, art, "")
 	}
 	ts := httptest.NewServer(http.HandlerFunc(hf))
 	defer ts.Close()
 	a := &App{BaseURL: ts.URL, HTTPClient: *ts.Client()}
-	ar, sc, m, err := a.addOrUpdateArticle("A", "B", art.ID, art.Quantity)
-	if ar.String() != expArt.String() || sc != sCod || err != nil {
-		t.Errorf("EXPECTED\nCart: %s\nStatus code: %d\nMessage: %s\n\n", art, sCod, "")
-		t.Errorf("GOT\nCart: %s\nStatus code: %d\nMessage: %s\nError: %s", ar, sc, m, err)
+	exp := article{ID: "A", Quantity: 5}
+	if ar, err := a.addOrUpdateArticle("B", "", "A", 2); ar.String() != exp.String() || put.String() != exp.String() || err != nil {
+		t.Errorf("Adding an article already in the cart set %s returning %s, %v instead of %s", put, ar, err, exp)
 	}
 }
 
 func getCartSuccess(t *testing.T, reqEtag string) {
 	respEtag := `W/"123456789"`
-	sCod := http.StatusOK
 	crt := cart{
 		ID:       "ABC",
-		Subtotal: 30,
+		Subtotal: money.New(3000, "EUR"),
 		Items: []item{
-			item{ID: "A", Quantity: 2, UnitPrice: 10, TotalPrice: 20},
-			item{ID: "B", Quantity: 5, UnitPrice: 2, TotalPrice: 10},
+			item{ID: "A", Quantity: 2, UnitPrice: money.New(1000, "EUR"), TotalPrice: money.New(2000, "EUR")},
+			item{ID: "B", Quantity: 5, UnitPrice: money.New(200, "EUR"), TotalPrice: money.New(1000, "EUR")},
 		},
 		URL:  "http://127.0.0.1/carts/ABC",
 		ETag: respEtag,
 	}
 	hf := func(w http.ResponseWriter, r *http.Request) {
 		if reqEtag != respEtag {
-			respondWithPayload(w, sCod, crt, respEtag)
+			respondWithPayload(w, http.StatusOK, crt, respEtag)
 		} else {
-			sCod = http.StatusNotModified
-			crt = *new(cart)
 			w.WriteHeader(http.StatusNotModified)
 		}
 	}
 	ts := httptest.NewServer(http.HandlerFunc(hf))
 	defer ts.Close()
 	a := &App{BaseURL: ts.URL, HTTPClient: *ts.Client()}
-	c, sc, m, err := a.getCart(crt.ID, reqEtag)
-	if c.String() != crt.String() || sc != sCod || err != nil {
-		t.Errorf("EXPECTED\nCart: %s\nStatus code: %d\nMessage: %s\n\n", crt, sCod, "")
-		t.Errorf("GOT\nCart: %s\nStatus code: %d\nMessage: %s\nError: %s", c, sc, m, err)
+	c, err := a.getCart(crt.ID, reqEtag)
+	if reqEtag == respEtag {
+		if !errors.Is(err, client.ErrNotModified) {
+			t.Errorf("Getting the cart with its ETag: %s, %v instead of %v", c, err, client.ErrNotModified)
+		}
+		return
+	}
+	if c.String() != crt.String() || err != nil {
+		t.Errorf("EXPECTED\nCart: %s\n\n", crt)
+		t.Errorf("GOT\nCart: %s\nError: %v", c, err)
 	}
 }
 
@@ -201,10 +216,52 @@ func deleteCartSuccess(t *testing.T, reqEtag string) {
 	ts := httptest.NewServer(http.HandlerFunc(hf))
 	defer ts.Close()
 	a := &App{BaseURL: ts.URL, HTTPClient: *ts.Client()}
-	sc, m, err := a.deleteCart(wid, reqEtag)
-	if sc != sCod || m != "" || err != nil {
-		t.Errorf("EXPECTED\nStatus code: %d\n\n", sCod)
-		t.Errorf("GOT\nStatus code: %d\nMessage: %s\nError: %s", sc, m, err)
+	if err := a.deleteCart(wid, reqEtag); err != nil {
+		t.Errorf("Error deleting the cart %v", err)
+	}
+}
+
+func TestConditionalChanges(t *testing.T) {
+	etag := `"2-0a1b2c3d"`
+	var reqs []string
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		im := r.Header.Get("If-Match")
+		reqs = append(reqs, r.Method+" "+im)
+		switch {
+		case r.Method == "GET":
+			respondWithPayload(w, http.StatusOK, cart{ID: "ABC"}, etag)
+		case im == "":
+			respondWithPayload(w, http.StatusPreconditionRequired, map[string]interface{}{"status": 428, "detail": "Send If-Match"}, "")
+		case im == etag:
+			respondWithPayload(w, http.StatusCreated, article{ID: "MUG", Quantity: 1}, "")
+		default:
+			w.WriteHeader(http.StatusPreconditionFailed)
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(hf))
+	defer ts.Close()
+	a := &App{BaseURL: ts.URL, HTTPClient: *ts.Client()}
+	if _, err := a.addArticleToCart("ABC", "", "MUG", 1); err != nil {
+		t.Fatalf("Adding after 428: %v", err)
+	}
+	if _, err := a.addArticleToCart("ABC", "", "MUG", 1); err != nil {
+		t.Fatalf("Adding in conditional mode: %v", err)
+	}
+	a.getCart("ABC", "")
+	etag = `"3-0a1b2c3d"`
+	if err := a.deleteCart("ABC", ""); !errors.Is(err, client.ErrPreconditionFailed) {
+		t.Errorf("Deleting with a stale remembered ETag: %v instead of %v", err, client.ErrPreconditionFailed)
+	}
+	exp := []string{"POST ", "GET ", `POST "2-0a1b2c3d"`, "GET ", `POST "2-0a1b2c3d"`, "GET ", `DELETE "2-0a1b2c3d"`}
+	if fmt.Sprint(reqs) != fmt.Sprint(exp) {
+		t.Errorf("Requests %q instead of %q", reqs, exp)
+	}
+}
+
+func TestAppliedPromotionString(t *testing.T) {
+	ap := appliedPromotion{RuleID: 1, RuleCode: "VOUCHER2X1", ItemID: "VOUCHER", Quantity: 1, Saving: money.New(500, "EUR")}
+	if s, exp := ap.String(), "VOUCHER2X1 on 1 x VOUCHER: saved 5.00 €"; s != exp {
+		t.Errorf("Applied promotion printed as %q instead of %q", s, exp)
 	}
 }
 
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"shopping-cart-kata/money"
+)
+
+type appliedPromotion struct {
+	RuleID   int64       `json:"ruleId"`
+	RuleCode string      `json:"ruleCode"`
+	Label    string      `json:"label"`
+	ItemID   string      `json:"itemId"`
+	Quantity int         `json:"quantity"`
+	Saving   money.Money `json:"saving"`
+}
+
+func (ap appliedPromotion) String() string {
+	rule := ap.Label
+	if rule == "" {
+		rule = ap.RuleCode
+	}
+	if rule == "" {
+		rule = fmt.Sprintf("Rule %d", ap.RuleID)
+	}
+	target := "cart"
+	if ap.ItemID != "" {
+		target = fmt.Sprintf("%d x %s", ap.Quantity, ap.ItemID)
+	}
+	return fmt.Sprintf("%s on %s: saved %s %s", rule, target, ap.Saving.StringAmount(), ap.Saving.Symbol())
+}
@@ -1,16 +1,39 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"shopping-cart-kata/client"
+	"shopping-cart-kata/money"
+)
 
 type cart struct {
-	ID       string  `json:"id"`
-	Subtotal float64 `json:"subTotal"`
-	Items    []item  `json:"items"`
-	URL      string  `json:"url"`
-	ETag     string  `json:"etag"`
+	ID         string             `json:"id"`
+	Subtotal   money.Money        `json:"subTotal"`
+	Items      []item             `json:"items"`
+	Promotions []appliedPromotion `json:"promotions"`
+	URL        string             `json:"url"`
+	Degraded   bool               `json:"degraded"`
+	Reasons    []string           `json:"degradedReasons,omitempty"`
+	ETag       string             `json:"etag"`
 }
 
 func (c cart) String() string {
-	format := "{ \"id\": %q, \"subtotal\": %f, \"items\": %v }\nETag: %s"
-	return fmt.Sprintf(format, c.ID, c.Subtotal, c.Items, c.ETag)
+	format := "{ \"id\": %q, \"subtotal\": \"%s\", \"items\": %v }"
+	s := fmt.Sprintf(format, c.ID, c.Subtotal, c.Items)
+	if c.ETag != "" {
+		s += "\nETag: " + c.ETag
+	}
+	return s
+}
+
+// fromClientCart converts the cart retrieved by the client
+func fromClientCart(cc client.Cart) cart {
+	c := cart{ID: cc.ID, Subtotal: cc.Subtotal, URL: cc.URL, Degraded: cc.Degraded, Reasons: cc.DegradedReasons, ETag: cc.ETag}
+	for _, i := range cc.Items {
+		c.Items = append(c.Items, item{ID: i.ID, Quantity: i.Quantity, UnitPrice: i.UnitPrice, TotalPrice: i.TotalPrice})
+	}
+	for _, ap := range cc.Promotions {
+		c.Promotions = append(c.Promotions, appliedPromotion(ap))
+	}
+	return c
 }
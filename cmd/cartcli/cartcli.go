@@ -2,9 +2,10 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"shopping-cart-kata/catalog"
@@ -13,11 +14,27 @@ import (
 
 func main() {
 	var baseURL = flag.String("baseUrl", "http://127.0.0.1:8000", "Address:port of the server")
+	var output = flag.String("output", outputText, "Format of the command results: "+strings.Join(outputFormats, ", "))
+	flag.Usage = func() { printUsage(os.Stderr) }
 	flag.Parse()
-	welcomeMsg := buildWelcomeMsg(*baseURL)
+	a := &App{BaseURL: *baseURL, HTTPClient: http.Client{}, Output: *output}
+	os.Exit(run(a, flag.Args()))
+}
+
+// run runs the interactive shell, when no command is given, or a command
+func run(a *App, args []string) int {
+	if len(args) == 0 || (len(args) == 1 && args[0] == "shell") {
+		shell(a, os.Stdin)
+		return exitOK
+	}
+	return runCommand(a, args)
+}
+
+// shell runs the interactive menu until quit or the end of input
+func shell(a *App, in io.Reader) {
+	welcomeMsg := buildWelcomeMsg(a)
 	fmt.Println(welcomeMsg)
-	input := bufio.NewScanner(os.Stdin)
-	a := &App{BaseURL: *baseURL, HTTPClient: http.Client{}}
+	input := bufio.NewScanner(in)
 	for input.Scan() {
 		choice := input.Text()
 		switch choice {
@@ -26,12 +43,14 @@ func main() {
 		case "2":
 			handleAddArticleToCart(input, a)
 		case "3":
-			handleGetCartSubtotal(input, a)
+			handleRemoveArticleFromCart(input, a)
 		case "4":
-			handleDeleteCart(input, a)
+			handleGetCartSubtotal(input, a)
 		case "5":
+			handleDeleteCart(input, a)
+		case "6":
 			fmt.Println()
-			os.Exit(0)
+			return
 		default:
 			fmt.Printf("Invalid choice %s", choice)
 		}
@@ -39,8 +58,8 @@ func main() {
 	}
 }
 
-func buildWelcomeMsg(baseURL string) string {
-	arts, err := getArticles(baseURL)
+func buildWelcomeMsg(a *App) string {
+	arts, err := a.client().GetArticles(context.Background())
 	if err != nil {
 		fmt.Printf("Error retrieving the catalog:\n%s", err)
 		os.Exit(1)
@@ -48,23 +67,22 @@ func buildWelcomeMsg(baseURL string) string {
 	return welcomeMsgForArticles(arts)
 }
 
-func getArticles(baseURL string) ([]catalog.Article, error) {
-	var arts []catalog.Article
-	url := fmt.Sprintf("%s/articles", baseURL)
-	resp, err := http.Get(url)
-	if err != nil {
-		return arts, err
-	}
-	defer resp.Body.Close()
-	if err := json.NewDecoder(resp.Body).Decode(&arts); err != nil {
-		return arts, err
-	}
-	return arts, nil
-}
-
 func welcomeMsgForArticles(articles []catalog.Article) string {
 	var sb strings.Builder
 	sb.WriteString("\nOUR CATALOG CONTAINS THE FOLLOWING ARTICLES\n")
+	sb.WriteString(articlesTable(articles))
+	sb.WriteString("\nPLEASE SELECT AN OPERATION\n")
+	sb.WriteString(" 1) Create a cart\n")
+	sb.WriteString(" 2) Add an article to a cart\n")
+	sb.WriteString(" 3) Remove an article from a cart\n")
+	sb.WriteString(" 4) Get the cart subtotal\n")
+	sb.WriteString(" 5) Delete a cart\n")
+	sb.WriteString(" 6) Quit\n")
+	return sb.String()
+}
+
+func articlesTable(articles []catalog.Article) string {
+	var sb strings.Builder
 	sb.WriteString("      Code             |   Name             |   Price\n")
 	sb.WriteString("   ---------------------------------------------------------------\n")
 	indent := 3
@@ -72,13 +90,8 @@ func welcomeMsgForArticles(articles []catalog.Article) string {
 	for _, a := range articles {
 		sCode := strings.Repeat(" ", colWidth-indent-len(a.Code))
 		sName := strings.Repeat(" ", colWidth-indent-len(a.Name))
-		sb.WriteString(fmt.Sprintf("      %s%s|   %s%s|  %6.2f €\n", a.Code, sCode, a.Name, sName, a.Price))
+		price := fmt.Sprintf("%6s %s", a.Price.StringAmount(), a.Price.Symbol())
+		sb.WriteString(fmt.Sprintf("      %s%s|   %s%s|  %s\n", a.Code, sCode, a.Name, sName, price))
 	}
-	sb.WriteString("\nPLEASE SELECT AN OPERATION\n")
-	sb.WriteString(" 1) Create a cart\n")
-	sb.WriteString(" 2) Add an article to a cart\n")
-	sb.WriteString(" 3) Get the cart subtotal\n")
-	sb.WriteString(" 4) Delete a cart\n")
-	sb.WriteString(" 5) Quit\n")
 	return sb.String()
 }
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"shopping-cart-kata/client"
+	"strconv"
+	"strings"
+)
+
+// Exit codes of the commands, mapped from the HTTP status of the response by way of the client errors
+const (
+	exitOK          = 0
+	exitFailure     = 1
+	exitUsage       = 2
+	exitUnavailable = 3
+	exitNotFound    = 4
+	exitPrecondFail = 5
+	exitConflict    = 6
+	exitInvalid     = 7
+	exitGone        = 8
+	exitNotModified = 9
+	exitServerError = 10
+)
+
+// exitCode maps the outcome of a client call to the exit code of the command
+// Errors without a response, e.g. the server is unreachable, make the service unavailable
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, client.ErrNotModified):
+		return exitNotModified
+	case errors.Is(err, client.ErrNotFound):
+		return exitNotFound
+	case errors.Is(err, client.ErrPreconditionFailed) || errors.Is(err, client.ErrPreconditionRequired):
+		return exitPrecondFail
+	case errors.Is(err, client.ErrConflict):
+		return exitConflict
+	case errors.Is(err, client.ErrValidation):
+		return exitInvalid
+	case errors.Is(err, client.ErrGone):
+		return exitGone
+	case errors.Is(err, client.ErrServer):
+		return exitServerError
+	case errors.Is(err, client.ErrUnexpectedStatus):
+		return exitFailure
+	}
+	return exitUnavailable
+}
+
+// command is a non-interactive operation named by a group and a verb
+// A last parameter ending with ... takes one or more arguments
+type command struct {
+	group  string
+	verb   string
+	params []string
+	flags  []string
+	run    func(a *App, args []string, f cmdFlags) int
+}
+
+// cmdFlags are the values of the command flags
+type cmdFlags struct {
+	etag  string
+	rules string
+}
+
+var flagValues = map[string]string{"etag": "etag", "rules": "file"}
+
+var commands = []command{
+	{"cart", "create", nil, nil, func(a *App, args []string, f cmdFlags) int {
+		return doCreateCart(a)
+	}},
+	{"cart", "add", []string{"id", "code", "qty"}, []string{"etag"}, func(a *App, args []string, f cmdFlags) int {
+		qty, err := strconv.Atoi(args[2])
+		if err != nil || qty <= 0 {
+			fmt.Fprintf(os.Stderr, "Invalid quantity %q: it must be a positive integer\n", args[2])
+			return exitUsage
+		}
+		return doAddArticleToCart(a, args[0], f.etag, args[1], qty)
+	}},
+	{"cart", "remove", []string{"id", "code"}, []string{"etag"}, func(a *App, args []string, f cmdFlags) int {
+		return doRemoveArticleFromCart(a, args[0], f.etag, args[1])
+	}},
+	{"cart", "get", []string{"id"}, []string{"etag"}, func(a *App, args []string, f cmdFlags) int {
+		return doGetCart(a, args[0], f.etag)
+	}},
+	{"cart", "delete", []string{"id"}, []string{"etag"}, func(a *App, args []string, f cmdFlags) int {
+		return doDeleteCart(a, args[0], f.etag)
+	}},
+	{"articles", "list", nil, nil, func(a *App, args []string, f cmdFlags) int {
+		return doListArticles(a)
+	}},
+	{"scenario", "run", []string{"file"}, nil, func(a *App, args []string, f cmdFlags) int {
+		return doRunScenarios(a, args[0])
+	}},
+	{"offline", "price", []string{"catalog", "code..."}, []string{"rules"}, func(a *App, args []string, f cmdFlags) int {
+		return doPriceOffline(a, args[0], f.rules, args[1:])
+	}},
+}
+
+// runCommand runs the command named by the first arguments returning its exit code
+func runCommand(a *App, args []string) int {
+	fs := flag.NewFlagSet("cartcli", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	var f cmdFlags
+	fs.StringVar(&f.etag, "etag", "", "ETag of the cart: If-Match for changes, If-None-Match for get")
+	fs.StringVar(&f.rules, "rules", "", "JSON file of promotion rules, in the format of cartsvc -rules (no promotions if empty)")
+	output := fs.String("output", a.Output, "Format of the command results")
+	pos, err := parseInterspersed(fs, args)
+	if err != nil {
+		return exitUsage
+	}
+	if *output != "" && !validOutput(*output) {
+		fmt.Fprintf(os.Stderr, "Invalid output %q: it must be one of %s\n", *output, strings.Join(outputFormats, ", "))
+		return exitUsage
+	}
+	a.Output = *output
+	for _, c := range commands {
+		if len(pos) < 2 || pos[0] != c.group || pos[1] != c.verb {
+			continue
+		}
+		if !c.accepts(pos[2:], fs) {
+			fmt.Fprintf(os.Stderr, "Usage: cartcli %s\n", c.usage())
+			return exitUsage
+		}
+		return c.run(a, pos[2:], f)
+	}
+	printUsage(os.Stderr)
+	return exitUsage
+}
+
+// accepts tells if the arguments match the parameters and only the command flags, or the output, are set
+func (c command) accepts(args []string, fs *flag.FlagSet) bool {
+	ok := len(args) == len(c.params)
+	if n := len(c.params); n > 0 && strings.HasSuffix(c.params[n-1], "...") {
+		ok = len(args) >= n
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "output" {
+			return
+		}
+		for _, name := range c.flags {
+			if f.Name == name {
+				return
+			}
+		}
+		ok = false
+	})
+	return ok
+}
+
+func (c command) usage() string {
+	u := c.group + " " + c.verb
+	for _, p := range c.params {
+		u += " <" + p + ">"
+	}
+	for _, name := range c.flags {
+		u += fmt.Sprintf(" [--%s %s]", name, flagValues[name])
+	}
+	return u
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: cartcli [-baseUrl url] [-output format] <command> [--output format]")
+	fmt.Fprintln(w, "Commands:")
+	fmt.Fprintln(w, "  shell (default): interactive menu")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %s\n", c.usage())
+	}
+	fmt.Fprintln(w, "Flags:")
+	flag.CommandLine.SetOutput(w)
+	flag.PrintDefaults()
+	fmt.Fprintln(w, usageExitCodes)
+}
+
+// parseInterspersed parses the flags wherever they are among the positional arguments, returned in order
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var pos []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return pos, nil
+		}
+		pos = append(pos, args[0])
+		args = args[1:]
+	}
+}
+
+const usageExitCodes = `Exit codes: 0 success, 1 failed scenarios or other failure, 2 usage error, 3 server unreachable, 4 not found,
+5 precondition failed or required, 6 conflict, 7 invalid request, 8 cart expired,
+9 not modified, 10 server error`
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"shopping-cart-kata/catalog"
+	"shopping-cart-kata/money"
+	"testing"
+)
+
+func TestRunCommands(t *testing.T) {
+	var ifMatch string
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /carts":
+			respondWithPayload(w, http.StatusCreated, cart{ID: "ABC"}, `"1-0a1b2c3d"`)
+		case "POST /carts/ABC/items":
+			ifMatch = r.Header.Get("If-Match")
+			respondWithPayload(w, http.StatusCreated, article{ID: "MUG", Quantity: 2}, "")
+		case "GET /carts/ABC":
+			if r.Header.Get("If-None-Match") == `"2-0a1b2c3d"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			respondWithPayload(w, http.StatusOK, cart{ID: "ABC", Subtotal: money.New(1500, "EUR")}, `"2-0a1b2c3d"`)
+		case "DELETE /carts/ABC":
+			w.WriteHeader(http.StatusPreconditionFailed)
+		case "GET /articles":
+			respondWithPayload(w, http.StatusOK, []catalog.Article{{Code: "MUG", Name: "Mug", Price: money.New(750, "EUR")}}, "")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(hf))
+	defer ts.Close()
+	a := &App{BaseURL: ts.URL, HTTPClient: *ts.Client()}
+	tests := []struct {
+		args []string
+		exp  int
+	}{
+		{[]string{"cart", "create"}, exitOK},
+		{[]string{"cart", "add", "ABC", "MUG", "2", "--etag", `"1-0a1b2c3d"`}, exitOK},
+		{[]string{"cart", "add", "ABC", "MUG", "zero"}, exitUsage},
+		{[]string{"cart", "add", "ABC", "MUG"}, exitUsage},
+		{[]string{"cart", "get", "ABC"}, exitOK},
+		{[]string{"cart", "get", "--etag", `"2-0a1b2c3d"`, "ABC"}, exitNotModified},
+		{[]string{"cart", "get", "XYZ"}, exitNotFound},
+		{[]string{"cart", "delete", "ABC", "--etag", `"1-0a1b2c3d"`}, exitPrecondFail},
+		{[]string{"cart", "create", "--etag", `"1-0a1b2c3d"`}, exitUsage},
+		{[]string{"articles", "list"}, exitOK},
+		{[]string{"cart", "empty", "ABC"}, exitUsage},
+		{[]string{"--unknown"}, exitUsage},
+	}
+	for _, test := range tests {
+		if code := run(a, test.args); code != test.exp {
+			t.Errorf("Running %q exited with %d instead of %d", test.args, code, test.exp)
+		}
+	}
+	if ifMatch != `"1-0a1b2c3d"` {
+		t.Errorf("Added with If-Match %s instead of the --etag value", ifMatch)
+	}
+	unreachable := &App{BaseURL: "http://127.0.0.1:1", HTTPClient: http.Client{}}
+	if code := run(unreachable, []string{"cart", "create"}); code != exitUnavailable {
+		t.Errorf("Creating on an unreachable server exited with %d instead of %d", code, exitUnavailable)
+	}
+}
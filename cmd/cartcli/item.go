@@ -1,15 +1,18 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"shopping-cart-kata/money"
+)
 
 type item struct {
-	ID         string  `json:"id"`
-	Quantity   int     `json:"quantity"`
-	UnitPrice  float64 `json:"unitPrice"`
-	TotalPrice float64 `json:"totalPrice"`
+	ID         string      `json:"id"`
+	Quantity   int         `json:"quantity"`
+	UnitPrice  money.Money `json:"unitPrice"`
+	TotalPrice money.Money `json:"totalPrice"`
 }
 
 func (i item) String() string {
-	format := `{ "id": %q, "quantity": %d, "unitPrice": %f, "totalPrice": %f }`
+	format := `{ "id": %q, "quantity": %d, "unitPrice": "%s", "totalPrice": "%s" }`
 	return fmt.Sprintf(format, i.ID, i.Quantity, i.UnitPrice, i.TotalPrice)
 }
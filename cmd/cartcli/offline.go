@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"shopping-cart-kata/appservice"
+	shopcart "shopping-cart-kata/cart"
+	"shopping-cart-kata/catalog"
+	"shopping-cart-kata/money"
+	"shopping-cart-kata/pricedcart"
+	"shopping-cart-kata/promotion"
+)
+
+// ErrNotInCatalog when an article to price is not in the catalog
+var ErrNotInCatalog = errors.New("Article is not in the catalog")
+
+// offlineCartID identifies the cart priced offline
+const offlineCartID = 1
+
+// fixedID provides always the same cart ID
+type fixedID int64
+
+// NextID returns the fixed ID
+func (id fixedID) NextID() int64 {
+	return int64(id)
+}
+
+// doPriceOffline prices the articles in-process, with the catalog and rules of local files, as cartsvc would
+// Codes may be repeated or comma separated like "VOUCHER, TSHIRT, VOUCHER"
+func doPriceOffline(a *App, catalogFile string, rulesFile string, args []string) int {
+	arts, err := readArticles(catalogFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid catalog file %s: %v\n", catalogFile, err)
+		return exitInvalid
+	}
+	var defs []promotion.RuleDef
+	if rulesFile != "" {
+		if defs, err = readRuleDefs(rulesFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid rules file %s: %v\n", rulesFile, err)
+			return exitInvalid
+		}
+	}
+	var codes []string
+	for _, arg := range args {
+		codes = append(codes, splitCodes(arg)...)
+	}
+	pc, err := priceOffline(arts, defs, codes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitInvalid
+	}
+	c := fromPricedCart(pc)
+	if a.structuredOutput() {
+		j, _ := json.Marshal(c)
+		return a.printOutput(j, cartRecords(c))
+	}
+	printCart(a.out(), c)
+	return exitOK
+}
+
+// priceOffline prices the articles through the application service of cartsvc with an in memory cart store
+// Rules failing on the cart are skipped and reported as degraded reasons
+func priceOffline(arts []catalog.Article, defs []promotion.RuleDef, codes []string) (pricedcart.PricedCart, error) {
+	cat := catalog.NewCatalog()
+	for _, art := range arts {
+		cat.AddArticle(art)
+	}
+	eng := promotion.NewEngineIn(catalogCurrency(arts))
+	if err := eng.ReplaceRules(defs); err != nil {
+		return nil, err
+	}
+	s := appservice.AppService{
+		CartIDG:        fixedID(offlineCartID),
+		CartDB:         shopcart.NewStore(),
+		Catalog:        cat,
+		PromEng:        eng,
+		PromoErrPolicy: appservice.SkipFailingPromo,
+	}
+	var unique []string
+	quantities := make(map[string]int)
+	for _, code := range codes {
+		if quantities[code] == 0 {
+			unique = append(unique, code)
+		}
+		quantities[code]++
+	}
+	id, err := s.CreateCart()
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range unique {
+		err := s.AddArticleToCart(id, code, quantities[code])
+		if err == appservice.ErrArtNotFound {
+			return nil, fmt.Errorf("%v: %s", ErrNotInCatalog, code)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.GetCart(id)
+}
+
+// catalogCurrency returns the currency of the catalog articles, all in the same one
+func catalogCurrency(arts []catalog.Article) string {
+	if len(arts) == 0 {
+		return money.DefaultCurrency
+	}
+	return arts[0].Price.Currency()
+}
+
+// fromPricedCart converts the priced cart as the web API would
+func fromPricedCart(pc pricedcart.PricedCart) cart {
+	c := cart{ID: fmt.Sprint(pc.GetID()), Subtotal: pc.GetSubtotal(), Reasons: pc.GetDegradedReasons()}
+	c.Degraded = len(c.Reasons) > 0
+	for _, pi := range pc.GetItems() {
+		c.Items = append(c.Items, item{ID: pi.ID, Quantity: pi.Quantity, UnitPrice: pi.UnitPrice, TotalPrice: pi.TotalPrice})
+	}
+	for _, ap := range pc.GetAppliedPromotions() {
+		c.Promotions = append(c.Promotions, appliedPromotion{
+			RuleID:   ap.Rule.ID,
+			RuleCode: ap.Rule.Code,
+			Label:    ap.Rule.Label,
+			ItemID:   ap.ItemID,
+			Quantity: ap.Quantity,
+			Saving:   ap.Saving,
+		})
+	}
+	return c
+}
+
+func readArticles(path string) ([]catalog.Article, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return catalog.ParseArticles(f)
+}
+
+func readRuleDefs(path string) ([]promotion.RuleDef, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return promotion.ParseRules(f)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPriceOffline(t *testing.T) {
+	dir := filepath.Join("..", "..")
+	catalogFile, rulesFile := filepath.Join(dir, "catalog.json"), filepath.Join(dir, "rules.json")
+	var out bytes.Buffer
+	a := &App{Out: &out}
+	args := []string{"offline", "price", catalogFile, "VOUCHER, TSHIRT, VOUCHER", "VOUCHER", "MUG", "TSHIRT", "TSHIRT", "--rules", rulesFile}
+	if code := run(a, args); code != exitOK {
+		t.Fatalf("Offline pricing exited with %d", code)
+	}
+	exp := []string{"Cart subtotal 74.50 €", "Buy one voucher get one free on 1 x VOUCHER: saved 5.00 €", "T-shirts at 19.00 when buying 3 or more on 3 x TSHIRT: saved 3.00 €"}
+	for _, e := range exp {
+		if !strings.Contains(out.String(), e) {
+			t.Errorf("Offline pricing printed\n%s\nwithout %q", out.String(), e)
+		}
+	}
+
+	out.Reset()
+	if code := run(a, []string{"offline", "price", catalogFile, "TSHIRT", "TSHIRT", "--output", "json"}); code != exitOK {
+		t.Fatalf("Offline pricing without rules exited with %d", code)
+	}
+	var c cart
+	if err := json.Unmarshal(out.Bytes(), &c); err != nil || c.Subtotal.StringAmount() != "40.00" || len(c.Promotions) != 0 {
+		t.Errorf("Offline pricing without rules printed %s", out.String())
+	}
+
+	if code := run(a, []string{"offline", "price", catalogFile, "HAT"}); code != exitInvalid {
+		t.Errorf("Offline pricing of an article not in the catalog exited with %d instead of %d", code, exitInvalid)
+	}
+	if code := run(a, []string{"offline", "price", rulesFile, "MUG"}); code != exitInvalid {
+		t.Errorf("Offline pricing with an invalid catalog exited with %d instead of %d", code, exitInvalid)
+	}
+}
+
+func TestPriceOfflineInOtherCurrency(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "offline")
+	defer os.RemoveAll(dir)
+	usdCatalog, mixedCatalog := filepath.Join(dir, "usd.json"), filepath.Join(dir, "mixed.json")
+	usdRules, eurRules := filepath.Join(dir, "usdRules.json"), filepath.Join("..", "..", "rules.json")
+	ioutil.WriteFile(usdCatalog, []byte(`[{ "Code": "TSHIRT", "Name": "T-Shirt", "Price": { "amount": "20.00", "currency": "USD" } }]`), 0644)
+	ioutil.WriteFile(mixedCatalog, []byte(`[{ "Code": "TSHIRT", "Name": "T-Shirt", "Price": { "amount": "20.00", "currency": "USD" } },
+		{ "Code": "MUG", "Name": "Coffee Mug", "Price": { "amount": "7.50", "currency": "EUR" } }]`), 0644)
+	ioutil.WriteFile(usdRules, []byte(`{ "rules": [{ "code": "TSHIRT3PLUS", "label": "T-shirts at 19.00", "type": "multibuy",
+		"articles": ["TSHIRT"], "minQuantity": 3, "unitPrice": { "amount": "19.00", "currency": "USD" } }] }`), 0644)
+	var out bytes.Buffer
+	a := &App{Out: &out}
+	if code := run(a, []string{"offline", "price", usdCatalog, "TSHIRT", "TSHIRT", "TSHIRT", "--rules", usdRules}); code != exitOK || !strings.Contains(out.String(), "Cart subtotal 57.00 $") {
+		t.Errorf("Offline pricing in USD exited with %d printing\n%s", code, out.String())
+	}
+	for _, args := range [][]string{
+		{"offline", "price", usdCatalog, "TSHIRT", "--rules", eurRules},
+		{"offline", "price", filepath.Join("..", "..", "catalog.json"), "MUG", "--rules", usdRules},
+		{"offline", "price", mixedCatalog, "TSHIRT", "MUG"},
+	} {
+		if code := run(a, args); code != exitInvalid {
+			t.Errorf("Offline pricing %v exited with %d instead of %d", args, code, exitInvalid)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"shopping-cart-kata/catalog"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Output formats of the commands: text is for people, the others for tools
+const (
+	outputText  = "text"
+	outputJSON  = "json"
+	outputTable = "table"
+	outputCSV   = "csv"
+)
+
+var outputFormats = []string{outputText, outputJSON, outputTable, outputCSV}
+
+func validOutput(format string) bool {
+	for _, f := range outputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// records are the rows of a table or of a CSV file
+type records struct {
+	header []string
+	rows   [][]string
+}
+
+// cartRecords has a row per item repeating the cart fields, a single row with empty item fields for an empty cart
+func cartRecords(c cart) records {
+	r := records{header: []string{"CART", "SUBTOTAL", "CURRENCY", "ETAG", "ITEM", "QUANTITY", "UNIT PRICE", "TOTAL PRICE"}}
+	cartFields := []string{c.ID, c.Subtotal.StringAmount(), c.Subtotal.Currency(), c.ETag}
+	if len(c.Items) == 0 {
+		r.rows = append(r.rows, append(cartFields, "", "", "", ""))
+	}
+	for _, i := range c.Items {
+		itemFields := []string{i.ID, strconv.Itoa(i.Quantity), i.UnitPrice.StringAmount(), i.TotalPrice.StringAmount()}
+		r.rows = append(r.rows, append(append([]string(nil), cartFields...), itemFields...))
+	}
+	return r
+}
+
+func articleRecords(a article) records {
+	return records{
+		header: []string{"ITEM", "QUANTITY"},
+		rows:   [][]string{{a.ID, strconv.Itoa(a.Quantity)}},
+	}
+}
+
+func catalogRecords(arts []catalog.Article) records {
+	r := records{header: []string{"CODE", "NAME", "PRICE", "CURRENCY"}}
+	for _, a := range arts {
+		r.rows = append(r.rows, []string{a.Code, a.Name, a.Price.StringAmount(), a.Price.Currency()})
+	}
+	return r
+}
+
+// structuredOutput tells if the results are printed for tools
+func (a *App) structuredOutput() bool {
+	return a.Output != "" && a.Output != outputText
+}
+
+func (a *App) out() io.Writer {
+	if a.Out == nil {
+		return os.Stdout
+	}
+	return a.Out
+}
+
+// printOutput prints in the output format other than text the JSON payload, as is but for a final newline, or the records
+func (a *App) printOutput(j []byte, r records) int {
+	var err error
+	switch a.Output {
+	case outputJSON:
+		if !bytes.HasSuffix(j, []byte("\n")) {
+			j = append(j, '\n')
+		}
+		_, err = a.out().Write(j)
+	case outputTable:
+		tw := tabwriter.NewWriter(a.out(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(r.header, "\t"))
+		for _, row := range r.rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		err = tw.Flush()
+	case outputCSV:
+		cw := csv.NewWriter(a.out())
+		cw.Write(r.header)
+		cw.WriteAll(r.rows)
+		err = cw.Error()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	return exitOK
+}
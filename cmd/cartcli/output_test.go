@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"shopping-cart-kata/catalog"
+	"shopping-cart-kata/money"
+	"strings"
+	"testing"
+)
+
+func TestOutputFormats(t *testing.T) {
+	crt := cart{
+		ID:       "ABC",
+		Subtotal: money.New(2500, "EUR"),
+		Items: []item{
+			item{ID: "MUG", Quantity: 2, UnitPrice: money.New(750, "EUR"), TotalPrice: money.New(1500, "EUR")},
+			item{ID: "TSHIRT, XL", Quantity: 1, UnitPrice: money.New(1000, "EUR"), TotalPrice: money.New(1000, "EUR")},
+		},
+	}
+	arts := []catalog.Article{{Code: "MUG", Name: "Coffee Mug", Price: money.New(750, "EUR")}}
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/articles" {
+			respondWithPayload(w, http.StatusOK, arts, "")
+			return
+		}
+		respondWithPayload(w, http.StatusOK, crt, `"2-0a1b2c3d"`)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(hf))
+	defer ts.Close()
+	tests := []struct {
+		args []string
+		exp  string
+	}{
+		{[]string{"cart", "get", "ABC", "--output", "csv"}, `CART,SUBTOTAL,CURRENCY,ETAG,ITEM,QUANTITY,UNIT PRICE,TOTAL PRICE
+ABC,25.00,EUR,"""2-0a1b2c3d""",MUG,2,7.50,15.00
+ABC,25.00,EUR,"""2-0a1b2c3d""","TSHIRT, XL",1,10.00,10.00
+`},
+		{[]string{"cart", "get", "ABC", "--output", "table"}, `CART  SUBTOTAL  CURRENCY  ETAG          ITEM        QUANTITY  UNIT PRICE  TOTAL PRICE
+ABC   25.00     EUR       "2-0a1b2c3d"  MUG         2         7.50        15.00
+ABC   25.00     EUR       "2-0a1b2c3d"  TSHIRT, XL  1         10.00       10.00
+`},
+		{[]string{"--output=csv", "articles", "list"}, "CODE,NAME,PRICE,CURRENCY\nMUG,Coffee Mug,7.50,EUR\n"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		a := &App{BaseURL: ts.URL, HTTPClient: *ts.Client(), Out: &out}
+		if code := run(a, test.args); code != exitOK || out.String() != test.exp {
+			t.Errorf("Running %q exited with %d printing\n%s\ninstead of\n%s", test.args, code, out.String(), test.exp)
+		}
+	}
+
+	a := &App{BaseURL: ts.URL, HTTPClient: *ts.Client(), Output: outputJSON}
+	if code := run(a, []string{"cart", "get", "ABC", "--output", "yaml"}); code != exitUsage {
+		t.Errorf("Unknown output format exited with %d instead of %d", code, exitUsage)
+	}
+}
+
+func TestJSONOutputIsTheAPIPayload(t *testing.T) {
+	bodies := map[string]string{
+		"POST /carts":           `{"id":"ABC","subTotal":{"amount":"0.00","currency":"EUR"},"items":[],"promotions":[],"url":"http://127.0.0.1/carts/ABC","degraded":false}`,
+		"GET /carts/ABC":        "{ \"id\": \"ABC\", \"items\": [], \"futureField\": 1 }\n",
+		"POST /carts/ABC/items": `{"id":"MUG","quantity":2,"cartUrl":"http://127.0.0.1/carts/ABC"}`,
+		"GET /articles":         `[{"code":"MUG","name":"Coffee Mug","price":{"amount":"7.50","currency":"EUR"}}]`,
+	}
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"1-0a1b2c3d"`)
+		status := http.StatusOK
+		if r.Method == http.MethodPost {
+			status = http.StatusCreated
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, bodies[r.Method+" "+r.URL.Path])
+	}
+	ts := httptest.NewServer(http.HandlerFunc(hf))
+	defer ts.Close()
+	tests := map[string][]string{
+		"POST /carts":           {"cart", "create"},
+		"GET /carts/ABC":        {"cart", "get", "ABC"},
+		"POST /carts/ABC/items": {"cart", "add", "ABC", "MUG", "2"},
+		"GET /articles":         {"articles", "list"},
+	}
+	for call, args := range tests {
+		var out bytes.Buffer
+		a := &App{BaseURL: ts.URL, HTTPClient: *ts.Client(), Out: &out, Output: outputJSON}
+		exp := strings.TrimSuffix(bodies[call], "\n") + "\n"
+		if code := run(a, args); code != exitOK || out.String() != exp {
+			t.Errorf("Running %q exited with %d printing\n%s\ninstead of the body of %s\n%s", args, code, out.String(), call, exp)
+		}
+	}
+}
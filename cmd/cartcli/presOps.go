@@ -2,19 +2,18 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"net/http"
+	"io"
+	"os"
+	"shopping-cart-kata/client"
 	"strconv"
 )
 
 func handleCreateCart(a *App) {
 	fmt.Println("\rAttempting cart creation...")
-	c, code, msg, err := a.createCart()
-	if code == http.StatusCreated {
-		fmt.Printf("Created cart %s\n", c)
-		return
-	}
-	printOtherInfo(code, msg, err)
+	doCreateCart(a)
 }
 
 func handleAddArticleToCart(input *bufio.Scanner, a *App) {
@@ -24,64 +23,150 @@ func handleAddArticleToCart(input *bufio.Scanner, a *App) {
 	artQty := inputInt(input, "article quantity (must be positive)", false)
 	format := "\rAttempting to add article %q with quantity %d to cart %q ETag %s...\n"
 	fmt.Printf(format, artCode, artQty, id, etag)
-	art, code, msg, err := a.addOrUpdateArticle(id, etag, artCode, artQty)
-	if code == http.StatusCreated || code == http.StatusOK {
-		fmt.Printf("Added article %s\n", art)
-		return
-	}
-	if code == http.StatusNotFound {
-		fmt.Println("No cart found with that ID")
-		return
-	}
-	if code == http.StatusPreconditionFailed {
-		fmt.Println("No cart found with that ETag")
-		return
-	}
-	if code == http.StatusUnprocessableEntity {
-		fmt.Println("The article does not exist")
-		return
-	}
-	printOtherInfo(code, msg, err)
+	doAddArticleToCart(a, id, etag, artCode, artQty)
+}
+
+func handleRemoveArticleFromCart(input *bufio.Scanner, a *App) {
+	id := inputString(input, "cart ID", false)
+	etag := inputString(input, "cart ETag (press return to skip)", true)
+	artCode := inputString(input, "article code", false)
+	fmt.Printf("\rAttempting to remove article %q from cart %q ETag %s...\n", artCode, id, etag)
+	doRemoveArticleFromCart(a, id, etag, artCode)
 }
 
 func handleGetCartSubtotal(input *bufio.Scanner, a *App) {
 	id := inputString(input, "cart ID", false)
 	etag := inputString(input, "cart ETag (press return to skip)", true)
 	fmt.Printf("\rAttempting to get subtotal for cart %q with ETag %s...\n", id, etag)
-	c, code, msg, err := a.getCart(id, etag)
-	if code == http.StatusOK {
-		fmt.Printf("Cart subtotal %g\nCart %s", c.Subtotal, c)
-		return
-	}
-	if code == http.StatusNotFound {
-		fmt.Println("No cart found with that ID")
-		return
-	}
-	if code == http.StatusNotModified {
-		fmt.Println("Cart with that ETag was not modified: omit ETag to get the cart")
-		return
-	}
-	printOtherInfo(code, msg, err)
+	doGetCart(a, id, etag)
 }
 
 func handleDeleteCart(input *bufio.Scanner, a *App) {
 	id := inputString(input, "cart ID", false)
 	etag := inputString(input, "cart ETag (press return to skip)", true)
 	fmt.Printf("\rAttempting to delete cart %q with ETag %s...\n", id, etag)
-	code, msg, err := a.deleteCart(id, etag)
-	if code == http.StatusNoContent {
-		fmt.Println("Cart deleted")
-		return
+	doDeleteCart(a, id, etag)
+}
+
+// The do functions perform an operation printing its outcome and return the exit code for it
+
+func doCreateCart(a *App) int {
+	c, err := a.createCart()
+	if err != nil {
+		return printError(err)
 	}
-	if code == http.StatusNotFound {
-		fmt.Println("No cart found with that ID")
-		return
+	if a.structuredOutput() {
+		return a.printOutput(a.payload, cartRecords(c))
 	}
-	if code == http.StatusPreconditionFailed {
-		fmt.Println("No cart found with that ETag")
-		return
+	fmt.Fprintf(a.out(), "Created cart %s\n", c)
+	return exitOK
+}
+
+func doAddArticleToCart(a *App, id string, etag string, artCode string, artQty int) int {
+	art, err := a.addOrUpdateArticle(id, etag, artCode, artQty)
+	switch {
+	case errors.Is(err, client.ErrNotFound):
+		return printFailure(err, "No cart found with that ID")
+	case errors.Is(err, client.ErrGone):
+		return printFailure(err, "The cart expired")
+	case errors.Is(err, client.ErrPreconditionFailed):
+		return printFailure(err, "No cart found with that ETag")
+	case err != nil:
+		return printError(err)
+	}
+	if a.structuredOutput() {
+		return a.printOutput(a.payload, articleRecords(art))
+	}
+	fmt.Fprintf(a.out(), "Added article %s\n", art)
+	return exitOK
+}
+
+func doRemoveArticleFromCart(a *App, id string, etag string, artCode string) int {
+	err := a.removeArticleFromCart(id, etag, artCode)
+	switch {
+	case errors.Is(err, client.ErrNotFound) && apiMessage(err) != "":
+		return printFailure(err, "The article is not in the cart")
+	case errors.Is(err, client.ErrNotFound):
+		return printFailure(err, "No cart found with that ID")
+	case errors.Is(err, client.ErrGone):
+		return printFailure(err, "The cart expired")
+	case errors.Is(err, client.ErrPreconditionFailed):
+		return printFailure(err, "No cart found with that ETag")
+	case err != nil:
+		return printError(err)
+	}
+	if !a.structuredOutput() {
+		fmt.Fprintln(a.out(), "Article removed")
+	}
+	return exitOK
+}
+
+func doGetCart(a *App, id string, etag string) int {
+	c, err := a.getCart(id, etag)
+	switch {
+	case errors.Is(err, client.ErrNotFound):
+		return printFailure(err, "No cart found with that ID")
+	case errors.Is(err, client.ErrGone):
+		return printFailure(err, "The cart expired")
+	case errors.Is(err, client.ErrNotModified):
+		return printFailure(err, "Cart with that ETag was not modified: omit ETag to get the cart")
+	case err != nil:
+		return printError(err)
+	}
+	if a.structuredOutput() {
+		return a.printOutput(a.payload, cartRecords(c))
+	}
+	printCart(a.out(), c)
+	return exitOK
+}
+
+func doDeleteCart(a *App, id string, etag string) int {
+	err := a.deleteCart(id, etag)
+	switch {
+	case errors.Is(err, client.ErrNotFound):
+		return printFailure(err, "No cart found with that ID")
+	case errors.Is(err, client.ErrGone):
+		return printFailure(err, "The cart expired")
+	case errors.Is(err, client.ErrPreconditionFailed):
+		return printFailure(err, "No cart found with that ETag")
+	case err != nil:
+		return printError(err)
+	}
+	if !a.structuredOutput() {
+		fmt.Fprintln(a.out(), "Cart deleted")
+	}
+	return exitOK
+}
+
+func doListArticles(a *App) int {
+	arts, err := a.client().GetArticles(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error retrieving the catalog:\n%s\n", err)
+		return exitCode(err)
+	}
+	if a.structuredOutput() {
+		return a.printOutput(a.payload, catalogRecords(arts))
+	}
+	fmt.Fprint(a.out(), articlesTable(arts))
+	return exitOK
+}
+
+// printCart prints the subtotal, the applied promotions and why others were not applied
+func printCart(out io.Writer, c cart) {
+	fmt.Fprintf(out, "Cart subtotal %s %s\n", c.Subtotal.StringAmount(), c.Subtotal.Symbol())
+	if len(c.Promotions) > 0 {
+		fmt.Fprintln(out, "Applied promotions:")
+	}
+	for _, ap := range c.Promotions {
+		fmt.Fprintf(out, " - %s\n", ap)
+	}
+	if c.Degraded {
+		fmt.Fprintln(out, "Promotions not applied for errors:")
 	}
-	printOtherInfo(code, msg, err)
+	for _, r := range c.Reasons {
+		fmt.Fprintf(out, " - %s\n", r)
+	}
+	fmt.Fprintf(out, "Cart %s\n", c)
 }
 
 func inputInt(input *bufio.Scanner, suffix string, optional bool) int {
@@ -108,14 +193,14 @@ func inputString(input *bufio.Scanner, suffix string, optional bool) string {
 	return text
 }
 
-func printOtherInfo(code int, msg string, err error) {
-	if code != 0 {
-		fmt.Printf("Status code: %d\n", code)
-	}
-	if len(msg) != 0 {
-		fmt.Printf("Message: %s\n", msg)
-	}
-	if err != nil {
-		fmt.Println(err)
-	}
+// printFailure prints the message explaining the error of a client call returning the exit code for it
+func printFailure(err error, msg string) int {
+	fmt.Fprintln(os.Stderr, msg)
+	return exitCode(err)
+}
+
+// printError prints the error of a client call, with the status and message of the response if any, returning the exit code for it
+func printError(err error) int {
+	fmt.Fprintln(os.Stderr, err)
+	return exitCode(err)
 }
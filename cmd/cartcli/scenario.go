@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"shopping-cart-kata/client"
+	"shopping-cart-kata/money"
+	"strings"
+)
+
+// ErrNoScenarios when a scenario file does not contain any scenario
+var ErrNoScenarios = errors.New("No scenarios in the file")
+
+// ErrNoExpectedSubtotal when a scenario does not tell the expected subtotal
+var ErrNoExpectedSubtotal = errors.New("Scenario without expected subtotal")
+
+// Results of a scenario
+const (
+	scenarioPass  = "pass"
+	scenarioFail  = "fail"
+	scenarioError = "error"
+)
+
+// scenarioFile is a suite of scenarios in YAML (or JSON)
+type scenarioFile struct {
+	Scenarios []scenario `yaml:"scenarios"`
+}
+
+// scenario lists the articles put in a cart, a repeated code adding one more, and the expected subtotal
+// Items are a list or a comma separated string like "VOUCHER, TSHIRT, VOUCHER", the subtotal an amount optionally
+// followed by the currency code or symbol like "25.00€"
+type scenario struct {
+	Name     string   `yaml:"name"`
+	Items    itemList `yaml:"items"`
+	Subtotal string   `yaml:"subtotal"`
+}
+
+type itemList []string
+
+// UnmarshalYAML accepts a list of codes or a comma separated string of codes
+func (l *itemList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		*l = splitCodes(s)
+		return nil
+	}
+	var codes []string
+	if err := unmarshal(&codes); err != nil {
+		return err
+	}
+	*l = codes
+	return nil
+}
+
+// splitCodes splits a comma separated string of article codes
+func splitCodes(s string) []string {
+	var codes []string
+	for _, code := range strings.Split(s, ",") {
+		if code = strings.TrimSpace(code); code != "" {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// scenarioResult is the outcome of a scenario
+type scenarioResult struct {
+	Name     string `json:"name"`
+	Result   string `json:"result"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func readScenarios(path string) ([]scenario, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f scenarioFile
+	if err := yaml.UnmarshalStrict(b, &f); err != nil {
+		return nil, err
+	}
+	if len(f.Scenarios) == 0 {
+		return nil, ErrNoScenarios
+	}
+	for i := range f.Scenarios {
+		s := &f.Scenarios[i]
+		if s.Name == "" {
+			s.Name = fmt.Sprintf("Scenario %d", i+1)
+		}
+		if strings.TrimSpace(s.Subtotal) == "" {
+			return nil, fmt.Errorf("%v: %s", ErrNoExpectedSubtotal, s.Name)
+		}
+	}
+	return f.Scenarios, nil
+}
+
+// runScenario prices the items in a new cart, deleted at the end
+// Every change is conditional to the ETag of the cart it follows so that servers requiring conditional changes accept them
+func runScenario(ctx context.Context, c client.Client, s scenario) scenarioResult {
+	r := scenarioResult{Name: s.Name, Expected: s.Subtotal}
+	crt, err := c.CreateCart(ctx)
+	if err != nil {
+		return r.failed(err)
+	}
+	id, etag := crt.ID, crt.ETag
+	defer func() { c.DeleteCart(ctx, id, etag) }()
+	var codes []string
+	quantities := make(map[string]int)
+	for _, code := range s.Items {
+		if quantities[code] == 0 {
+			codes = append(codes, code)
+		}
+		quantities[code]++
+	}
+	for i, code := range codes {
+		if i > 0 {
+			if crt, err = c.GetCart(ctx, id, ""); err != nil {
+				return r.failed(err)
+			}
+			etag = crt.ETag
+		}
+		if _, err := c.AddArticle(ctx, id, etag, code, quantities[code]); err != nil {
+			return r.failed(fmt.Errorf("Adding %s: %v", code, err))
+		}
+	}
+	if crt, err = c.GetCart(ctx, id, ""); err != nil {
+		return r.failed(err)
+	}
+	etag = crt.ETag
+	r.Actual = crt.Subtotal.StringAmount() + " " + crt.Subtotal.Currency()
+	match, err := matchSubtotal(s.Subtotal, crt.Subtotal)
+	if err != nil {
+		return r.failed(err)
+	}
+	r.Result = scenarioFail
+	if match {
+		r.Result = scenarioPass
+	}
+	return r
+}
+
+func (r scenarioResult) failed(err error) scenarioResult {
+	r.Result = scenarioError
+	r.Error = err.Error()
+	return r
+}
+
+// matchSubtotal tells if the subtotal is the expected amount, in the expected currency if given
+func matchSubtotal(expected string, subtotal money.Money) (bool, error) {
+	expected = strings.TrimSpace(expected)
+	end := strings.IndexFunc(expected, func(r rune) bool { return (r < '0' || r > '9') && r != '.' && r != '-' })
+	if end < 0 {
+		end = len(expected)
+	}
+	amount, err := money.Parse(expected[:end], subtotal.Currency())
+	if err != nil {
+		return false, fmt.Errorf("Invalid expected subtotal %q: %v", expected, err)
+	}
+	cur := strings.TrimSpace(expected[end:])
+	if cur != "" && !strings.EqualFold(cur, subtotal.Currency()) && cur != subtotal.Symbol() {
+		return false, nil
+	}
+	return amount.Cmp(subtotal) == 0, nil
+}
+
+func scenarioRecords(results []scenarioResult) records {
+	r := records{header: []string{"SCENARIO", "RESULT", "EXPECTED", "ACTUAL", "ERROR"}}
+	for _, res := range results {
+		r.rows = append(r.rows, []string{res.Name, res.Result, res.Expected, res.Actual, res.Error})
+	}
+	return r
+}
+
+// doRunScenarios runs the scenarios of the file printing a report, it fails if any scenario does not pass
+func doRunScenarios(a *App, path string) int {
+	scenarios, err := readScenarios(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid scenario file %s: %v\n", path, err)
+		return exitInvalid
+	}
+	c := a.client()
+	results := make([]scenarioResult, len(scenarios))
+	passed := 0
+	for i, s := range scenarios {
+		results[i] = runScenario(context.Background(), c, s)
+		if results[i].Result == scenarioPass {
+			passed++
+		}
+	}
+	code := exitOK
+	if passed < len(results) {
+		code = exitFailure
+	}
+	if a.structuredOutput() {
+		j, _ := json.Marshal(results)
+		if c := a.printOutput(j, scenarioRecords(results)); c != exitOK {
+			return c
+		}
+		return code
+	}
+	out := a.out()
+	for _, r := range results {
+		switch r.Result {
+		case scenarioPass:
+			fmt.Fprintf(out, "PASS  %s: %s\n", r.Name, r.Actual)
+		case scenarioFail:
+			fmt.Fprintf(out, "FAIL  %s: expected %s, got %s\n", r.Name, r.Expected, r.Actual)
+		default:
+			fmt.Fprintf(out, "ERROR %s: %s\n", r.Name, r.Error)
+		}
+	}
+	fmt.Fprintf(out, "%d scenarios: %d passed, %d failed\n", len(results), passed, len(results)-passed)
+	return code
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"shopping-cart-kata/money"
+	"strings"
+	"testing"
+)
+
+func TestMatchSubtotal(t *testing.T) {
+	subtotal := money.New(2500, "EUR")
+	tests := []struct {
+		expected string
+		exp      bool
+	}{
+		{"25.00", true},
+		{"25", true},
+		{"25.00€", true},
+		{" 25.00 EUR ", true},
+		{"25.00 eur", true},
+		{"25.00 USD", false},
+		{"25.01", false},
+	}
+	for _, test := range tests {
+		if match, err := matchSubtotal(test.expected, subtotal); match != test.exp || err != nil {
+			t.Errorf("Matching %q with %s: %t, %v instead of %t", test.expected, subtotal, match, err, test.exp)
+		}
+	}
+	if _, err := matchSubtotal("twenty", subtotal); err == nil {
+		t.Errorf("No error for an invalid expected subtotal")
+	}
+}
+
+func TestRunScenarios(t *testing.T) {
+	// The fake server prices every article 10.00 EUR and accepts only changes conditional to the current ETag
+	quantities := make(map[string]int)
+	version := 0
+	etag := func() string { return fmt.Sprintf(`"%d-0a1b2c3d"`, version) }
+	deleted := 0
+	hf := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.URL.Path != "/carts" && r.Header.Get("If-Match") != etag() {
+			t.Errorf("%s %s with If-Match %s instead of %s", r.Method, r.URL.Path, r.Header.Get("If-Match"), etag())
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "POST /carts":
+			for k := range quantities {
+				delete(quantities, k)
+			}
+			version = 1
+			respondWithPayload(w, http.StatusCreated, cart{ID: "ABC"}, etag())
+		case "POST /carts/ABC/items":
+			var art article
+			json.NewDecoder(r.Body).Decode(&art)
+			if art.ID == "NOPE" {
+				respondWithError(w, http.StatusUnprocessableEntity, "The article does not exist")
+				return
+			}
+			quantities[art.ID] += art.Quantity
+			version++
+			respondWithPayload(w, http.StatusCreated, art, "")
+		case "GET /carts/ABC":
+			n := 0
+			for _, q := range quantities {
+				n += q
+			}
+			respondWithPayload(w, http.StatusOK, cart{ID: "ABC", Subtotal: money.New(int64(n)*1000, "EUR")}, etag())
+		case "DELETE /carts/ABC":
+			deleted++
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(hf))
+	defer ts.Close()
+	dir, _ := ioutil.TempDir("", "scenarios")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "scenarios.yaml")
+	ioutil.WriteFile(path, []byte(`scenarios:
+  - name: Listed
+    items: [MUG, MUG, TSHIRT]
+    subtotal: 30.00€
+  - items: MUG, VOUCHER
+    subtotal: 25.00 EUR
+  - items: MUG, NOPE
+    subtotal: 20.00
+`), 0644)
+
+	var out bytes.Buffer
+	a := &App{BaseURL: ts.URL, HTTPClient: *ts.Client(), Out: &out}
+	if code := run(a, []string{"scenario", "run", path}); code != exitFailure {
+		t.Errorf("Failing scenarios exited with %d instead of %d", code, exitFailure)
+	}
+	exp := `PASS  Listed: 30.00 EUR
+FAIL  Scenario 2: expected 25.00 EUR, got 20.00 EUR
+ERROR Scenario 3: Adding NOPE: Invalid request (status 422): The article does not exist
+3 scenarios: 1 passed, 2 failed
+`
+	if out.String() != exp {
+		t.Errorf("Report\n%s\ninstead of\n%s", out.String(), exp)
+	}
+	if deleted != 3 {
+		t.Errorf("%d carts deleted instead of 3", deleted)
+	}
+
+	ioutil.WriteFile(path, []byte("scenarios:\n  - items: [MUG]\n    subtotal: 10\n"), 0644)
+	out.Reset()
+	if code := run(a, []string{"scenario", "run", path, "--output", "csv"}); code != exitOK {
+		t.Errorf("Passing scenarios exited with %d instead of %d", code, exitOK)
+	}
+	if !strings.HasSuffix(out.String(), "Scenario 1,pass,10,10.00 EUR,\n") {
+		t.Errorf("CSV report\n%s", out.String())
+	}
+
+	ioutil.WriteFile(path, []byte("scenarios:\n  - items: [MUG]\n    total: 10\n"), 0644)
+	if code := run(a, []string{"scenario", "run", path}); code != exitInvalid {
+		t.Errorf("Invalid scenario file exited with %d instead of %d", code, exitInvalid)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"shopping-cart-kata/promotion"
+)
+
+// adminTokenHeader carries the shared secret of the admin requests
+const adminTokenHeader = "X-Admin-Token"
+
+// errRulesNotSaved when a change of the rules cannot be saved into the rules file
+var errRulesNotSaved = errors.New("Promotion rules not saved")
+
+// adminOnly answers 403 while the admin API is disabled for lack of a token and 401 to requests without the right one
+func (a *App) adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.AdminToken == "" {
+			respondWithError(w, http.StatusForbidden, "The admin API is disabled")
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(adminTokenHeader)), []byte(a.AdminToken)) != 1 {
+			respondWithError(w, http.StatusUnauthorized, "The admin token is missing or wrong")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// changeRules applies an admin change of the rules and saves them into the rules file, if any, so that reloads and restarts keep it
+// The change is undone when the rules cannot be saved
+func (a *App) changeRules(change func() error) error {
+	a.rulesLock.Lock()
+	defer a.rulesLock.Unlock()
+	prev := a.AppSvc.PromEng.GetRuleDefs()
+	if err := change(); err != nil {
+		return err
+	}
+	if err := a.saveRules(); err != nil {
+		log.Printf("Promotion rules not saved into %s: %v", a.RulesFile, err)
+		if err := a.AppSvc.PromEng.ReplaceRules(prev); err != nil {
+			log.Printf("Promotion rules change not undone: %v", err)
+		}
+		return errRulesNotSaved
+	}
+	a.pricingChanged()
+	return nil
+}
+
+// saveRules replaces the rules file with the current rules, atomically so that the rules watcher never reads it half written
+func (a *App) saveRules() error {
+	if a.RulesFile == "" {
+		return nil
+	}
+	tmp, err := os.Create(filepath.Join(filepath.Dir(a.RulesFile), "."+filepath.Base(a.RulesFile)+".tmp"))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := promotion.WriteRules(tmp, a.AppSvc.PromEng.GetRuleDefs()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), a.RulesFile)
+}
+
+func (a *App) getPromotions(w http.ResponseWriter, r *http.Request) {
+	defs := a.AppSvc.PromEng.GetRuleDefs()
+	vms := make([]promotionVM, len(defs))
+	for i, d := range defs {
+		vm, err := fromRuleDef(d)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "The system encountered an unxepected condition")
+			return
+		}
+		vms[i] = vm
+	}
+	respondWithPayload(w, http.StatusOK, vms, "")
+}
+
+func (a *App) createPromotion(w http.ResponseWriter, r *http.Request) {
+	d, ok := decodeRuleDef(w, r)
+	if !ok {
+		return
+	}
+	err := a.changeRules(func() error {
+		_, err := a.AppSvc.PromEng.AddRuleDef(d)
+		return err
+	})
+	if ve, ok := err.(*promotion.ValidationError); ok {
+		respondWithValidationError(w, ve)
+		return
+	}
+	if err == promotion.ErrDuplicateRule {
+		respondWithError(w, http.StatusConflict, "A promotion with that code already exists")
+		return
+	}
+	if err == errRulesNotSaved {
+		respondWithRulesNotSaved(w)
+		return
+	}
+	vm, err := fromRuleDef(d)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "The system encountered an unxepected condition")
+		return
+	}
+	w.Header().Set("Location", vm.URL)
+	respondWithPayload(w, http.StatusCreated, vm, "")
+}
+
+func (a *App) getPromotion(w http.ResponseWriter, r *http.Request) {
+	d, ok := a.AppSvc.PromEng.GetRuleDef(mux.Vars(r)["code"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	respondWithRuleDef(w, http.StatusOK, d)
+}
+
+func (a *App) updatePromotion(w http.ResponseWriter, r *http.Request) {
+	code := mux.Vars(r)["code"]
+	d, ok := decodeRuleDef(w, r)
+	if !ok {
+		return
+	}
+	if d.Code == "" {
+		d.Code = code
+	}
+	if d.Code != code {
+		respondWithError(w, http.StatusUnprocessableEntity, "The promotion code cannot be changed")
+		return
+	}
+	err := a.changeRules(func() error { return a.AppSvc.PromEng.UpdateRuleDef(d) })
+	if ve, ok := err.(*promotion.ValidationError); ok {
+		respondWithValidationError(w, ve)
+		return
+	}
+	if err == promotion.ErrRuleNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err == errRulesNotSaved {
+		respondWithRulesNotSaved(w)
+		return
+	}
+	respondWithRuleDef(w, http.StatusOK, d)
+}
+
+func (a *App) enablePromotion(w http.ResponseWriter, r *http.Request) {
+	a.setPromotionEnabled(w, r, true)
+}
+
+func (a *App) disablePromotion(w http.ResponseWriter, r *http.Request) {
+	a.setPromotionEnabled(w, r, false)
+}
+
+func (a *App) setPromotionEnabled(w http.ResponseWriter, r *http.Request, enabled bool) {
+	code := mux.Vars(r)["code"]
+	err := a.changeRules(func() error { return a.AppSvc.PromEng.EnableRule(code, enabled) })
+	if err == promotion.ErrRuleNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err == errRulesNotSaved {
+		respondWithRulesNotSaved(w)
+		return
+	}
+	d, _ := a.AppSvc.PromEng.GetRuleDef(code)
+	respondWithRuleDef(w, http.StatusOK, d)
+}
+
+func (a *App) deletePromotion(w http.ResponseWriter, r *http.Request) {
+	err := a.changeRules(func() error { return a.AppSvc.PromEng.DelRuleDef(mux.Vars(r)["code"]) })
+	if err == promotion.ErrRuleNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err == errRulesNotSaved {
+		respondWithRulesNotSaved(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) replacePromotions(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	defs, err := promotion.ParseRules(r.Body)
+	if ve, ok := err.(*promotion.ValidationError); ok {
+		respondWithValidationError(w, ve)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Request payload cannot be decoded")
+		return
+	}
+	err = a.changeRules(func() error { return a.AppSvc.PromEng.ReplaceRules(defs) })
+	if ve, ok := err.(*promotion.ValidationError); ok {
+		respondWithValidationError(w, ve)
+		return
+	}
+	if err == errRulesNotSaved {
+		respondWithRulesNotSaved(w)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "The system encountered an unxepected condition")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) getCacheStats(w http.ResponseWriter, r *http.Request) {
+	respondWithPayload(w, http.StatusOK, a.CartCache.Stats(), "")
+}
+
+func decodeRuleDef(w http.ResponseWriter, r *http.Request) (promotion.RuleDef, bool) {
+	var d promotion.RuleDef
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	defer r.Body.Close()
+	if err := decoder.Decode(&d); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Request payload cannot be decoded")
+		return d, false
+	}
+	return d, true
+}
+
+func respondWithRuleDef(w http.ResponseWriter, statusCode int, d promotion.RuleDef) {
+	vm, err := fromRuleDef(d)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "The system encountered an unxepected condition")
+		return
+	}
+	respondWithPayload(w, statusCode, vm, "")
+}
+
+func respondWithValidationError(w http.ResponseWriter, ve *promotion.ValidationError) {
+	vm := struct {
+		Msg  string                     `json:"error"`
+		Rule *promotion.ValidationError `json:"rule"`
+	}{Msg: ve.Error(), Rule: ve}
+	respondWithPayload(w, http.StatusUnprocessableEntity, vm, "")
+}
+
+func respondWithRulesNotSaved(w http.ResponseWriter) {
+	respondWithError(w, http.StatusInternalServerError, "The promotion rules cannot be saved, the change was undone")
+}
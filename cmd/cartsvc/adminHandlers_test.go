@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"shopping-cart-kata/cache"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAdminToken = "s3cr3t"
+
+// newAdminRequest creates a request carrying the admin token of the test apps
+func newAdminRequest(method string, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err == nil {
+		req.Header.Set(adminTokenHeader, testAdminToken)
+	}
+	return req, err
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	a := testApp(cache.NewCache())
+	for token, status := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusUnauthorized, testAdminToken: http.StatusOK} {
+		req, _ := http.NewRequest("GET", "http://127.0.0.1/admin/cache", nil)
+		req.Header.Set(adminTokenHeader, token)
+		checkResponseCode(t, status, executeRequest(a, req))
+	}
+	req, _ := newAdminRequest("POST", "http://127.0.0.1/admin/promotions/VOUCHER2X1/disable", nil)
+	req.Header.Del(adminTokenHeader)
+	checkResponseCode(t, http.StatusUnauthorized, executeRequest(a, req))
+	if d, _ := a.AppSvc.PromEng.GetRuleDef("VOUCHER2X1"); d.Disabled {
+		t.Error("Promotion disabled without the admin token")
+	}
+	a.AdminToken = ""
+	req, _ = newAdminRequest("GET", "http://127.0.0.1/admin/promotions", nil)
+	checkResponseCode(t, http.StatusForbidden, executeRequest(a, req))
+}
+
+func TestAdminChangesAreSavedToRulesFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "rules")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rules.json")
+	sample, _ := ioutil.ReadFile("../../rules.json")
+	ioutil.WriteFile(path, sample, 0644)
+	a := testApp(cache.NewCache())
+	a.RulesFile = path
+	watcher := newRulesWatcher(path, time.Hour, a.ReplaceRules)
+
+	rule := `{ "code": "MUG10", "type": "percentOff", "articles": ["MUG"], "percent": 10 }`
+	req, _ := newAdminRequest("POST", "http://127.0.0.1/admin/promotions", strings.NewReader(rule))
+	checkResponseCode(t, http.StatusCreated, executeRequest(a, req))
+	req, _ = newAdminRequest("DELETE", "http://127.0.0.1/admin/promotions/VOUCHER2X1", nil)
+	checkResponseCode(t, http.StatusNoContent, executeRequest(a, req))
+	watcher.check()
+	if _, ok := a.AppSvc.PromEng.GetRuleDef("MUG10"); !ok {
+		t.Error("Created promotion lost when the rules file was reloaded")
+	}
+	defs := loadRuleDefs(path)
+	if len(defs) != 2 || defs[0].Code != "TSHIRT3PLUS" || defs[1].Code != "MUG10" {
+		t.Errorf("Rules file %v instead of TSHIRT3PLUS and MUG10", defs)
+	}
+
+	a.RulesFile = filepath.Join(dir, "missing", "rules.json")
+	req, _ = newAdminRequest("POST", "http://127.0.0.1/admin/promotions/MUG10/disable", nil)
+	checkResponseCode(t, http.StatusInternalServerError, executeRequest(a, req))
+	if d, _ := a.AppSvc.PromEng.GetRuleDef("MUG10"); d.Disabled {
+		t.Error("Promotion disabled although the rules were not saved")
+	}
+}
+
+func TestPromotionsCRUD(t *testing.T) {
+	const base = "http://127.0.0.1/admin/promotions"
+	a := testApp(cache.NewCache())
+
+	req, _ := newAdminRequest("GET", base, nil)
+	response := executeRequest(a, req)
+	checkResponseCode(t, http.StatusOK, response)
+	var list []promotionVM
+	json.NewDecoder(response.Body).Decode(&list)
+	if len(list) != 2 {
+		t.Fatalf("Listed %d promotions instead of 2", len(list))
+	}
+
+	rule := `{ "code": "MUG10", "type": "percentOff", "articles": ["MUG"], "percent": 10 }`
+	req, _ = newAdminRequest("POST", base, strings.NewReader(rule))
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusCreated, response)
+	url := response.Header().Get("Location")
+	if !strings.HasSuffix(url, "/admin/promotions/MUG10") {
+		t.Errorf("Location %q does not point to the created promotion", url)
+	}
+	req, _ = newAdminRequest("POST", base, strings.NewReader(rule))
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusConflict, response)
+
+	req, _ = newAdminRequest("PUT", url, strings.NewReader(`{ "type": "percentOff", "articles": ["MUG"], "percent": 20 }`))
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusOK, response)
+	req, _ = newAdminRequest("GET", url, nil)
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusOK, response)
+	var p promotionVM
+	json.NewDecoder(response.Body).Decode(&p)
+	if p.Percent.String() != "20.00%" {
+		t.Errorf("Updated promotion percent %s instead of 20.00%%", p.Percent)
+	}
+
+	req, _ = newAdminRequest("POST", url+"/disable", nil)
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusOK, response)
+	json.NewDecoder(response.Body).Decode(&p)
+	if !p.Disabled {
+		t.Errorf("Promotion %v not disabled", p)
+	}
+
+	req, _ = newAdminRequest("DELETE", url, nil)
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusNoContent, response)
+	req, _ = newAdminRequest("GET", url, nil)
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusNotFound, response)
+}
+
+func TestPromotionValidationErrors(t *testing.T) {
+	a := testApp(new(uncache))
+	rule := `{ "code": "MUG10", "type": "percentOff", "articles": [] }`
+	req, _ := newAdminRequest("POST", "http://127.0.0.1/admin/promotions", strings.NewReader(rule))
+	response := executeRequest(a, req)
+	checkResponseCode(t, http.StatusUnprocessableEntity, response)
+	var body struct {
+		Rule struct {
+			Code   string `json:"code"`
+			Fields []struct {
+				Field string `json:"field"`
+			} `json:"fields"`
+		} `json:"rule"`
+	}
+	json.NewDecoder(response.Body).Decode(&body)
+	if body.Rule.Code != "MUG10" || len(body.Rule.Fields) != 2 {
+		t.Errorf("Validation error %+v does not report articles and percent", body)
+	}
+	req, _ = newAdminRequest("PUT", "http://127.0.0.1/admin/promotions/NOPE", strings.NewReader(rule))
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusUnprocessableEntity, response)
+}
+
+func TestPromotionsInOtherCurrency(t *testing.T) {
+	a := testApp(new(uncache))
+	rule := `{ "code": "MUGS", "type": "multibuy", "articles": ["MUG"], "minQuantity": 2, "unitPrice": { "amount": "5.00", "currency": "USD" } }`
+	req, _ := newAdminRequest("POST", "http://127.0.0.1/admin/promotions", strings.NewReader(rule))
+	checkResponseCode(t, http.StatusUnprocessableEntity, executeRequest(a, req))
+	req, _ = newAdminRequest("PUT", "http://127.0.0.1/admin/promotions", strings.NewReader(`{ "rules": [`+rule+`] }`))
+	response := executeRequest(a, req)
+	checkResponseCode(t, http.StatusUnprocessableEntity, response)
+	var body struct {
+		Rule struct {
+			Code string `json:"code"`
+		} `json:"rule"`
+	}
+	json.NewDecoder(response.Body).Decode(&body)
+	if body.Rule.Code != "MUGS" {
+		t.Errorf("Validation error %+v does not report the rule", body)
+	}
+	if defs := a.AppSvc.PromEng.GetRuleDefs(); len(defs) != 2 {
+		t.Errorf("Rules replaced by a rule set in another currency: %v", defs)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	a := testApp(cache.NewLRUCache(10, 0))
+	req, _ := http.NewRequest("POST", "http://127.0.0.1/carts", nil)
+	location := executeRequest(a, req).Header().Get("Location")
+	req, _ = http.NewRequest("GET", location, nil)
+	etag := executeRequest(a, req).Header().Get("ETag")
+	req, _ = http.NewRequest("GET", location, nil)
+	req.Header.Set("If-None-Match", etag)
+	checkResponseCode(t, http.StatusNotModified, executeRequest(a, req))
+
+	req, _ = newAdminRequest("GET", "http://127.0.0.1/admin/cache", nil)
+	response := executeRequest(a, req)
+	checkResponseCode(t, http.StatusOK, response)
+	var stats cache.Stats
+	json.NewDecoder(response.Body).Decode(&stats)
+	if stats.Hits != 1 || stats.Entries != 1 {
+		t.Errorf("Cache stats %+v instead of one hit on one entry", stats)
+	}
+}
@@ -6,6 +6,10 @@ import (
 	"net/http"
 	"shopping-cart-kata/appservice"
 	"shopping-cart-kata/cache"
+	"shopping-cart-kata/promotion"
+	"shopping-cart-kata/subscription"
+	"sync"
+	"time"
 )
 
 // App is the web api application
@@ -14,6 +18,44 @@ type App struct {
 	HashGen   *hashids.HashID
 	Router    *mux.Router
 	CartCache cache.Cache
+	Subs      subscription.Manager
+	// RequireIfMatch rejects the changes of carts not conditional to an entity tag
+	RequireIfMatch bool
+	// AdminToken is the shared secret admin requests send in X-Admin-Token, the admin API is disabled when empty
+	AdminToken string
+	// RulesFile receives the promotion rules changed through the admin API, which live in memory only when empty
+	RulesFile string
+
+	rulesLock sync.Mutex
+
+	pricingLock sync.RWMutex
+	pricing     pricingState
+}
+
+// ReplaceRules atomically swaps the promotion rule set and invalidates cached carts since prices changed
+func (a *App) ReplaceRules(defs []promotion.RuleDef) error {
+	a.rulesLock.Lock()
+	defer a.rulesLock.Unlock()
+	if err := a.AppSvc.PromEng.ReplaceRules(defs); err != nil {
+		return err
+	}
+	a.pricingChanged()
+	return nil
+}
+
+// pricingChanged updates the pricing tag and invalidates cached carts after a change of rules or prices
+func (a *App) pricingChanged() {
+	a.updatePricing(time.Now())
+	a.CartCache.Clear()
+}
+
+// EvictCart removes the cached representation of a cart
+func (a *App) EvictCart(id int64) {
+	wid, err := a.encode(id)
+	if err != nil {
+		return
+	}
+	a.CartCache.Remove(wid)
 }
 
 // Run runs the application
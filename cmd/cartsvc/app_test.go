@@ -11,8 +11,10 @@ import (
 	"shopping-cart-kata/appservice"
 	"shopping-cart-kata/cache"
 	"shopping-cart-kata/cart"
+	"shopping-cart-kata/money"
 	"strings"
 	"testing"
+	"time"
 )
 
 type uncache struct{}
@@ -30,6 +32,14 @@ func (c *uncache) Remove(wid string) {
 	return
 }
 
+func (c *uncache) Clear() {
+	return
+}
+
+func (c *uncache) Stats() cache.Stats {
+	return cache.Stats{}
+}
+
 func TestHash(t *testing.T) {
 	const id = 1
 	a := testApp(new(uncache))
@@ -120,19 +130,47 @@ func TestUnsuccessfulSetArticleQuantity(t *testing.T) {
 	checkResponseCode(t, http.StatusUnprocessableEntity, response)
 
 	item.ID = art.Code
-	item.Quantity = 0
+	item.Quantity = -2
 	j, _ = json.Marshal(item)
 	b = bytes.NewBuffer(j)
 	req, _ = http.NewRequest("PUT", url, b)
 	response = executeRequest(a, req)
 	checkResponseCode(t, http.StatusUnprocessableEntity, response)
+}
 
-	item.Quantity = -2
-	j, _ = json.Marshal(item)
-	b = bytes.NewBuffer(j)
-	req, _ = http.NewRequest("PUT", url, b)
+func TestRemoveArticle(t *testing.T) {
+	a := testApp(new(uncache))
+	req, _ := http.NewRequest("POST", "http://127.0.0.1/carts", nil)
+	response := executeRequest(a, req)
+	var c cartVM
+	json.NewDecoder(response.Body).Decode(&c)
+	url := fmt.Sprintf("%s/items", c.URL)
+	for _, code := range []string{"VOUCHER", "TSHIRT"} {
+		j, _ := json.Marshal(itemCreateVM{ID: code, Quantity: 2})
+		req, _ = http.NewRequest("POST", url, bytes.NewBuffer(j))
+		response = executeRequest(a, req)
+		checkResponseCode(t, http.StatusCreated, response)
+	}
+
+	req, _ = http.NewRequest("DELETE", url+"/VOUCHER", nil)
 	response = executeRequest(a, req)
-	checkResponseCode(t, http.StatusUnprocessableEntity, response)
+	checkResponseCode(t, http.StatusNoContent, response)
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusNotFound, response)
+
+	j, _ := json.Marshal(itemCreateVM{ID: "TSHIRT", Quantity: 0})
+	req, _ = http.NewRequest("PUT", url, bytes.NewBuffer(j))
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusOK, response)
+
+	req, _ = http.NewRequest("GET", c.URL, nil)
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusOK, response)
+	var dc cartVM
+	json.NewDecoder(response.Body).Decode(&dc)
+	if len(dc.Items) != 0 {
+		t.Errorf("Cart items %v instead of none", dc.Items)
+	}
 }
 
 func TestGetCartWithArticles(t *testing.T) {
@@ -182,7 +220,7 @@ func TestGetCartWithArticles(t *testing.T) {
 	if dc.ID != c.ID {
 		t.Errorf("Cart ID %s instead of %s\n%s", dc.ID, c.ID, respBody)
 	}
-	if dc.Subtotal == 0 {
+	if dc.Subtotal.IsZero() {
 		t.Errorf("Subtotal 0\n%s", respBody)
 	}
 	if !strings.Contains(dc.URL, c.ID) {
@@ -234,8 +272,8 @@ func TestGetCreatedCartWithNormalReq(t *testing.T) {
 	if dc.ID != c.ID {
 		t.Errorf("Cart ID %s instead of %s\n%s", dc.ID, c.ID, respBody)
 	}
-	if dc.Subtotal != 0 {
-		t.Errorf("Subtotal %g instead of 0\n%s", dc.Subtotal, respBody)
+	if !dc.Subtotal.IsZero() {
+		t.Errorf("Subtotal %s instead of 0\n%s", dc.Subtotal, respBody)
 	}
 	if !strings.Contains(dc.URL, c.ID) {
 		t.Errorf("URL %s does not contain ID %s\n%s", dc.URL, c.ID, respBody)
@@ -287,10 +325,17 @@ func TestDeletedCachedCart(t *testing.T) {
 
 func TestDeleteNonCachedCart(t *testing.T) {
 	a := testApp(new(uncache))
-	req, _ := http.NewRequest("DELETE", "http://127.0.0.1/carts/1", nil)
-	req.Header.Add("If-Match", "fakeEtag")
+	wid, _ := a.encode(1)
+	req, _ := http.NewRequest("DELETE", "http://127.0.0.1/carts/"+wid, nil)
+	req.Header.Add("If-Match", `"1-fake"`)
 	response := executeRequest(a, req)
 	checkResponseCode(t, http.StatusPreconditionFailed, response)
+
+	// Preconditions are ignored when the response without them would not be 2xx or 412
+	req, _ = http.NewRequest("DELETE", "http://127.0.0.1/carts/1", nil)
+	req.Header.Add("If-Match", "fakeEtag")
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusNotFound, response)
 }
 
 func TestGetDeletedCart(t *testing.T) {
@@ -312,18 +357,180 @@ func TestGetDeletedCart(t *testing.T) {
 	checkResponseCode(t, http.StatusNotFound, response)
 }
 
+func TestGetExpiredCart(t *testing.T) {
+	c := cache.NewCache()
+	a := testApp(c)
+	a.AppSvc.CartTTL = time.Millisecond
+	req, _ := http.NewRequest("POST", "http://127.0.0.1/carts", nil)
+	response := executeRequest(a, req)
+	var cv cartVM
+	json.NewDecoder(response.Body).Decode(&cv)
+	req, _ = http.NewRequest("GET", cv.URL, nil)
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusOK, response)
+	etag := response.Header().Get("ETag")
+
+	time.Sleep(5 * time.Millisecond)
+	newCartSweeper(time.Hour, a.AppSvc.ExpireCarts, a.EvictCart).sweep()
+	if _, ok := c.GetByEtagWithID(etag, cv.ID); ok {
+		t.Errorf("Expired cart still cached")
+	}
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusGone, response)
+}
+
+func TestCartHistoryAndTimeTravel(t *testing.T) {
+	a := testApp(new(uncache))
+	req, _ := http.NewRequest("POST", "http://127.0.0.1/carts", nil)
+	response := executeRequest(a, req)
+	var c cartVM
+	json.NewDecoder(response.Body).Decode(&c)
+	req, _ = http.NewRequest("GET", c.URL+"/history", nil)
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusNotImplemented, response)
+
+	a.AppSvc.CartDB = cart.NewEventSourcedStore(cart.NewEventStore())
+	req, _ = http.NewRequest("POST", "http://127.0.0.1/carts", nil)
+	response = executeRequest(a, req)
+	json.NewDecoder(response.Body).Decode(&c)
+	j, _ := json.Marshal(itemCreateVM{ID: "MUG", Quantity: 2})
+	req, _ = http.NewRequest("POST", c.URL+"/items", bytes.NewBuffer(j))
+	executeRequest(a, req)
+	at := time.Now().UTC().Format(time.RFC3339Nano)
+	time.Sleep(5 * time.Millisecond)
+	req, _ = http.NewRequest("DELETE", c.URL+"/items/MUG", nil)
+	executeRequest(a, req)
+
+	req, _ = http.NewRequest("GET", c.URL+"/history", nil)
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusOK, response)
+	var h historyVM
+	json.NewDecoder(response.Body).Decode(&h)
+	if len(h.Events) != 3 || h.Events[1].Type != cart.ArticleAdded || h.Events[2].Type != cart.ArticleRemoved {
+		t.Errorf("History %v instead of creation, addition and removal", h)
+	}
+
+	req, _ = http.NewRequest("GET", c.URL+"?at="+at, nil)
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusOK, response)
+	var past cartVM
+	json.NewDecoder(response.Body).Decode(&past)
+	if len(past.Items) != 1 || past.Subtotal != money.MustParse("15.00", "EUR") {
+		t.Errorf("Cart at %s is %v instead of containing 2 MUG", at, past)
+	}
+	req, _ = http.NewRequest("GET", c.URL+"?at=yesterday", nil)
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusBadRequest, response)
+
+	restarted := testApp(new(uncache))
+	restarted.AppSvc.CartDB = a.AppSvc.CartDB
+	req, _ = http.NewRequest("GET", c.URL+"?at="+at, nil)
+	response = executeRequest(restarted, req)
+	checkResponseCode(t, http.StatusUnprocessableEntity, response)
+}
+
+func TestSampleRulesFileMatchesDefaults(t *testing.T) {
+	defs := loadRuleDefs("../../rules.json")
+	exp := defaultRuleDefs()
+	if len(defs) != len(exp) {
+		t.Fatalf("Sample rules file contains %d rules instead of %d", len(defs), len(exp))
+	}
+	for i, d := range defs {
+		if d.Code != exp[i].Code || d.Type != exp[i].Type {
+			t.Errorf("Sample rule %s (%s) instead of %s (%s)", d.Code, d.Type, exp[i].Code, exp[i].Type)
+		}
+	}
+}
+
+func TestReplacePromotionsInvalidatesCachedCarts(t *testing.T) {
+	a := testApp(cache.NewCache())
+	req, _ := http.NewRequest("POST", "http://127.0.0.1/carts", nil)
+	response := executeRequest(a, req)
+	var c cartVM
+	json.NewDecoder(response.Body).Decode(&c)
+	etag := response.Header().Get("ETag")
+
+	rules := `{ "rules": [ { "code": "MUG10", "type": "percentOff", "articles": ["MUG"] } ] }`
+	req, _ = newAdminRequest("PUT", "http://127.0.0.1/admin/promotions", strings.NewReader(rules))
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusUnprocessableEntity, response)
+	req, _ = http.NewRequest("GET", c.URL, nil)
+	req.Header.Add("If-None-Match", etag)
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusNotModified, response)
+
+	rules = `{ "rules": [ { "code": "MUG10", "type": "percentOff", "articles": ["MUG"], "percent": 10 } ] }`
+	req, _ = newAdminRequest("PUT", "http://127.0.0.1/admin/promotions", strings.NewReader(rules))
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusNoContent, response)
+	req, _ = http.NewRequest("GET", c.URL, nil)
+	req.Header.Add("If-None-Match", etag)
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusOK, response)
+}
+
+func TestGetCartWithAppliedPromotions(t *testing.T) {
+	a := testApp(new(uncache))
+	req, _ := http.NewRequest("POST", "http://127.0.0.1/carts", nil)
+	response := executeRequest(a, req)
+	var c cartVM
+	json.NewDecoder(response.Body).Decode(&c)
+	j, _ := json.Marshal(itemCreateVM{ID: "VOUCHER", Quantity: 2})
+	req, _ = http.NewRequest("POST", fmt.Sprintf("%s/items", c.URL), bytes.NewBuffer(j))
+	executeRequest(a, req)
+
+	req, _ = http.NewRequest("GET", c.URL, nil)
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusOK, response)
+	var dc cartVM
+	json.NewDecoder(response.Body).Decode(&dc)
+	if len(dc.Promotions) != 1 {
+		t.Fatalf("Applied promotions %v instead of the voucher one", dc.Promotions)
+	}
+	p := dc.Promotions[0]
+	if p.RuleCode != "VOUCHER2X1" || p.ItemID != "VOUCHER" || p.Quantity != 1 || p.Saving != money.New(500, "EUR") {
+		t.Errorf("Applied promotion %+v instead of 1 free VOUCHER", p)
+	}
+}
+
+func TestGetDegradedCart(t *testing.T) {
+	a := testApp(new(uncache))
+	broken := func(c cart.Cart, prices map[string]money.Money) []interface{} {
+		panic("boom")
+	}
+	a.AppSvc.PromEng.AddRule(&broken)
+	req, _ := http.NewRequest("POST", "http://127.0.0.1/carts", nil)
+	response := executeRequest(a, req)
+	var c cartVM
+	json.NewDecoder(response.Body).Decode(&c)
+
+	req, _ = http.NewRequest("GET", c.URL, nil)
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusInternalServerError, response)
+
+	a.AppSvc.PromoErrPolicy = appservice.SkipFailingPromo
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusOK, response)
+	var dc cartVM
+	json.NewDecoder(response.Body).Decode(&dc)
+	if !dc.Degraded || len(dc.Reasons) != 1 {
+		t.Errorf("Cart %v not flagged as degraded", dc)
+	}
+}
+
 func testApp(c cache.Cache) *App {
 	cfg := Config{HashSalt: "a9a21fd753f94", ListenAddress: "127.0.0.1"}
 	a := &App{
 		AppSvc: appservice.AppService{
-			CartIDG: new(generator),
+			CartIDG: newGenerator(1),
 			CartDB:  cart.NewStore(),
 			Catalog: createCatalog(),
-			PromEng: createPromoEngine(),
+			PromEng: createPromoEngine(""),
 		},
-		HashGen:   createHashGenerator(cfg.HashSalt),
-		Router:    mux.NewRouter().StrictSlash(true),
-		CartCache: c,
+		HashGen:    createHashGenerator(cfg.HashSalt),
+		Router:     mux.NewRouter().StrictSlash(true),
+		CartCache:  c,
+		AdminToken: testAdminToken,
 	}
 	a.ConfigRoutes(cfg.ListenAddress)
 	a.ConfigURLBuilders()
@@ -341,3 +548,15 @@ func checkResponseCode(t *testing.T, expected int, response *httptest.ResponseRe
 		t.Fatalf("Expected status code %d instead of %d\n%s", expected, response.Code, response.Body)
 	}
 }
+
+func TestCartIDHashRoundTrip(t *testing.T) {
+	a := testApp(cache.NewCache())
+	id := newGenerator(42).NextID()
+	hash, err := a.encode(id)
+	if err != nil {
+		t.Fatalf("Error encoding ID %d: %v", id, err)
+	}
+	if got, err := a.decode(hash); err != nil || got != id {
+		t.Errorf("Hash %s decoded to %d, %v instead of %d", hash, got, err, id)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"shopping-cart-kata/money"
+	"shopping-cart-kata/pricedcart"
+)
+
+type appliedPromotionVM struct {
+	RuleID   int64       `json:"ruleId"`
+	RuleCode string      `json:"ruleCode,omitempty"`
+	Label    string      `json:"label,omitempty"`
+	ItemID   string      `json:"itemId,omitempty"`
+	Quantity int         `json:"quantity"`
+	Saving   money.Money `json:"saving"`
+}
+
+func fromAppliedPromotion(ap pricedcart.AppliedPromotion) appliedPromotionVM {
+	return appliedPromotionVM{
+		RuleID:   ap.Rule.ID,
+		RuleCode: ap.Rule.Code,
+		Label:    ap.Rule.Label,
+		ItemID:   ap.ItemID,
+		Quantity: ap.Quantity,
+		Saving:   ap.Saving,
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// cartSweeper periodically evicts the expired carts and their cached representations
+type cartSweeper struct {
+	interval time.Duration
+	expire   func() ([]int64, error)
+	evict    func(id int64)
+	stop     chan struct{}
+}
+
+func newCartSweeper(interval time.Duration, expire func() ([]int64, error), evict func(id int64)) *cartSweeper {
+	return &cartSweeper{interval: interval, expire: expire, evict: evict, stop: make(chan struct{})}
+}
+
+// Start sweeps in background until Stop is called
+func (s *cartSweeper) Start() {
+	go func() {
+		t := time.NewTicker(s.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-t.C:
+				s.sweep()
+			}
+		}
+	}()
+}
+
+// Stop stops sweeping
+func (s *cartSweeper) Stop() {
+	close(s.stop)
+}
+
+func (s *cartSweeper) sweep() {
+	ids, err := s.expire()
+	for _, id := range ids {
+		s.evict(id)
+	}
+	if err != nil {
+		log.Printf("Unable to expire carts: %v", err)
+		return
+	}
+	if len(ids) > 0 {
+		log.Printf("Expired %d carts", len(ids))
+	}
+}
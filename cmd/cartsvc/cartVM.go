@@ -1,18 +1,19 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
-	"hash/crc32"
+	"shopping-cart-kata/money"
 	"shopping-cart-kata/pricedcart"
 )
 
 type cartVM struct {
-	ID       string      `json:"id"`
-	Subtotal float64     `json:"subTotal"`
-	Items    []itemGetVM `json:"items"`
-	URL      string      `json:"url"`
-	etag     string
+	ID         string               `json:"id"`
+	Subtotal   money.Money          `json:"subTotal"`
+	Items      []itemGetVM          `json:"items"`
+	Promotions []appliedPromotionVM `json:"promotions"`
+	URL        string               `json:"url"`
+	Degraded   bool                 `json:"degraded"`
+	Reasons    []string             `json:"degradedReasons,omitempty"`
+	val        validators
 }
 
 func fromPricedCart(pc pricedcart.PricedCart, wid string, url string) cartVM {
@@ -24,20 +25,17 @@ func fromPricedCart(pc pricedcart.PricedCart, wid string, url string) cartVM {
 	for i, pci := range pcItems {
 		c.Items[i] = fromPricedItem(pci)
 	}
+	applied := pc.GetAppliedPromotions()
+	c.Promotions = make([]appliedPromotionVM, len(applied))
+	for i, ap := range applied {
+		c.Promotions[i] = fromAppliedPromotion(ap)
+	}
 	c.URL = url
+	c.Reasons = pc.GetDegradedReasons()
+	c.Degraded = len(c.Reasons) > 0
 	return c
 }
 
 func (c *cartVM) GetEtag() string {
-	return c.etag
-}
-
-func (c *cartVM) ComputeEtag() {
-	data, err := json.Marshal(c)
-	if err != nil {
-		c.etag = ""
-		return
-	}
-	crc := crc32.ChecksumIEEE(data)
-	c.etag = fmt.Sprintf(`W/"%s-%d-%08X"`, "cart", len(data), crc)
+	return c.val.etag
 }
@@ -4,45 +4,276 @@ import (
 	"flag"
 	"github.com/gorilla/mux"
 	"github.com/speps/go-hashids"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"shopping-cart-kata/appservice"
 	"shopping-cart-kata/cache"
 	"shopping-cart-kata/cart"
 	"shopping-cart-kata/catalog"
+	"shopping-cart-kata/money"
 	"shopping-cart-kata/promotion"
+	"shopping-cart-kata/publisher"
+	"shopping-cart-kata/subscription"
+	"syscall"
+	"time"
 )
 
 func main() {
 	cfg := loadConfig()
 	a := createApp(cfg)
+	pub, sinkClosers := createPublisher(cfg, a.Subs)
+	a.AppSvc.Publisher = pub
 	a.ConfigRoutes(cfg.Authority)
 	a.ConfigURLBuilders()
+	if cfg.RulesFile != "" && cfg.RulesPoll > 0 {
+		newRulesWatcher(cfg.RulesFile, cfg.RulesPoll, a.ReplaceRules).Start()
+	}
+	if cfg.CartTTL > 0 && cfg.SweepInterval > 0 {
+		newCartSweeper(cfg.SweepInterval, a.AppSvc.ExpireCarts, a.EvictCart).Start()
+	}
+	closers := append([]io.Closer{pub}, sinkClosers...)
+	closers = append(closers, a.Subs)
+	if c, ok := a.AppSvc.CartDB.(io.Closer); ok {
+		closers = append(closers, c)
+	}
+	closeOnSignal(closers...)
 	a.Run(cfg.ListenAddress)
 }
 
+// closeOnSignal delivers the pending events and flushes the persisted carts before exiting on interrupt or termination
+func closeOnSignal(closers ...io.Closer) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		code := 0
+		for _, c := range closers {
+			if err := c.Close(); err != nil {
+				log.Printf("Error closing %T: %v", c, err)
+				code = 1
+			}
+		}
+		os.Exit(code)
+	}()
+}
+
 func loadConfig() Config {
 	var hashSalt = flag.String("salt", "a9a21fd753f9431381c3980c7664aab6", "Hash salt for REST IDs")
 	var listenAddress = flag.String("listen", "127.0.0.1:8000", "Address:port on which to listen")
 	var authority = flag.String("authority", "127.0.0.1:8000", "Authority part of REST URLs")
+	var rulesFile = flag.String("rules", "", "JSON file of promotion rules (built-in rules if empty)")
+	var rulesPoll = flag.Duration("rulesPoll", 5*time.Second, "Interval between rules file change checks (0 disables reload)")
+	var promoErrors = flag.String("promoErrors", "fail", "On promotion rule errors fail the request (fail) or skip the rule flagging the cart as degraded (skip)")
+	var nodeID = flag.Uint("node", 0, "Node id (0-65535) making cart IDs unique across instances")
+	var storeDir = flag.String("storeDir", "", "Directory where carts are persisted (in memory if empty)")
+	var eventSourced = flag.Bool("eventSourced", false, "Store carts as streams of events (in -storeDir if given)")
+	var fsync = flag.String("fsync", "always", "When persisted carts are flushed to disk: on every change (always), every fsyncInterval (interval) or by the OS (never)")
+	var fsyncInterval = flag.Duration("fsyncInterval", time.Second, "Interval between flushes with -fsync=interval")
+	var snapshotEvery = flag.Int("snapshotEvery", 1000, "Changes after which persisted carts are snapshotted (0 disables snapshots)")
+	var cartTTL = flag.Duration("cartTTL", 0, "How long a cart lives after its last change (0 means forever)")
+	var sweepInterval = flag.Duration("sweepInterval", time.Minute, "Interval between evictions of expired carts")
+	var eventsStdout = flag.Bool("eventsStdout", false, "Publish cart events to the standard output as JSON lines")
+	var eventsFile = flag.String("eventsFile", "", "File to which cart events are appended as JSON lines")
+	var eventsWebhook = flag.String("eventsWebhook", "", "URL to which cart events are posted")
+	var eventsOutbox = flag.String("eventsOutbox", "", "File keeping cart events until delivered to every sink (events.outbox in -storeDir if given, in memory if empty)")
+	var cacheSize = flag.Int("cacheSize", 10000, "Maximum number of cached cart representations, least recently used evicted first (0 means unbounded)")
+	var cacheTTL = flag.Duration("cacheTTL", 0, "How long a cart representation stays cached with -cacheSize (0 means until evicted)")
+	var shards = flag.Int("shards", 16, "Number of independently locked shards of the in-memory cart store and of the cart cache")
+	var requireIfMatch = flag.Bool("requireIfMatch", false, "Reject cart changes without an entity tag in If-Match with 428 Precondition Required")
+	var subscriptionAttempts = flag.Int("subscriptionAttempts", subscription.DefaultOptions.MaxAttempts, "Delivery attempts of an event to a subscription before it becomes a dead letter")
+	var adminToken = flag.String("adminToken", os.Getenv("CARTSVC_ADMIN_TOKEN"), "Shared secret the admin requests send in the X-Admin-Token header (admin API disabled if empty)")
+	var subscriptionInternal = flag.Bool("subscriptionInternal", false, "Allow subscription callbacks to loopback, link-local and private addresses")
 	flag.Parse()
+	_, validFsync := syncPolicies[*fsync]
+	if *promoErrors != "fail" && *promoErrors != "skip" || *nodeID > math.MaxUint16 || !validFsync {
+		flag.Usage()
+		os.Exit(2)
+	}
 	return Config{
-		HashSalt:      *hashSalt,
-		ListenAddress: *listenAddress,
-		Authority:     *authority,
+		HashSalt:       *hashSalt,
+		ListenAddress:  *listenAddress,
+		Authority:      *authority,
+		RulesFile:      *rulesFile,
+		RulesPoll:      *rulesPoll,
+		SkipBadPromos:  *promoErrors == "skip",
+		NodeID:         uint16(*nodeID),
+		StoreDir:       *storeDir,
+		EventSourced:   *eventSourced,
+		Fsync:          *fsync,
+		FsyncInterval:  *fsyncInterval,
+		SnapshotEvery:  *snapshotEvery,
+		CartTTL:        *cartTTL,
+		SweepInterval:  *sweepInterval,
+		EventsStdout:   *eventsStdout,
+		EventsFile:     *eventsFile,
+		EventsWebhook:  *eventsWebhook,
+		EventsOutbox:   eventsOutboxPath(*eventsOutbox, *storeDir),
+		SubsAttempts:   *subscriptionAttempts,
+		SubsInternal:   *subscriptionInternal,
+		CacheSize:      *cacheSize,
+		CacheTTL:       *cacheTTL,
+		Shards:         *shards,
+		RequireIfMatch: *requireIfMatch,
+		AdminToken:     *adminToken,
 	}
 }
 
 func createApp(cfg Config) *App {
-	return &App{
+	a := &App{
 		AppSvc: appservice.AppService{
-			CartIDG: new(generator),
-			CartDB:  cart.NewStore(),
-			Catalog: createCatalog(),
-			PromEng: createPromoEngine(),
+			CartIDG:        newGenerator(cfg.NodeID),
+			CartDB:         createCartStore(cfg),
+			Catalog:        createCatalog(),
+			PromEng:        createPromoEngine(cfg.RulesFile),
+			PromoErrPolicy: promoErrorPolicy(cfg.SkipBadPromos),
+			CartTTL:        cfg.CartTTL,
+		},
+		HashGen:        createHashGenerator(cfg.HashSalt),
+		Router:         mux.NewRouter().StrictSlash(true),
+		CartCache:      createCartCache(cfg.CacheSize, cfg.CacheTTL, cfg.Shards),
+		Subs:           createSubscriptionManager(cfg.SubsAttempts, cfg.SubsInternal),
+		RequireIfMatch: cfg.RequireIfMatch,
+		AdminToken:     cfg.AdminToken,
+		RulesFile:      cfg.RulesFile,
+	}
+	a.updatePricing(rulesModTime(cfg.RulesFile))
+	return a
+}
+
+// rulesModTime returns when the rules file was last modified, zero for the default rules
+// so that Last-Modified depends on what persists across restarts
+func rulesModTime(rulesFile string) time.Time {
+	if rulesFile == "" {
+		return time.Time{}
+	}
+	fi, err := os.Stat(rulesFile)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+var syncPolicies = map[string]cart.SyncPolicy{
+	"always":   cart.SyncAlways,
+	"interval": cart.SyncPeriodic,
+	"never":    cart.SyncNever,
+}
+
+func createCartStore(cfg Config) cart.Store {
+	if cfg.EventSourced {
+		return createEventSourcedStore(cfg)
+	}
+	if cfg.StoreDir == "" && cfg.Shards > 1 {
+		return cart.NewShardedStore(cfg.Shards)
+	}
+	if cfg.StoreDir == "" {
+		return cart.NewStore()
+	}
+	opts := cart.FileStoreOptions{
+		Sync:          syncPolicies[cfg.Fsync],
+		SyncInterval:  cfg.FsyncInterval,
+		SnapshotEvery: cfg.SnapshotEvery,
+		SnapshotFailed: func(err error) {
+			log.Printf("Cart snapshot failed, the log keeps growing: %v", err)
 		},
-		HashGen:   createHashGenerator(cfg.HashSalt),
-		Router:    mux.NewRouter().StrictSlash(true),
-		CartCache: cache.NewCache(),
 	}
+	s, err := cart.NewFileStore(cfg.StoreDir, opts)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func createEventSourcedStore(cfg Config) cart.Store {
+	if cfg.StoreDir == "" {
+		return cart.NewEventSourcedStore(cart.NewEventStore())
+	}
+	if err := os.MkdirAll(cfg.StoreDir, 0755); err != nil {
+		panic(err)
+	}
+	path := filepath.Join(cfg.StoreDir, "cart-events.log")
+	es, err := cart.NewFileEventStore(path, syncPolicies[cfg.Fsync], cfg.FsyncInterval)
+	if err != nil {
+		panic(err)
+	}
+	return cart.NewEventSourcedStore(es)
+}
+
+// createCartCache splits the capacity among the shards
+func createCartCache(size int, ttl time.Duration, shards int) cache.Cache {
+	if shards <= 1 {
+		shards = 1
+	}
+	newShard := cache.NewCache
+	if size > 0 {
+		capacity := (size + shards - 1) / shards
+		newShard = func() cache.Cache { return cache.NewLRUCache(capacity, ttl) }
+	}
+	if shards == 1 {
+		return newShard()
+	}
+	return cache.NewShardedCache(shards, newShard)
+}
+
+func createSubscriptionManager(attempts int, allowInternal bool) subscription.Manager {
+	opts := subscription.DefaultOptions
+	opts.MaxAttempts = attempts
+	opts.AllowInternal = allowInternal
+	return subscription.NewManager(opts)
+}
+
+// createPublisher returns the publisher of the cart events to the subscriptions and the configured sinks with the sinks to close after it
+func createPublisher(cfg Config, subs subscription.Manager) (publisher.Publisher, []io.Closer) {
+	sinks := []publisher.Sink{subs}
+	var closers []io.Closer
+	if cfg.EventsStdout {
+		sinks = append(sinks, publisher.NewWriterSink(os.Stdout))
+	}
+	if cfg.EventsFile != "" {
+		fs, err := publisher.NewFileSink(cfg.EventsFile)
+		if err != nil {
+			panic(err)
+		}
+		sinks = append(sinks, fs)
+		closers = append(closers, fs)
+	}
+	if cfg.EventsWebhook != "" {
+		sinks = append(sinks, publisher.NewWebhookSink(cfg.EventsWebhook, 5*time.Second))
+	}
+	opts := publisher.DefaultOptions
+	opts.DeliveryFailed = func(sink string, e publisher.Event, err error) {
+		log.Printf("Event %s %s of cart %d not delivered to %s, retrying: %v", e.ID, e.Type, e.CartID, sink, err)
+	}
+	opts.DeadLetter = func(sink string, e publisher.Event, err error) {
+		log.Printf("Event %s %s of cart %d not delivered to %s: %v", e.ID, e.Type, e.CartID, sink, err)
+	}
+	if cfg.EventsOutbox == "" {
+		return publisher.NewPublisher(opts, sinks...), closers
+	}
+	p, err := publisher.NewDurablePublisher(cfg.EventsOutbox, opts, sinks...)
+	if err != nil {
+		panic(err)
+	}
+	return p, closers
+}
+
+// eventsOutboxPath returns the outbox file given or, when not given, the one in the store dir if any
+func eventsOutboxPath(outbox string, storeDir string) string {
+	if outbox != "" || storeDir == "" {
+		return outbox
+	}
+	return filepath.Join(storeDir, "events.outbox")
+}
+
+func promoErrorPolicy(skipBadPromos bool) appservice.PromoErrorPolicy {
+	if skipBadPromos {
+		return appservice.SkipFailingPromo
+	}
+	return appservice.FailOnPromoError
 }
 
 func createHashGenerator(salt string) *hashids.HashID {
@@ -58,17 +289,55 @@ func createHashGenerator(salt string) *hashids.HashID {
 
 func createCatalog() catalog.Catalog {
 	c := catalog.NewCatalog()
-	c.AddArticle(catalog.Article{Code: "VOUCHER", Name: "AcME Voucher", Price: 5.0})
-	c.AddArticle(catalog.Article{Code: "TSHIRT", Name: "AcME T-Shirt", Price: 20.0})
-	c.AddArticle(catalog.Article{Code: "MUG", Name: "AcME Coffee Mug", Price: 7.5})
+	c.AddArticle(catalog.Article{Code: "VOUCHER", Name: "AcME Voucher", Price: money.MustParse("5.00", "EUR")})
+	c.AddArticle(catalog.Article{Code: "TSHIRT", Name: "AcME T-Shirt", Price: money.MustParse("20.00", "EUR")})
+	c.AddArticle(catalog.Article{Code: "MUG", Name: "AcME Coffee Mug", Price: money.MustParse("7.50", "EUR")})
 	return c
 }
 
-func createPromoEngine() promotion.Engine {
+func createPromoEngine(rulesFile string) promotion.Engine {
 	e := promotion.NewEngine()
-	f1 := promotion.TwoForOne
-	f2 := promotion.DiscountForThreeOrMore
-	e.AddRule(&f1)
-	e.AddRule(&f2)
+	defs := defaultRuleDefs()
+	if rulesFile != "" {
+		defs = loadRuleDefs(rulesFile)
+	}
+	if err := e.ReplaceRules(defs); err != nil {
+		panic(err)
+	}
 	return e
 }
+
+func loadRuleDefs(rulesFile string) []promotion.RuleDef {
+	f, err := os.Open(rulesFile)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	defs, err := promotion.ParseRules(f)
+	if err != nil {
+		panic(err)
+	}
+	return defs
+}
+
+func defaultRuleDefs() []promotion.RuleDef {
+	tshirtPrice := money.MustParse("19.00", "EUR")
+	return []promotion.RuleDef{
+		{
+			Code:     "VOUCHER2X1",
+			Label:    "Buy one voucher get one free",
+			Type:     promotion.BuyXGetY,
+			Articles: []string{"VOUCHER"},
+			Buy:      1,
+			Free:     1,
+		},
+		{
+			Code:        "TSHIRT3PLUS",
+			Label:       "T-shirts at 19.00 when buying 3 or more",
+			Type:        promotion.Multibuy,
+			Articles:    []string{"TSHIRT"},
+			MinQuantity: 3,
+			UnitPrice:   &tshirtPrice,
+		},
+	}
+}
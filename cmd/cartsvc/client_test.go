@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http/httptest"
+	"shopping-cart-kata/cache"
+	"shopping-cart-kata/client"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	a := testApp(cache.NewCache())
+	ts := httptest.NewUnstartedServer(nil)
+	a.Router = mux.NewRouter().StrictSlash(true)
+	a.ConfigRoutes(ts.Listener.Addr().String())
+	a.ConfigURLBuilders()
+	ts.Config.Handler = a.Router
+	ts.Start()
+	defer ts.Close()
+	c := client.NewClient(ts.URL, client.Options{})
+	ctx := context.Background()
+
+	arts, err := c.GetArticles(ctx)
+	if err != nil || len(arts) == 0 {
+		t.Fatalf("Catalog %v, %v", arts, err)
+	}
+	crt, err := c.CreateCart(ctx)
+	if err != nil || crt.ID == "" || crt.ETag == "" || crt.LastModified.IsZero() {
+		t.Fatalf("Created cart %+v, %v", crt, err)
+	}
+	if _, err := c.AddArticle(ctx, crt.ID, crt.ETag, "MUG", 2); err != nil {
+		t.Fatalf("Error adding an article %v", err)
+	}
+	if _, err := c.AddArticle(ctx, crt.ID, "", "MUG", 1); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("Adding an article twice: %v instead of %v", err, client.ErrConflict)
+	}
+	if _, err := c.AddArticle(ctx, crt.ID, "", "NOPE", 1); !errors.Is(err, client.ErrValidation) {
+		t.Errorf("Adding an unknown article: %v instead of %v", err, client.ErrValidation)
+	}
+	if _, err := c.SetArticleQuantity(ctx, crt.ID, crt.ETag, "MUG", 3); !errors.Is(err, client.ErrPreconditionFailed) {
+		t.Errorf("Changing with a stale ETag: %v instead of %v", err, client.ErrPreconditionFailed)
+	}
+	got, err := c.GetCart(ctx, crt.ID, "")
+	if err != nil || len(got.Items) != 1 || got.Items[0].Quantity != 2 || got.Subtotal.StringAmount() != "15.00" {
+		t.Fatalf("Cart %+v, %v", got, err)
+	}
+	if _, err := c.GetCart(ctx, crt.ID, got.ETag); !errors.Is(err, client.ErrNotModified) {
+		t.Errorf("Getting with the current ETag: %v instead of %v", err, client.ErrNotModified)
+	}
+	if err := c.RemoveArticle(ctx, crt.ID, got.ETag, "MUG"); err != nil {
+		t.Errorf("Error removing an article %v", err)
+	}
+	a.RequireIfMatch = true
+	if err := c.DeleteCart(ctx, crt.ID, ""); !errors.Is(err, client.ErrPreconditionRequired) {
+		t.Errorf("Deleting without ETag: %v instead of %v", err, client.ErrPreconditionRequired)
+	}
+	if err := c.DeleteCart(ctx, crt.ID, "*"); !errors.Is(err, client.ErrPreconditionRequired) {
+		t.Errorf("Deleting with any ETag: %v instead of %v", err, client.ErrPreconditionRequired)
+	}
+	got, _ = c.GetCart(ctx, crt.ID, "")
+	if err := c.DeleteCart(ctx, crt.ID, got.ETag); err != nil {
+		t.Errorf("Error deleting the cart %v", err)
+	}
+	if _, err := c.GetCart(ctx, crt.ID, ""); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Getting a deleted cart: %v instead of %v", err, client.ErrNotFound)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"net/http"
+	"shopping-cart-kata/appservice"
+	"sort"
+	"strings"
+	"time"
+)
+
+// validators identify the current representation of a cart
+type validators struct {
+	etag         string
+	lastModified time.Time
+}
+
+// pricingState tracks the rules and prices every cart representation depends on
+type pricingState struct {
+	tag   string
+	since time.Time
+}
+
+// validatorsOf derives the validators of a cart version with the current pricing
+func (a *App) validatorsOf(id int64, v appservice.CartVersion) validators {
+	return a.currentPricing().validatorsOf(id, v)
+}
+
+// validatorsOf derives the strong ETag from the cart, its version and the pricing, and Last-Modified from the latest of their changes
+// The cart part keeps carts at the same version from sharing an ETag, and so a cache entry.
+// The pricing must be read before pricing the cart: since it changes after the rules and prices do,
+// a cart priced with rules newer than the tag is never served as the newer tag
+func (p pricingState) validatorsOf(id int64, v appservice.CartVersion) validators {
+	lastModified := v.ModifiedAt
+	if p.since.After(lastModified) {
+		lastModified = p.since
+	}
+	return validators{
+		etag:         fmt.Sprintf(`"%d-%s-%s"`, v.Version, cartTag(id), p.tag),
+		lastModified: lastModified.UTC().Truncate(time.Second),
+	}
+}
+
+// cartTag hashes the cart ID so that ETags do not reveal it
+func cartTag(id int64) string {
+	h := fnv.New64a()
+	fmt.Fprint(h, id)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// currentPricing returns the pricing computed on the last change of rules or prices, computing it on first use
+func (a *App) currentPricing() pricingState {
+	a.pricingLock.RLock()
+	p := a.pricing
+	a.pricingLock.RUnlock()
+	if p.tag != "" {
+		return p
+	}
+	return a.updatePricing(time.Time{})
+}
+
+// updatePricing recomputes the pricing tag after rules or prices changed at the given moment
+// The tag is a hash of rules and prices so that ETags survive restarts when they do not change:
+// the moment is kept only if the tag changed
+func (a *App) updatePricing(since time.Time) pricingState {
+	articles := a.AppSvc.Catalog.GetArticles()
+	sort.Slice(articles, func(i, j int) bool { return articles[i].Code < articles[j].Code })
+	j, _ := json.Marshal(struct {
+		Rules    interface{}
+		Articles interface{}
+	}{a.AppSvc.PromEng.GetRuleDefs(), articles})
+	tag := fmt.Sprintf("%08x", crc32.ChecksumIEEE(j))
+	a.pricingLock.Lock()
+	defer a.pricingLock.Unlock()
+	if a.pricing.tag != tag {
+		a.pricing = pricingState{tag: tag, since: since}
+	}
+	return a.pricing
+}
+
+func setValidators(w http.ResponseWriter, val validators) {
+	w.Header().Set("ETag", val.etag)
+	w.Header().Set("Last-Modified", val.lastModified.Format(http.TimeFormat))
+}
+
+// evalPreconditions evaluates the conditional headers in the order of RFC 7232 section 6
+// It returns 0 when the request can proceed, otherwise 304 or 412
+func evalPreconditions(r *http.Request, val validators) int {
+	safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !matchETag(im, val.etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if t, ok := parseHTTPDate(r.Header.Get("If-Unmodified-Since")); ok && val.lastModified.After(t) {
+		return http.StatusPreconditionFailed
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !matchETag(inm, val.etag, true) {
+			return 0
+		}
+		if safe {
+			return http.StatusNotModified
+		}
+		return http.StatusPreconditionFailed
+	}
+	if t, ok := parseHTTPDate(r.Header.Get("If-Modified-Since")); safe && ok && !val.lastModified.After(t) {
+		return http.StatusNotModified
+	}
+	return 0
+}
+
+// preconditions turns the conditional headers of a change into an app service precondition
+// A cart never saved has no representation: no entity tag, not even "*", matches it
+func (a *App) preconditions(r *http.Request, id int64) []appservice.Precondition {
+	h := r.Header
+	if h.Get("If-Match") == "" && h.Get("If-None-Match") == "" && h.Get("If-Unmodified-Since") == "" {
+		return nil
+	}
+	return []appservice.Precondition{func(v appservice.CartVersion) bool {
+		if v.Version == 0 {
+			return evalPreconditions(r, validators{}) == 0
+		}
+		return evalPreconditions(r, a.validatorsOf(id, v)) == 0
+	}}
+}
+
+// rejectUnconditional responds with 428 to a change whose If-Match does not list an entity tag when it is required: neither "*" nor If-Unmodified-Since tell which representation the change was based on
+// It tells if the request was rejected
+func (a *App) rejectUnconditional(w http.ResponseWriter, r *http.Request) bool {
+	if !a.RequireIfMatch || len(parseETags(r.Header.Get("If-Match"))) > 0 {
+		return false
+	}
+	respondWithProblem(w, http.StatusPreconditionRequired, "Precondition Required",
+		"The cart can be changed only conditionally: get the cart and send its ETag in the If-Match header")
+	return true
+}
+
+// respondToPrecondition writes the response of a failed evaluation
+func respondToPrecondition(w http.ResponseWriter, status int, val validators) {
+	if status == http.StatusNotModified {
+		setValidators(w, val)
+	}
+	w.WriteHeader(status)
+}
+
+// matchETag tells if the header, "*" or a list of entity tags, matches the current strong ETag
+// Weak comparison ignores the W/ prefix, strong comparison never matches weak tags
+func matchETag(header string, current string, weak bool) bool {
+	if current == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, t := range parseETags(header) {
+		isWeak := strings.HasPrefix(t, "W/")
+		if isWeak && !weak {
+			continue
+		}
+		if strings.TrimPrefix(t, "W/") == current {
+			return true
+		}
+	}
+	return false
+}
+
+// parseETags splits a list of entity tags: quoted strings may contain commas, malformed tags are skipped
+func parseETags(header string) []string {
+	var tags []string
+	for s := header; ; {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return tags
+		}
+		prefix := ""
+		if strings.HasPrefix(s, "W/") {
+			prefix, s = "W/", s[2:]
+		}
+		if !strings.HasPrefix(s, `"`) {
+			next := strings.IndexByte(s, ',')
+			if next < 0 {
+				return tags
+			}
+			s = s[next:]
+			continue
+		}
+		end := strings.IndexByte(s[1:], '"')
+		if end < 0 {
+			return tags
+		}
+		tags = append(tags, prefix+s[:end+2])
+		s = s[end+2:]
+	}
+}
+
+func parseHTTPDate(s string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(s)
+	return t, err == nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"shopping-cart-kata/cache"
+	"shopping-cart-kata/cart"
+	"shopping-cart-kata/money"
+	"shopping-cart-kata/promotion"
+	"testing"
+	"time"
+)
+
+func TestMatchETag(t *testing.T) {
+	const current = `"3-0a1b2c3d"`
+	tests := []struct {
+		header string
+		weak   bool
+		exp    bool
+	}{
+		{`"3-0a1b2c3d"`, false, true},
+		{`"2-0a1b2c3d", "3-0a1b2c3d"`, false, true},
+		{`"a,b", "3-0a1b2c3d"`, false, true},
+		{`W/"3-0a1b2c3d"`, false, false},
+		{`W/"3-0a1b2c3d"`, true, true},
+		{`"2-0a1b2c3d"`, true, false},
+		{`*`, false, true},
+		{`3-0a1b2c3d`, true, false},
+		{`bad, "3-0a1b2c3d"`, false, true},
+	}
+	for _, test := range tests {
+		if got := matchETag(test.header, current, test.weak); got != test.exp {
+			t.Errorf("Matching %s (weak %t) with %s: %t instead of %t", test.header, test.weak, current, got, test.exp)
+		}
+	}
+	if matchETag("*", "", false) {
+		t.Errorf("Wildcard matched a missing representation")
+	}
+}
+
+func TestIfMatchSurvivesCacheMiss(t *testing.T) {
+	a := testApp(cache.NewCache())
+	url := createTestCart(t, a)
+	req, _ := http.NewRequest("GET", url, nil)
+	etag := executeRequest(a, req).Header().Get("ETag")
+
+	restarted := testApp(cache.NewCache())
+	restarted.AppSvc.CartDB = a.AppSvc.CartDB
+	b := bytes.NewBufferString(`{ "id": "MUG", "quantity": 1 }`)
+	req, _ = http.NewRequest("POST", url+"/items", b)
+	req.Header.Set("If-Match", etag)
+	checkResponseCode(t, http.StatusCreated, executeRequest(restarted, req))
+
+	b = bytes.NewBufferString(`{ "id": "TSHIRT", "quantity": 1 }`)
+	req, _ = http.NewRequest("POST", url+"/items", b)
+	req.Header.Set("If-Match", etag)
+	checkResponseCode(t, http.StatusPreconditionFailed, executeRequest(restarted, req))
+
+	req, _ = http.NewRequest("DELETE", url, nil)
+	req.Header.Set("If-Match", "*")
+	checkResponseCode(t, http.StatusNoContent, executeRequest(restarted, req))
+}
+
+func TestConditionalGet(t *testing.T) {
+	a := testApp(cache.NewCache())
+	url := createTestCart(t, a)
+	req, _ := http.NewRequest("GET", url, nil)
+	response := executeRequest(a, req)
+	etag := response.Header().Get("ETag")
+	lastModified := response.Header().Get("Last-Modified")
+	if _, err := http.ParseTime(lastModified); err != nil {
+		t.Fatalf("Invalid Last-Modified %q", lastModified)
+	}
+
+	req, _ = http.NewRequest("GET", url, nil)
+	req.Header.Set("If-None-Match", `"0-00000000", W/`+etag)
+	response = executeRequest(a, req)
+	checkResponseCode(t, http.StatusNotModified, response)
+	if response.Header().Get("ETag") != etag {
+		t.Errorf("Not modified response without the ETag")
+	}
+
+	req, _ = http.NewRequest("GET", url, nil)
+	req.Header.Set("If-Modified-Since", lastModified)
+	checkResponseCode(t, http.StatusNotModified, executeRequest(a, req))
+
+	// If-None-Match takes precedence over If-Modified-Since
+	req, _ = http.NewRequest("GET", url, nil)
+	req.Header.Set("If-None-Match", `"0-00000000"`)
+	req.Header.Set("If-Modified-Since", lastModified)
+	checkResponseCode(t, http.StatusOK, executeRequest(a, req))
+
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	b := bytes.NewBufferString(`{ "id": "MUG", "quantity": 1 }`)
+	req, _ = http.NewRequest("POST", url+"/items", b)
+	req.Header.Set("If-Unmodified-Since", past)
+	checkResponseCode(t, http.StatusPreconditionFailed, executeRequest(a, req))
+
+	b = bytes.NewBufferString(`{ "id": "MUG", "quantity": 1 }`)
+	req, _ = http.NewRequest("POST", url+"/items", b)
+	req.Header.Set("If-None-Match", etag)
+	checkResponseCode(t, http.StatusPreconditionFailed, executeRequest(a, req))
+}
+
+func TestRulesChangeETag(t *testing.T) {
+	a := testApp(cache.NewCache())
+	url := createTestCart(t, a)
+	req, _ := http.NewRequest("GET", url, nil)
+	etag := executeRequest(a, req).Header().Get("ETag")
+	a.ReplaceRules([]promotion.RuleDef{})
+	req, _ = http.NewRequest("GET", url, nil)
+	req.Header.Set("If-None-Match", etag)
+	response := executeRequest(a, req)
+	checkResponseCode(t, http.StatusOK, response)
+	if response.Header().Get("ETag") == etag {
+		t.Errorf("ETag %s not changed by a rules change", etag)
+	}
+}
+
+func TestCartsAtSameVersionDoNotShareETag(t *testing.T) {
+	for name, c := range map[string]cache.Cache{"map": cache.NewCache(), "lru": cache.NewLRUCache(10, 0)} {
+		a := testApp(c)
+		urls := []string{createTestCart(t, a), createTestCart(t, a)}
+		etags := make(map[string]bool)
+		for i := 0; i < 6; i++ {
+			req, _ := http.NewRequest("GET", urls[i%2], nil)
+			response := executeRequest(a, req)
+			checkResponseCode(t, http.StatusOK, response)
+			etags[response.Header().Get("ETag")] = true
+		}
+		if len(etags) != 2 {
+			t.Errorf("%s cache: ETags %v of two carts at the same version", name, etags)
+		}
+		if s := c.Stats(); s.Hits != 6 || s.Misses != 0 {
+			t.Errorf("%s cache: %d hits and %d misses getting two created carts alternately", name, s.Hits, s.Misses)
+		}
+	}
+}
+
+func TestValidatorsSurviveRestart(t *testing.T) {
+	a := testApp(cache.NewCache())
+	url := createTestCart(t, a)
+	req, _ := http.NewRequest("GET", url, nil)
+	response := executeRequest(a, req)
+
+	restarted := testApp(cache.NewCache())
+	restarted.AppSvc.CartDB = a.AppSvc.CartDB
+	time.Sleep(time.Second)
+	req, _ = http.NewRequest("GET", url, nil)
+	again := executeRequest(restarted, req)
+	for _, h := range []string{"ETag", "Last-Modified"} {
+		if got, exp := again.Header().Get(h), response.Header().Get(h); got != exp {
+			t.Errorf("%s %s after a restart instead of %s", h, got, exp)
+		}
+	}
+}
+
+// swappingEngine replaces the rules right after pricing a cart with the previous ones, as a concurrent admin change would
+type swappingEngine struct {
+	promotion.Engine
+	swap func()
+}
+
+func (e *swappingEngine) ApplyRules(c cart.Cart, prices map[string]money.Money) (promotion.PromoSet, map[int64]error) {
+	promoSet, errs := e.Engine.ApplyRules(c, prices)
+	if e.swap != nil {
+		swap := e.swap
+		e.swap = nil
+		swap()
+	}
+	return promoSet, errs
+}
+
+func TestRulesChangeWhilePricingIsNotCached(t *testing.T) {
+	a := testApp(cache.NewCache())
+	url := createTestCart(t, a)
+	req, _ := http.NewRequest("POST", url+"/items", bytes.NewBufferString(`{ "id": "VOUCHER", "quantity": 2 }`))
+	checkResponseCode(t, http.StatusCreated, executeRequest(a, req))
+	eng := &swappingEngine{Engine: a.AppSvc.PromEng}
+	eng.swap = func() { a.ReplaceRules([]promotion.RuleDef{}) }
+	a.AppSvc.PromEng = eng
+
+	req, _ = http.NewRequest("GET", url, nil)
+	executeRequest(a, req)
+	req, _ = http.NewRequest("GET", url, nil)
+	response := executeRequest(a, req)
+	var c cartVM
+	json.NewDecoder(response.Body).Decode(&c)
+	if c.Subtotal != money.MustParse("10.00", "EUR") {
+		t.Errorf("Subtotal %s priced with the replaced rules instead of 10.00", c.Subtotal)
+	}
+}
+
+func createTestCart(t *testing.T, a *App) string {
+	req, _ := http.NewRequest("POST", "http://127.0.0.1/carts", nil)
+	response := executeRequest(a, req)
+	checkResponseCode(t, http.StatusCreated, response)
+	return response.Header().Get("Location")
+}
+
+func TestRequireIfMatch(t *testing.T) {
+	a := testApp(cache.NewCache())
+	a.RequireIfMatch = true
+	url := createTestCart(t, a)
+
+	b := bytes.NewBufferString(`{ "id": "MUG", "quantity": 1 }`)
+	req, _ := http.NewRequest("POST", url+"/items", b)
+	response := executeRequest(a, req)
+	checkResponseCode(t, http.StatusPreconditionRequired, response)
+	if ct := response.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("Content type %s instead of application/problem+json", ct)
+	}
+	var p problemVM
+	if err := json.Unmarshal(response.Body.Bytes(), &p); err != nil || p.Status != http.StatusPreconditionRequired || p.Detail == "" {
+		t.Errorf("Invalid problem %s", response.Body.String())
+	}
+	for _, h := range []map[string]string{
+		{},
+		{"If-Match": "*"},
+		{"If-Match": "W/"},
+		{"If-Unmodified-Since": time.Now().UTC().Add(time.Minute).Format(http.TimeFormat)},
+	} {
+		req, _ = http.NewRequest("DELETE", url, nil)
+		for k, v := range h {
+			req.Header.Set(k, v)
+		}
+		checkResponseCode(t, http.StatusPreconditionRequired, executeRequest(a, req))
+	}
+
+	req, _ = http.NewRequest("GET", url, nil)
+	etag := executeRequest(a, req).Header().Get("ETag")
+	b = bytes.NewBufferString(`{ "id": "MUG", "quantity": 1 }`)
+	req, _ = http.NewRequest("POST", url+"/items", b)
+	req.Header.Set("If-Match", etag)
+	checkResponseCode(t, http.StatusCreated, executeRequest(a, req))
+
+	b = bytes.NewBufferString(`{ "id": "MUG", "quantity": 3 }`)
+	req, _ = http.NewRequest("PUT", url+"/items", b)
+	req.Header.Set("If-Match", etag)
+	checkResponseCode(t, http.StatusPreconditionFailed, executeRequest(a, req))
+}
@@ -1,8 +1,32 @@
 package main
 
+import "time"
+
 // Config represents the app configuration
 type Config struct {
-	HashSalt      string
-	ListenAddress string
-	Authority     string
+	HashSalt       string
+	ListenAddress  string
+	Authority      string
+	RulesFile      string
+	RulesPoll      time.Duration
+	SkipBadPromos  bool
+	NodeID         uint16
+	StoreDir       string
+	EventSourced   bool
+	Fsync          string
+	FsyncInterval  time.Duration
+	SnapshotEvery  int
+	CartTTL        time.Duration
+	SweepInterval  time.Duration
+	EventsStdout   bool
+	EventsFile     string
+	EventsWebhook  string
+	EventsOutbox   string
+	SubsAttempts   int
+	SubsInternal   bool
+	CacheSize      int
+	CacheTTL       time.Duration
+	Shards         int
+	RequireIfMatch bool
+	AdminToken     string
 }
@@ -5,6 +5,7 @@ import (
 	"github.com/gorilla/mux"
 	"net/http"
 	"shopping-cart-kata/appservice"
+	"time"
 )
 
 func (a *App) createCart(w http.ResponseWriter, r *http.Request) {
@@ -27,27 +28,29 @@ func (a *App) createCart(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, "The system encountered an unxepected condition")
 		return
 	}
-	c := &cartVM{ID: wid, URL: url.String()}
-	c.ComputeEtag()
+	v, err := a.AppSvc.GetCartVersion(id)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "The system is not operating properly")
+		return
+	}
+	c := &cartVM{ID: wid, URL: url.String(), val: a.validatorsOf(id, v)}
 	a.CartCache.AddOrReplace(wid, c)
 	w.Header().Set("Location", c.URL)
-	respondWithPayload(w, http.StatusCreated, *c, c.GetEtag())
+	setValidators(w, c.val)
+	respondWithPayload(w, http.StatusCreated, *c, "")
 }
 
 func (a *App) addArticleToCart(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	wid := vars["id"]
-	if im := r.Header.Get("If-Match"); len(im) != 0 {
-		if _, ok := a.CartCache.GetByEtagWithID(im, wid); !ok {
-			w.WriteHeader(http.StatusPreconditionFailed)
-			return
-		}
-	}
 	id, err := a.decode(wid)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	if a.rejectUnconditional(w, r) {
+		return
+	}
 	var article itemCreateVM
 	decoder := json.NewDecoder(r.Body)
 	defer r.Body.Close()
@@ -55,7 +58,7 @@ func (a *App) addArticleToCart(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Request payload cannot be decoded")
 		return
 	}
-	err = a.AppSvc.AddArticleToCart(id, article.ID, article.Quantity)
+	err = a.AppSvc.AddArticleToCart(id, article.ID, article.Quantity, a.preconditions(r, id)...)
 	if err == appservice.ErrNotInitialized {
 		respondWithError(w, http.StatusInternalServerError, "The system is not configured properly")
 		return
@@ -64,6 +67,14 @@ func (a *App) addArticleToCart(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	if err == appservice.ErrCartExpired {
+		respondWithError(w, http.StatusGone, "The cart expired")
+		return
+	}
+	if err == appservice.ErrPreconditionFailed {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
 	if err == appservice.ErrArtNotFound {
 		respondWithError(w, http.StatusUnprocessableEntity, "The article does not exist")
 		return
@@ -76,6 +87,14 @@ func (a *App) addArticleToCart(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusUnprocessableEntity, "Article quantity must be positive")
 		return
 	}
+	if err == appservice.ErrConcurrentModification {
+		respondWithError(w, http.StatusConflict, "The cart is being modified concurrently, retry later")
+		return
+	}
+	if err == appservice.ErrCartStore {
+		respondWithError(w, http.StatusInternalServerError, "The system is not operating properly")
+		return
+	}
 	url, err := buildCartURL(wid)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "The system encountered an unxepected condition")
@@ -89,17 +108,14 @@ func (a *App) addArticleToCart(w http.ResponseWriter, r *http.Request) {
 func (a *App) setArticleQuantity(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	wid := vars["id"]
-	if im := r.Header.Get("If-Match"); len(im) != 0 {
-		if _, ok := a.CartCache.GetByEtagWithID(im, wid); !ok {
-			w.WriteHeader(http.StatusPreconditionFailed)
-			return
-		}
-	}
 	id, err := a.decode(wid)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	if a.rejectUnconditional(w, r) {
+		return
+	}
 	var article itemCreateVM
 	decoder := json.NewDecoder(r.Body)
 	defer r.Body.Close()
@@ -107,7 +123,7 @@ func (a *App) setArticleQuantity(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Request payload cannot be decoded")
 		return
 	}
-	err = a.AppSvc.SetArticleQty(id, article.ID, article.Quantity)
+	err = a.AppSvc.SetArticleQty(id, article.ID, article.Quantity, a.preconditions(r, id)...)
 	if err == appservice.ErrNotInitialized {
 		respondWithError(w, http.StatusInternalServerError, "The system is not configured properly")
 		return
@@ -116,6 +132,14 @@ func (a *App) setArticleQuantity(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	if err == appservice.ErrCartExpired {
+		respondWithError(w, http.StatusGone, "The cart expired")
+		return
+	}
+	if err == appservice.ErrPreconditionFailed {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
 	if err == appservice.ErrArtNotFound {
 		respondWithError(w, http.StatusUnprocessableEntity, "The article is not in the cart")
 		return
@@ -124,6 +148,14 @@ func (a *App) setArticleQuantity(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusUnprocessableEntity, "Article quantity must be positive")
 		return
 	}
+	if err == appservice.ErrConcurrentModification {
+		respondWithError(w, http.StatusConflict, "The cart is being modified concurrently, retry later")
+		return
+	}
+	if err == appservice.ErrCartStore {
+		respondWithError(w, http.StatusInternalServerError, "The system is not operating properly")
+		return
+	}
 	url, err := buildCartURL(wid)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "The system encountered an unxepected condition")
@@ -134,11 +166,55 @@ func (a *App) setArticleQuantity(w http.ResponseWriter, r *http.Request) {
 	respondWithPayload(w, http.StatusOK, article, "")
 }
 
+func (a *App) removeArticleFromCart(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	wid := vars["id"]
+	id, err := a.decode(wid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if a.rejectUnconditional(w, r) {
+		return
+	}
+	err = a.AppSvc.RemoveArticleFromCart(id, vars["code"], a.preconditions(r, id)...)
+	if err == appservice.ErrNotInitialized {
+		respondWithError(w, http.StatusInternalServerError, "The system is not configured properly")
+		return
+	}
+	if err == appservice.ErrCartNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err == appservice.ErrCartExpired {
+		respondWithError(w, http.StatusGone, "The cart expired")
+		return
+	}
+	if err == appservice.ErrPreconditionFailed {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	if err == appservice.ErrArtNotFound {
+		respondWithError(w, http.StatusNotFound, "The article is not in the cart")
+		return
+	}
+	if err == appservice.ErrConcurrentModification {
+		respondWithError(w, http.StatusConflict, "The cart is being modified concurrently, retry later")
+		return
+	}
+	if err == appservice.ErrCartStore {
+		respondWithError(w, http.StatusInternalServerError, "The system is not operating properly")
+		return
+	}
+	a.CartCache.Remove(wid)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (a *App) getCart(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	wid := vars["id"]
-	if _, ok := a.CartCache.GetByEtagWithID(r.Header.Get("If-None-Match"), wid); ok {
-		w.WriteHeader(http.StatusNotModified)
+	if at := r.URL.Query().Get("at"); at != "" {
+		a.getCartAt(w, r, wid, at)
 		return
 	}
 	id, err := a.decode(wid)
@@ -146,45 +222,145 @@ func (a *App) getCart(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-	pc, err := a.AppSvc.GetCart(id)
+	v, err := a.AppSvc.GetCartVersion(id)
+	if a.respondWithGetCartError(w, err) {
+		return
+	}
+	pricing := a.currentPricing()
+	val := pricing.validatorsOf(id, v)
+	if status := evalPreconditions(r, val); status != 0 {
+		respondToPrecondition(w, status, val)
+		return
+	}
+	if e, ok := a.CartCache.GetByEtagWithID(val.etag, wid); ok {
+		c := e.(*cartVM)
+		setValidators(w, c.val)
+		respondWithPayload(w, http.StatusOK, *c, "")
+		return
+	}
+	pc, v, err := a.AppSvc.GetVersionedCart(id)
+	if a.respondWithGetCartError(w, err) {
+		return
+	}
+	c := fromPricedCart(pc, wid, r.URL.String())
+	c.val = pricing.validatorsOf(id, v)
+	a.CartCache.AddOrReplace(wid, &c)
+	setValidators(w, c.val)
+	respondWithPayload(w, http.StatusOK, c, "")
+}
+
+// respondWithGetCartError responds to the errors retrieving a cart telling if there was one
+func (a *App) respondWithGetCartError(w http.ResponseWriter, err error) bool {
+	switch err {
+	case nil:
+		return false
+	case appservice.ErrNotInitialized:
+		respondWithError(w, http.StatusInternalServerError, "The system is not configured properly")
+	case appservice.ErrCartNotFound:
+		w.WriteHeader(http.StatusNotFound)
+	case appservice.ErrCartExpired:
+		respondWithError(w, http.StatusGone, "The cart expired")
+	case appservice.ErrPromoRulesApplication:
+		respondWithError(w, http.StatusInternalServerError, "Promotions cannot be applied to the cart")
+	default:
+		respondWithError(w, http.StatusInternalServerError, "The system encountered an unxepected condition")
+	}
+	return true
+}
+
+// getCartAt responds with the cart as it was at a past moment, neither cached nor tagged since it is not the current state
+func (a *App) getCartAt(w http.ResponseWriter, r *http.Request, wid string, at string) {
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "The at timestamp must be in RFC 3339 format")
+		return
+	}
+	id, err := a.decode(wid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	pc, err := a.AppSvc.GetCartAt(id, t)
 	if err == appservice.ErrNotInitialized {
 		respondWithError(w, http.StatusInternalServerError, "The system is not configured properly")
 		return
 	}
+	if err == appservice.ErrHistoryUnavailable {
+		respondWithError(w, http.StatusNotImplemented, "The cart history is not kept")
+		return
+	}
 	if err == appservice.ErrCartNotFound {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	if err == appservice.ErrPricingNotRecorded {
+		respondWithError(w, http.StatusUnprocessableEntity, "Prices and promotions are not recorded at that moment, e.g. it is before the last restart")
+		return
+	}
 	if err == appservice.ErrPromoRulesApplication {
+		respondWithError(w, http.StatusInternalServerError, "Promotions cannot be applied to the cart")
+		return
+	}
+	respondWithPayload(w, http.StatusOK, fromPricedCart(pc, wid, r.URL.String()), "")
+}
+
+func (a *App) getCartHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	wid := vars["id"]
+	id, err := a.decode(wid)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	events, err := a.AppSvc.GetCartHistory(id)
+	if err == appservice.ErrNotInitialized {
+		respondWithError(w, http.StatusInternalServerError, "The system is not configured properly")
+		return
+	}
+	if err == appservice.ErrHistoryUnavailable {
+		respondWithError(w, http.StatusNotImplemented, "The cart history is not kept")
+		return
+	}
+	if err == appservice.ErrCartNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	url, err := buildCartURL(wid)
+	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "The system encountered an unxepected condition")
 		return
 	}
-	c := fromPricedCart(pc, wid, r.URL.String())
-	cp := &c
-	cp.ComputeEtag()
-	a.CartCache.AddOrReplace(wid, cp)
-	respondWithPayload(w, http.StatusOK, *cp, cp.GetEtag())
+	respondWithPayload(w, http.StatusOK, fromEvents(events, wid, url.String()), "")
 }
 
 func (a *App) deleteCart(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	wid := vars["id"]
-	if im := r.Header.Get("If-Match"); len(im) != 0 {
-		if _, ok := a.CartCache.GetByEtagWithID(im, wid); !ok {
-			w.WriteHeader(http.StatusPreconditionFailed)
-			return
-		}
-	}
 	id, err := a.decode(wid)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-	err = a.AppSvc.DeleteCart(id)
+	if a.rejectUnconditional(w, r) {
+		return
+	}
+	err = a.AppSvc.DeleteCart(id, a.preconditions(r, id)...)
 	if err == appservice.ErrNotInitialized {
 		respondWithError(w, http.StatusInternalServerError, "The system is not configured properly")
 		return
 	}
+	if err == appservice.ErrPreconditionFailed {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	if err == appservice.ErrConcurrentModification {
+		respondWithError(w, http.StatusConflict, "The cart is being modified concurrently, retry later")
+		return
+	}
+	if err == appservice.ErrCartStore {
+		respondWithError(w, http.StatusInternalServerError, "The system is not operating properly")
+		return
+	}
 	a.CartCache.Remove(wid)
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -198,6 +374,14 @@ func respondWithError(w http.ResponseWriter, code int, message string) {
 	respondWithPayload(w, code, map[string]string{"error": message}, "")
 }
 
+// respondWithProblem responds with a RFC 7807 problem details body
+func respondWithProblem(w http.ResponseWriter, code int, title string, detail string) {
+	response, _ := json.Marshal(problemVM{Type: "about:blank", Title: title, Status: code, Detail: detail})
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(code)
+	w.Write(response)
+}
+
 func respondWithPayload(w http.ResponseWriter, statusCode int, viewmodel interface{}, etag string) {
 	response, _ := json.Marshal(viewmodel)
 	if etag != "" {
@@ -14,9 +14,5 @@ func (a *App) decode(hash string) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	i, err := strconv.Atoi(s)
-	if err != nil {
-		return int64(0), err
-	}
-	return int64(i), nil
+	return strconv.ParseInt(s, 16, 64)
 }
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"shopping-cart-kata/cart"
+	"time"
+)
+
+type historyVM struct {
+	ID      string    `json:"id"`
+	Events  []eventVM `json:"events"`
+	CartURL string    `json:"cartUrl"`
+}
+
+type eventVM struct {
+	Version   int64          `json:"version"`
+	Type      cart.EventType `json:"type"`
+	ArticleID string         `json:"articleId,omitempty"`
+	Quantity  int            `json:"quantity,omitempty"`
+	At        time.Time      `json:"at"`
+}
+
+func fromEvents(events []cart.Event, wid string, cartURL string) historyVM {
+	h := historyVM{ID: wid, Events: make([]eventVM, len(events)), CartURL: cartURL}
+	for i, e := range events {
+		h.Events[i] = eventVM{Version: e.Version, Type: e.Type, ArticleID: e.ArticleID, Quantity: e.Quantity, At: e.At}
+	}
+	return h
+}
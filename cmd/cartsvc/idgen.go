@@ -1,12 +1,61 @@
 package main
 
-// Should be based on https://github.com/sony/sonyflake
+import (
+	"sync"
+	"time"
+)
+
+// IDs are laid out as in https://github.com/sony/sonyflake:
+// 39 bits of time in units of 10 ms since idEpoch, 8 bits of sequence and 16 bits of node id
+const (
+	timeUnit    = 10 * time.Millisecond
+	sequenceLen = 8
+	nodeLen     = 16
+	maxElapsed  = 1<<39 - 1
+	maxSequence = 1<<sequenceLen - 1
+)
+
+var idEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// generator provides time ordered IDs, unique across the instances with different node ids
+// IDs survive restarts as long as the clock is not set back more than the restart takes
 type generator struct {
-	id int64
+	sync.Mutex
+	node     uint16
+	elapsed  int64
+	sequence int64
+	now      func() time.Time
+	sleep    func(time.Duration)
+}
+
+func newGenerator(node uint16) *generator {
+	return &generator{node: node, now: time.Now, sleep: time.Sleep}
 }
 
 // NextID id generation
+// When the clock goes backwards or the sequence is exhausted the IDs borrow the following time units
 func (g *generator) NextID() int64 {
-	g.id++
-	return g.id
+	g.Lock()
+	defer g.Unlock()
+	current := g.sinceEpoch()
+	if g.elapsed < current {
+		g.elapsed = current
+		g.sequence = 0
+	} else {
+		g.sequence = (g.sequence + 1) & maxSequence
+		if g.sequence == 0 {
+			g.elapsed++
+			if overtime := g.elapsed - current; overtime > 0 {
+				g.sleep(time.Duration(overtime)*timeUnit - time.Duration(g.now().Sub(idEpoch)%timeUnit))
+			}
+		}
+	}
+	if g.elapsed > maxElapsed {
+		panic("ID generator time limit exceeded")
+	}
+	return g.elapsed<<(sequenceLen+nodeLen) | g.sequence<<nodeLen | int64(g.node)
+}
+
+func (g *generator) sinceEpoch() int64 {
+	return int64(g.now().Sub(idEpoch) / timeUnit)
 }
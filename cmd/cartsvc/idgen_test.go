@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentIDsAreUnique(t *testing.T) {
+	const (
+		workers = 8
+		perWork = 1000
+	)
+	g := newGenerator(7)
+	ids := make(chan int64, workers*perWork)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWork; i++ {
+				ids <- g.NextID()
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	seen := make(map[int64]bool, workers*perWork)
+	for id := range ids {
+		if id <= 0 || seen[id] {
+			t.Fatalf("ID %d not positive or duplicated", id)
+		}
+		if node := id & (1<<nodeLen - 1); node != 7 {
+			t.Fatalf("ID %d with node %d instead of 7", id, node)
+		}
+		seen[id] = true
+	}
+}
+
+func TestIDsAreTimeOrdered(t *testing.T) {
+	clock := idEpoch.Add(time.Hour)
+	g := newGenerator(1)
+	g.now = func() time.Time { return clock }
+	g.sleep = func(d time.Duration) { clock = clock.Add(d) }
+	last := g.NextID()
+	next := func(msg string) {
+		id := g.NextID()
+		if id <= last {
+			t.Fatalf("%s: ID %d not greater than %d", msg, id, last)
+		}
+		last = id
+	}
+	for i := 0; i < 3*maxSequence; i++ {
+		next("sequence exhausted")
+	}
+	clock = clock.Add(time.Second)
+	next("clock forward")
+	clock = clock.Add(-time.Minute)
+	next("clock backwards")
+}
+
+func TestIDsOfDifferentNodesDiffer(t *testing.T) {
+	clock := idEpoch.Add(time.Hour)
+	g1, g2 := newGenerator(1), newGenerator(2)
+	g1.now = func() time.Time { return clock }
+	g2.now = g1.now
+	if id1, id2 := g1.NextID(), g2.NextID(); id1 == id2 {
+		t.Errorf("Same ID %d generated by different nodes", id1)
+	}
+}
@@ -1,12 +1,15 @@
 package main
 
-import "shopping-cart-kata/pricedcart"
+import (
+	"shopping-cart-kata/money"
+	"shopping-cart-kata/pricedcart"
+)
 
 type itemGetVM struct {
-	ID         string  `json:"id"`
-	Quantity   int     `json:"quantity"`
-	UnitPrice  float64 `json:"unitPrice"`
-	TotalPrice float64 `json:"totalPrice"`
+	ID         string      `json:"id"`
+	Quantity   int         `json:"quantity"`
+	UnitPrice  money.Money `json:"unitPrice"`
+	TotalPrice money.Money `json:"totalPrice"`
 }
 
 func fromPricedItem(pi pricedcart.Item) itemGetVM {
@@ -0,0 +1,8 @@
+package main
+
+type problemVM struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+}
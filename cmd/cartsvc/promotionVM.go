@@ -0,0 +1,16 @@
+package main
+
+import "shopping-cart-kata/promotion"
+
+type promotionVM struct {
+	promotion.RuleDef
+	URL string `json:"url"`
+}
+
+func fromRuleDef(d promotion.RuleDef) (promotionVM, error) {
+	url, err := buildPromotionURL(d.Code)
+	if err != nil {
+		return promotionVM{}, err
+	}
+	return promotionVM{RuleDef: d, URL: url.String()}, nil
+}
@@ -6,13 +6,35 @@ import (
 
 var buildCartURL func(wid string) (*url.URL, error)
 
+var buildPromotionURL func(code string) (*url.URL, error)
+
+var buildSubscriptionURL func(id string) (*url.URL, error)
+
 // ConfigRoutes configures the API routes
 func (a *App) ConfigRoutes(authority string) {
 	a.Router.HandleFunc("/carts", a.createCart).Host(authority).Methods("POST")
 	a.Router.HandleFunc("/carts/{id}", a.getCart).Host(authority).Methods("GET").Name("cart")
 	a.Router.HandleFunc("/carts/{id}", a.deleteCart).Host(authority).Methods("DELETE")
+	a.Router.HandleFunc("/carts/{id}/history", a.getCartHistory).Host(authority).Methods("GET")
 	a.Router.HandleFunc("/carts/{id}/items", a.addArticleToCart).Host(authority).Methods("POST")
 	a.Router.HandleFunc("/carts/{id}/items", a.setArticleQuantity).Host(authority).Methods("PUT")
+	a.Router.HandleFunc("/carts/{id}/items/{code}", a.removeArticleFromCart).Host(authority).Methods("DELETE")
+	a.Router.HandleFunc("/subscriptions", a.getSubscriptions).Host(authority).Methods("GET")
+	a.Router.HandleFunc("/subscriptions", a.createSubscription).Host(authority).Methods("POST")
+	a.Router.HandleFunc("/subscriptions/{id}", a.getSubscription).Host(authority).Methods("GET").Name("subscription")
+	a.Router.HandleFunc("/subscriptions/{id}", a.deleteSubscription).Host(authority).Methods("DELETE")
+	a.Router.HandleFunc("/subscriptions/{id}/deadletters", a.getDeadLetters).Host(authority).Methods("GET")
+	a.Router.HandleFunc("/subscriptions/{id}/replay", a.replayDeadLetters).Host(authority).Methods("POST")
+	// Should be in the promotion API
+	a.Router.HandleFunc("/admin/promotions", a.adminOnly(a.getPromotions)).Host(authority).Methods("GET")
+	a.Router.HandleFunc("/admin/promotions", a.adminOnly(a.createPromotion)).Host(authority).Methods("POST")
+	a.Router.HandleFunc("/admin/promotions", a.adminOnly(a.replacePromotions)).Host(authority).Methods("PUT")
+	a.Router.HandleFunc("/admin/promotions/{code}", a.adminOnly(a.getPromotion)).Host(authority).Methods("GET").Name("promotion")
+	a.Router.HandleFunc("/admin/promotions/{code}", a.adminOnly(a.updatePromotion)).Host(authority).Methods("PUT")
+	a.Router.HandleFunc("/admin/promotions/{code}", a.adminOnly(a.deletePromotion)).Host(authority).Methods("DELETE")
+	a.Router.HandleFunc("/admin/promotions/{code}/enable", a.adminOnly(a.enablePromotion)).Host(authority).Methods("POST")
+	a.Router.HandleFunc("/admin/promotions/{code}/disable", a.adminOnly(a.disablePromotion)).Host(authority).Methods("POST")
+	a.Router.HandleFunc("/admin/cache", a.adminOnly(a.getCacheStats)).Host(authority).Methods("GET")
 	// Should be in the catalog API
 	a.Router.HandleFunc("/articles", a.getArticles).Host(authority).Methods("GET")
 }
@@ -22,4 +44,10 @@ func (a *App) ConfigURLBuilders() {
 	buildCartURL = func(wid string) (*url.URL, error) {
 		return a.Router.Get("cart").URL("id", wid)
 	}
+	buildPromotionURL = func(code string) (*url.URL, error) {
+		return a.Router.Get("promotion").URL("code", code)
+	}
+	buildSubscriptionURL = func(id string) (*url.URL, error) {
+		return a.Router.Get("subscription").URL("id", id)
+	}
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+	"os"
+	"shopping-cart-kata/promotion"
+	"time"
+)
+
+// rulesWatcher polls a promotion rules file and reloads the rule set when it changes
+type rulesWatcher struct {
+	path     string
+	interval time.Duration
+	reload   func(defs []promotion.RuleDef) error
+	stop     chan struct{}
+	modTime  time.Time
+	size     int64
+}
+
+func newRulesWatcher(path string, interval time.Duration, reload func(defs []promotion.RuleDef) error) *rulesWatcher {
+	w := &rulesWatcher{path: path, interval: interval, reload: reload, stop: make(chan struct{})}
+	if fi, err := os.Stat(path); err == nil {
+		w.modTime, w.size = fi.ModTime(), fi.Size()
+	}
+	return w
+}
+
+// Start polls the file in background until Stop is called
+func (w *rulesWatcher) Start() {
+	go func() {
+		t := time.NewTicker(w.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-t.C:
+				w.check()
+			}
+		}
+	}()
+}
+
+// Stop stops polling the file
+func (w *rulesWatcher) Stop() {
+	close(w.stop)
+}
+
+func (w *rulesWatcher) check() {
+	fi, err := os.Stat(w.path)
+	if err != nil {
+		log.Printf("Unable to check promotion rules file %s: %v", w.path, err)
+		return
+	}
+	if fi.ModTime().Equal(w.modTime) && fi.Size() == w.size {
+		return
+	}
+	w.modTime, w.size = fi.ModTime(), fi.Size()
+	f, err := os.Open(w.path)
+	if err != nil {
+		log.Printf("Unable to open promotion rules file %s: %v", w.path, err)
+		return
+	}
+	defer f.Close()
+	defs, err := promotion.ParseRules(f)
+	if err != nil {
+		log.Printf("Promotion rules file %s not reloaded: %v", w.path, err)
+		return
+	}
+	if err := w.reload(defs); err != nil {
+		log.Printf("Promotion rules file %s not reloaded: %v", w.path, err)
+		return
+	}
+	log.Printf("Promotion rules reloaded from %s", w.path)
+}
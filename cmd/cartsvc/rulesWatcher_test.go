@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"shopping-cart-kata/promotion"
+	"testing"
+	"time"
+)
+
+func TestRulesWatcherReloadsChangedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatalf("Error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rules.json")
+	ioutil.WriteFile(path, []byte(`{ "rules": [] }`), 0644)
+	reloaded := make(chan []promotion.RuleDef, 1)
+	reload := func(defs []promotion.RuleDef) error {
+		reloaded <- defs
+		return nil
+	}
+	w := newRulesWatcher(path, 10*time.Millisecond, reload)
+	w.Start()
+	defer w.Stop()
+	rules := `{ "rules": [ { "code": "MUG10", "type": "percentOff", "articles": ["MUG"], "percent": 10 } ] }`
+	ioutil.WriteFile(path, []byte(rules), 0644)
+	select {
+	case defs := <-reloaded:
+		if len(defs) != 1 || defs[0].Code != "MUG10" {
+			t.Errorf("Reloaded rules %v instead of MUG10", defs)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Changed rules file not reloaded")
+	}
+}
+
+func TestRulesWatcherIgnoresInvalidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatalf("Error creating temp dir %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rules.json")
+	ioutil.WriteFile(path, []byte(`{ "rules": [] }`), 0644)
+	called := false
+	w := newRulesWatcher(path, time.Hour, func(defs []promotion.RuleDef) error {
+		called = true
+		return nil
+	})
+	ioutil.WriteFile(path, []byte(`{ "rules": [ { "code": "BAD" } ] }`), 0644)
+	w.check()
+	if called {
+		t.Error("Invalid rules file reloaded")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"shopping-cart-kata/publisher"
+	"shopping-cart-kata/subscription"
+)
+
+func (a *App) createSubscription(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CallbackURL string           `json:"callbackUrl"`
+		Events      []publisher.Type `json:"events"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	defer r.Body.Close()
+	if err := decoder.Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Request payload cannot be decoded")
+		return
+	}
+	s, err := a.Subs.Create(req.CallbackURL, req.Events)
+	if err != nil && err != publisher.ErrClosed {
+		respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusServiceUnavailable, "The system is shutting down")
+		return
+	}
+	vm, err := fromSubscription(s, true)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "The system encountered an unxepected condition")
+		return
+	}
+	w.Header().Set("Location", vm.URL)
+	respondWithPayload(w, http.StatusCreated, vm, "")
+}
+
+func (a *App) getSubscriptions(w http.ResponseWriter, r *http.Request) {
+	subs := a.Subs.List()
+	vms := make([]subscriptionVM, len(subs))
+	for i, s := range subs {
+		vm, err := fromSubscription(s, false)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "The system encountered an unxepected condition")
+			return
+		}
+		vms[i] = vm
+	}
+	respondWithPayload(w, http.StatusOK, vms, "")
+}
+
+func (a *App) getSubscription(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.Subs.Get(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	vm, err := fromSubscription(s, false)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "The system encountered an unxepected condition")
+		return
+	}
+	respondWithPayload(w, http.StatusOK, vm, "")
+}
+
+func (a *App) deleteSubscription(w http.ResponseWriter, r *http.Request) {
+	if err := a.Subs.Delete(mux.Vars(r)["id"]); err == subscription.ErrSubscriptionNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) getDeadLetters(w http.ResponseWriter, r *http.Request) {
+	dls, err := a.Subs.DeadLetters(mux.Vars(r)["id"])
+	if err == subscription.ErrSubscriptionNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	respondWithPayload(w, http.StatusOK, fromDeadLetters(dls), "")
+}
+
+func (a *App) replayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	n, err := a.Subs.Replay(mux.Vars(r)["id"])
+	if err == subscription.ErrSubscriptionNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	respondWithPayload(w, http.StatusAccepted, map[string]int{"replayed": n}, "")
+}
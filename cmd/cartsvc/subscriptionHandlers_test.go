@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"shopping-cart-kata/publisher"
+	"shopping-cart-kata/subscription"
+	"sync"
+	"testing"
+	"time"
+)
+
+func subscriptionsApp() *App {
+	a := testApp(new(uncache))
+	a.Subs = subscription.NewManager(subscription.Options{MaxAttempts: 2, RetryMin: time.Millisecond, AllowInternal: true})
+	a.AppSvc.Publisher = publisher.NewPublisher(publisher.Options{RetryMin: time.Millisecond}, a.Subs)
+	return a
+}
+
+func TestSubscriptionLifecycle(t *testing.T) {
+	var mu sync.Mutex
+	var received []publisher.Event
+	var secret string
+	fail := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		if fail || !subscription.Verify(secret, r.Header.Get(subscription.SignatureHeader), body, time.Minute) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var e publisher.Event
+		json.Unmarshal(body, &e)
+		received = append(received, e)
+	}))
+	defer srv.Close()
+	a := subscriptionsApp()
+	defer a.Subs.Close()
+	defer a.AppSvc.Publisher.Close()
+
+	b := bytes.NewBufferString(`{"callbackUrl":"` + srv.URL + `","events":["cart.created","cart.unknown"]}`)
+	req, _ := http.NewRequest("POST", "http://127.0.0.1/subscriptions", b)
+	if response := executeRequest(a, req); response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Subscription with an unknown event type answered %d instead of %d", response.Code, http.StatusUnprocessableEntity)
+	}
+	b = bytes.NewBufferString(`{"callbackUrl":"` + srv.URL + `","events":["cart.created"]}`)
+	req, _ = http.NewRequest("POST", "http://127.0.0.1/subscriptions", b)
+	response := executeRequest(a, req)
+	var vm subscriptionVM
+	json.NewDecoder(response.Body).Decode(&vm)
+	if response.Code != http.StatusCreated || vm.Secret == "" || response.Header().Get("Location") != vm.URL {
+		t.Fatalf("Subscription created with %d as %v", response.Code, vm)
+	}
+	mu.Lock()
+	secret = vm.Secret
+	mu.Unlock()
+	req, _ = http.NewRequest("GET", vm.URL, nil)
+	response = executeRequest(a, req)
+	var got subscriptionVM
+	json.NewDecoder(response.Body).Decode(&got)
+	if response.Code != http.StatusOK || got.Secret != "" || got.ID != vm.ID {
+		t.Errorf("Subscription retrieved with %d as %v instead of without its secret", response.Code, got)
+	}
+
+	req, _ = http.NewRequest("POST", "http://127.0.0.1/carts", nil)
+	executeRequest(a, req)
+	var dls []deadLetterVM
+	for i := 0; i < 500 && len(dls) == 0; i++ {
+		time.Sleep(time.Millisecond)
+		req, _ = http.NewRequest("GET", vm.DeadLettersURL, nil)
+		json.NewDecoder(executeRequest(a, req).Body).Decode(&dls)
+	}
+	if len(dls) != 1 || dls[0].Event.Type != publisher.CartCreated || dls[0].Attempts != 2 {
+		t.Fatalf("Dead letters %v instead of the cart creation failing twice", dls)
+	}
+
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	req, _ = http.NewRequest("POST", vm.URL+"/replay", nil)
+	if response := executeRequest(a, req); response.Code != http.StatusAccepted {
+		t.Errorf("Replay answered %d instead of %d", response.Code, http.StatusAccepted)
+	}
+	delivered := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received)
+	}
+	for i := 0; i < 500 && delivered() == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if delivered() != 1 {
+		t.Errorf("Replayed event not delivered")
+	}
+
+	req, _ = http.NewRequest("DELETE", vm.URL, nil)
+	if response := executeRequest(a, req); response.Code != http.StatusNoContent {
+		t.Errorf("Delete answered %d instead of %d", response.Code, http.StatusNoContent)
+	}
+	req, _ = http.NewRequest("GET", vm.DeadLettersURL, nil)
+	if response := executeRequest(a, req); response.Code != http.StatusNotFound {
+		t.Errorf("Dead letters of a deleted subscription answered %d instead of %d", response.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"shopping-cart-kata/publisher"
+	"shopping-cart-kata/subscription"
+	"time"
+)
+
+type subscriptionVM struct {
+	ID             string           `json:"id"`
+	CallbackURL    string           `json:"callbackUrl"`
+	Events         []publisher.Type `json:"events"`
+	Secret         string           `json:"secret,omitempty"`
+	CreatedAt      time.Time        `json:"createdAt"`
+	URL            string           `json:"url"`
+	DeadLettersURL string           `json:"deadLettersUrl"`
+}
+
+type deadLetterVM struct {
+	Event    publisher.Event `json:"event"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	FailedAt time.Time       `json:"failedAt"`
+}
+
+func fromSubscription(s subscription.Subscription, withSecret bool) (subscriptionVM, error) {
+	url, err := buildSubscriptionURL(s.ID)
+	if err != nil {
+		return subscriptionVM{}, err
+	}
+	vm := subscriptionVM{
+		ID:             s.ID,
+		CallbackURL:    s.URL,
+		Events:         s.Types,
+		CreatedAt:      s.CreatedAt,
+		URL:            url.String(),
+		DeadLettersURL: url.String() + "/deadletters",
+	}
+	if withSecret {
+		vm.Secret = s.Secret
+	}
+	return vm, nil
+}
+
+func fromDeadLetters(dls []subscription.DeadLetter) []deadLetterVM {
+	vms := make([]deadLetterVM, len(dls))
+	for i, dl := range dls {
+		vms[i] = deadLetterVM{Event: dl.Event, Attempts: dl.Attempts, Error: dl.Error, FailedAt: dl.FailedAt}
+	}
+	return vms
+}
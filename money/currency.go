@@ -0,0 +1,23 @@
+package money
+
+import "strings"
+
+type currency struct {
+	digits int
+	symbol string
+}
+
+// Known currencies: unknown ones are assumed to have two decimal digits
+var currencies = map[string]currency{
+	"EUR": {digits: 2, symbol: "€"},
+	"USD": {digits: 2, symbol: "$"},
+	"GBP": {digits: 2, symbol: "£"},
+	"JPY": {digits: 0, symbol: "¥"},
+}
+
+func digits(code string) int {
+	if c, ok := currencies[strings.ToUpper(code)]; ok {
+		return c.digits
+	}
+	return 2
+}
@@ -0,0 +1,215 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the currency of the zero value
+const DefaultCurrency = "EUR"
+
+// ErrCurrencyMismatch when operating on amounts of different currencies
+var ErrCurrencyMismatch = errors.New("Currencies do not match")
+
+// ErrInvalidAmount when an amount cannot be parsed
+var ErrInvalidAmount = errors.New("Amount is not valid")
+
+// ErrTooManyDecimals when an amount has more decimals than its currency allows
+var ErrTooManyDecimals = errors.New("Amount has too many decimals for the currency")
+
+// Money is an exact amount of a currency expressed in minor units
+// The zero value is a zero amount of DefaultCurrency
+// Zero amounts can be combined with amounts of any currency
+type Money struct {
+	amount int64
+	cur    string
+}
+
+// New creates an amount from its minor units (e.g. cents) and currency code
+func New(minor int64, currency string) Money {
+	return Money{amount: minor, cur: normalize(currency)}
+}
+
+// Parse creates an amount from its decimal representation (e.g. "19.00")
+func Parse(amount string, currency string) (Money, error) {
+	minor, err := parseDecimal(amount, digits(currency))
+	if err != nil {
+		return Money{}, err
+	}
+	return New(minor, currency), nil
+}
+
+// MustParse is like Parse but panics on error
+func MustParse(amount string, currency string) Money {
+	m, err := Parse(amount, currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Amount returns the amount in minor units
+func (m Money) Amount() int64 {
+	return m.amount
+}
+
+// Currency returns the currency code
+func (m Money) Currency() string {
+	if m.cur == "" {
+		return DefaultCurrency
+	}
+	return m.cur
+}
+
+// IsZero tells if the amount is zero
+func (m Money) IsZero() bool {
+	return m.amount == 0
+}
+
+// Cmp compares two amounts returning -1, 0 or +1
+func (m Money) Cmp(o Money) int {
+	matching(m, o)
+	switch {
+	case m.amount < o.amount:
+		return -1
+	case m.amount > o.amount:
+		return 1
+	}
+	return 0
+}
+
+// Add returns the sum of two amounts
+func (m Money) Add(o Money) Money {
+	return Money{amount: m.amount + o.amount, cur: matching(m, o)}
+}
+
+// Sub returns the difference of two amounts
+func (m Money) Sub(o Money) Money {
+	return Money{amount: m.amount - o.amount, cur: matching(m, o)}
+}
+
+// Mul returns the amount multiplied by an integer factor (e.g. a quantity)
+func (m Money) Mul(n int64) Money {
+	return Money{amount: m.amount * n, cur: m.cur}
+}
+
+// Percent returns the given percentage of the amount rounded with the given mode
+func (m Money) Percent(r Rate, mode RoundingMode) Money {
+	return Money{amount: divRound(m.amount*int64(r), rateScale, mode), cur: m.cur}
+}
+
+// StringAmount returns the decimal amount without currency (e.g. "19.00")
+func (m Money) StringAmount() string {
+	return formatDecimal(m.amount, digits(m.Currency()))
+}
+
+// Symbol returns the currency symbol, or the code when not known
+func (m Money) Symbol() string {
+	if c, ok := currencies[m.Currency()]; ok {
+		return c.symbol
+	}
+	return m.Currency()
+}
+
+func (m Money) String() string {
+	return fmt.Sprintf("%s %s", m.StringAmount(), m.Currency())
+}
+
+type moneyJSON struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// MarshalJSON serializes the amount as a decimal string plus currency code
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.StringAmount(), Currency: m.Currency()})
+}
+
+// UnmarshalJSON deserializes an amount serialized by MarshalJSON
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var j moneyJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	if j.Currency == "" {
+		j.Currency = DefaultCurrency
+	}
+	res, err := Parse(j.Amount, j.Currency)
+	if err != nil {
+		return err
+	}
+	*m = res
+	return nil
+}
+
+func normalize(currency string) string {
+	currency = strings.ToUpper(currency)
+	if currency == DefaultCurrency {
+		return ""
+	}
+	return currency
+}
+
+func matching(a, b Money) string {
+	switch {
+	case a.cur == b.cur || b.amount == 0:
+		return a.cur
+	case a.amount == 0:
+		return b.cur
+	}
+	panic(ErrCurrencyMismatch)
+}
+
+func parseDecimal(s string, digits int) (int64, error) {
+	s = strings.TrimSpace(s)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	parts := strings.Split(s, ".")
+	if len(parts) > 2 || parts[0] == "" && (len(parts) == 1 || parts[1] == "") {
+		return 0, ErrInvalidAmount
+	}
+	intPart, fracPart := parts[0], ""
+	if len(parts) == 2 {
+		fracPart = parts[1]
+	}
+	if len(fracPart) > digits {
+		if strings.Trim(fracPart[digits:], "0") != "" {
+			return 0, ErrTooManyDecimals
+		}
+		fracPart = fracPart[:digits]
+	}
+	fracPart += strings.Repeat("0", digits-len(fracPart))
+	for _, r := range intPart + fracPart {
+		if r < '0' || r > '9' {
+			return 0, ErrInvalidAmount
+		}
+	}
+	n, err := strconv.ParseInt(intPart+fracPart, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidAmount
+	}
+	if neg {
+		n = -n
+	}
+	return n, nil
+}
+
+func formatDecimal(n int64, digits int) string {
+	sign := ""
+	u := uint64(n)
+	if n < 0 {
+		sign = "-"
+		u = uint64(-n)
+	}
+	s := strconv.FormatUint(u, 10)
+	if digits == 0 {
+		return sign + s
+	}
+	if len(s) <= digits {
+		s = strings.Repeat("0", digits-len(s)+1) + s
+	}
+	return sign + s[:len(s)-digits] + "." + s[len(s)-digits:]
+}
@@ -0,0 +1,110 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := map[string]int64{"19": 1900, "7.5": 750, "0.05": 5, "-1.20": -120, "5.000": 500}
+	for s, exp := range cases {
+		m, err := Parse(s, "EUR")
+		if err != nil || m.Amount() != exp {
+			t.Errorf("Parsed %q to %d (%v) instead of %d", s, m.Amount(), err, exp)
+		}
+	}
+	if _, err := Parse("0.001", "EUR"); err != ErrTooManyDecimals {
+		t.Errorf("Parse of too many decimals: %v instead of %v", err, ErrTooManyDecimals)
+	}
+	for _, s := range []string{"", ".", "1.2.3", "abc", "1,00"} {
+		if _, err := Parse(s, "EUR"); err != ErrInvalidAmount {
+			t.Errorf("Parse of %q: %v instead of %v", s, err, ErrInvalidAmount)
+		}
+	}
+	if m, _ := Parse("100", "JPY"); m.Amount() != 100 {
+		t.Errorf("Parse of JPY amount is %d instead of 100", m.Amount())
+	}
+}
+
+func TestZeroValueIsDefaultCurrency(t *testing.T) {
+	var z Money
+	if z != New(0, DefaultCurrency) || z.Currency() != DefaultCurrency {
+		t.Errorf("Zero value %v is not a zero amount of %s", z, DefaultCurrency)
+	}
+	if s := z.String(); s != "0.00 EUR" {
+		t.Errorf("Zero value string is %q instead of %q", s, "0.00 EUR")
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	p := MustParse("7.50", "EUR")
+	if res := p.Mul(3).Add(New(1, "EUR")).Sub(New(50, "EUR")); res != New(2201, "EUR") {
+		t.Errorf("7.50 * 3 + 0.01 - 0.50 is %v instead of 22.01 EUR", res)
+	}
+}
+
+func TestZeroMatchesAnyCurrency(t *testing.T) {
+	var z Money
+	if res := z.Add(New(100, "USD")); res != New(100, "USD") {
+		t.Errorf("Zero plus 1.00 USD is %v instead of 1.00 USD", res)
+	}
+}
+
+func TestCurrencyMismatch(t *testing.T) {
+	defer func() {
+		if r := recover(); r != ErrCurrencyMismatch {
+			t.Errorf("Adding different currencies recovered %v instead of %v", r, ErrCurrencyMismatch)
+		}
+	}()
+	New(1, "EUR").Add(New(1, "USD"))
+}
+
+func TestPercentRounding(t *testing.T) {
+	cases := []struct {
+		amount int64
+		mode   RoundingMode
+		exp    int64
+	}{
+		{25, HalfEven, 2}, {35, HalfEven, 4}, {-25, HalfEven, -2},
+		{25, HalfUp, 3}, {-25, HalfUp, -3},
+		{25, HalfDown, 2}, {27, HalfDown, 3},
+		{29, Down, 2}, {21, Up, 3},
+	}
+	for _, c := range cases {
+		if res := New(c.amount, "EUR").Percent(Percent(10), c.mode); res.Amount() != c.exp {
+			t.Errorf("10%% of %d with mode %d is %d instead of %d", c.amount, c.mode, res.Amount(), c.exp)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	r, err := ParseRate("12.5%")
+	if err != nil || r != 1250 {
+		t.Errorf("Parsed rate 12.5%% to %v (%v) instead of 12.50%%", r, err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	m := MustParse("19.00", "EUR")
+	j, err := json.Marshal(m)
+	if err != nil || string(j) != `{"amount":"19.00","currency":"EUR"}` {
+		t.Fatalf("Marshalled %v to %s (%v)", m, j, err)
+	}
+	var res Money
+	if err := json.Unmarshal(j, &res); err != nil || res != m {
+		t.Errorf("Unmarshalled %s to %v (%v) instead of %v", j, res, err, m)
+	}
+}
+
+func TestRateJSON(t *testing.T) {
+	var r1, r2 Rate
+	if err := json.Unmarshal([]byte(`12.5`), &r1); err != nil || r1 != 1250 {
+		t.Errorf("Unmarshalled number 12.5 to %v (%v)", r1, err)
+	}
+	if err := json.Unmarshal([]byte(`"12.5"`), &r2); err != nil || r2 != 1250 {
+		t.Errorf("Unmarshalled string \"12.5\" to %v (%v)", r2, err)
+	}
+	if j, _ := json.Marshal(r1); string(j) != `"12.50"` {
+		t.Errorf("Marshalled %v to %s instead of \"12.50\"", r1, j)
+	}
+}
@@ -0,0 +1,45 @@
+package money
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const rateScale = 10000
+
+// Rate is a percentage expressed in hundredths of a percent (e.g. 1250 is 12.5%)
+type Rate int64
+
+// Percent creates a rate from an integer percentage
+func Percent(p int64) Rate {
+	return Rate(p * 100)
+}
+
+// ParseRate creates a rate from its decimal percentage (e.g. "12.5")
+func ParseRate(s string) (Rate, error) {
+	r, err := parseDecimal(strings.TrimSuffix(strings.TrimSpace(s), "%"), 2)
+	if err != nil {
+		return 0, err
+	}
+	return Rate(r), nil
+}
+
+func (r Rate) String() string {
+	return formatDecimal(int64(r), 2) + "%"
+}
+
+// MarshalJSON serializes the rate as a decimal percentage string
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(formatDecimal(int64(r), 2))
+}
+
+// UnmarshalJSON deserializes a rate from a decimal percentage string or number
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	res, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+	*r = res
+	return nil
+}
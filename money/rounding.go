@@ -0,0 +1,49 @@
+package money
+
+// RoundingMode is the way a fractional amount of minor units is rounded
+type RoundingMode int
+
+const (
+	// HalfEven rounds to the nearest neighbor, ties to the even one (banker's rounding)
+	HalfEven RoundingMode = iota
+	// HalfUp rounds to the nearest neighbor, ties away from zero
+	HalfUp
+	// HalfDown rounds to the nearest neighbor, ties towards zero
+	HalfDown
+	// Down rounds towards zero (truncation)
+	Down
+	// Up rounds away from zero
+	Up
+)
+
+// divRound divides n by a positive d rounding the result with mode
+func divRound(n, d int64, mode RoundingMode) int64 {
+	q, r := n/d, n%d
+	if r == 0 {
+		return q
+	}
+	sign := int64(1)
+	if n < 0 {
+		sign = -1
+		r = -r
+	}
+	switch mode {
+	case Down:
+		return q
+	case Up:
+		return q + sign
+	case HalfUp:
+		if 2*r >= d {
+			return q + sign
+		}
+	case HalfDown:
+		if 2*r > d {
+			return q + sign
+		}
+	default:
+		if 2*r > d || 2*r == d && q%2 != 0 {
+			return q + sign
+		}
+	}
+	return q
+}
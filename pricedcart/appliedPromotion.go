@@ -0,0 +1,21 @@
+package pricedcart
+
+import (
+	"fmt"
+	"shopping-cart-kata/money"
+	"shopping-cart-kata/promotion"
+)
+
+// AppliedPromotion explains how a promotion rule changed the priced cart
+// ItemID is empty for promotions applied to the whole cart
+type AppliedPromotion struct {
+	Rule     promotion.RuleRef
+	ItemID   string
+	Quantity int
+	Saving   money.Money
+}
+
+func (ap AppliedPromotion) String() string {
+	f := `{ "rule": %q, "label": %q, "itemId": %q, "quantity": %d, "saving": "%s" }`
+	return fmt.Sprintf(f, ap.Rule.Code, ap.Rule.Label, ap.ItemID, ap.Quantity, ap.Saving)
+}
@@ -3,16 +3,17 @@ package pricedcart
 import (
 	"fmt"
 	"shopping-cart-kata/cart"
+	"shopping-cart-kata/money"
 )
 
 // Item represents a shopping cart item with price
 type Item struct {
 	cart.Item
-	UnitPrice  float64
-	TotalPrice float64
+	UnitPrice  money.Money
+	TotalPrice money.Money
 }
 
 func (i Item) String() string {
-	msg := `{ "id": %q, "quantity": %d, "unitPrice": %g, "totalPrice": %g }`
+	msg := `{ "id": %q, "quantity": %d, "unitPrice": "%s", "totalPrice": "%s" }`
 	return fmt.Sprintf(msg, i.ID, i.Quantity, i.UnitPrice, i.TotalPrice)
 }
@@ -3,6 +3,7 @@ package pricedcart
 import (
 	"fmt"
 	"shopping-cart-kata/cart"
+	"shopping-cart-kata/money"
 	"shopping-cart-kata/promotion"
 )
 
@@ -10,9 +11,12 @@ import (
 type PricedCart interface {
 	GetID() int64
 	GetQuantity() int
-	GetSubtotal() float64
+	GetSubtotal() money.Money
 	GetItems() []Item
 	ApplyPromotions(ps promotion.PromoSet) PricedCart
+	GetAppliedPromotions() []AppliedPromotion
+	MarkDegraded(reasons ...string) PricedCart
+	GetDegradedReasons() []string
 }
 
 // DummyPricedCart is the implementation of the null object pattern
@@ -21,31 +25,35 @@ var DummyPricedCart = new(pricedCart)
 type pricedCart struct {
 	cartID   int64
 	quantity int
-	subTotal float64
+	subTotal money.Money
 	items    map[string]*Item
+	prices   map[string]money.Money
+	promos   []AppliedPromotion
+	degraded []string
 }
 
 // NewPricedCart creates a new priced cart from a cart and prices
-func NewPricedCart(c cart.Cart, prices map[string]float64) PricedCart {
+func NewPricedCart(c cart.Cart, prices map[string]money.Money) PricedCart {
 	if c == nil {
 		return DummyPricedCart
 	}
 	if prices == nil {
-		prices = make(map[string]float64)
+		prices = make(map[string]money.Money)
 	}
 	pc := new(pricedCart)
 	pc.cartID = c.GetID()
 	pc.quantity = c.GetQuantity()
 	items := c.GetItems()
 	pc.items = make(map[string]*Item)
+	pc.prices = prices
 	for _, i := range items {
 		pi := Item{Item: cart.Item{ID: i.ID, Quantity: i.Quantity}}
 		if p, ok := prices[i.ID]; ok {
 			pi.UnitPrice = p
-			pi.TotalPrice = p * float64(i.Quantity)
+			pi.TotalPrice = p.Mul(int64(i.Quantity))
 		}
 		pc.items[i.ID] = &pi
-		pc.subTotal += pi.TotalPrice
+		pc.subTotal = pc.subTotal.Add(pi.TotalPrice)
 	}
 	return pc
 }
@@ -59,7 +67,7 @@ func (c *pricedCart) GetQuantity() int {
 	return c.quantity
 }
 
-func (c *pricedCart) GetSubtotal() float64 {
+func (c *pricedCart) GetSubtotal() money.Money {
 	return c.subTotal
 }
 
@@ -74,28 +82,60 @@ func (c *pricedCart) GetItems() []Item {
 	return items
 }
 
+// ApplyPromotions applies the promotions: discounts stacked on the same item add up their savings
 func (c *pricedCart) ApplyPromotions(ps promotion.PromoSet) PricedCart {
 	pc := c
-	for _, d := range ps.CartItemDiscounts {
+	for idx, d := range ps.CartItemDiscounts {
 		if i, ok := pc.items[d.ItemID]; ok {
-			newTotal := i.UnitPrice*float64(i.Quantity-d.AffectedQty) + d.Discount.ApplyTo(i.UnitPrice)*float64(d.AffectedQty)
-			pc.subTotal = pc.subTotal - i.TotalPrice + newTotal
+			saving := i.UnitPrice.Sub(d.Discount.ApplyTo(i.UnitPrice)).Mul(int64(d.AffectedQty))
+			newTotal := i.TotalPrice.Sub(saving)
+			if newTotal.Amount() < 0 {
+				newTotal = money.New(0, newTotal.Currency())
+			}
+			pc.subTotal = pc.subTotal.Sub(i.TotalPrice).Add(newTotal)
+			pc.addAppliedPromotion(ps.ItemDiscountRule(idx), d.ItemID, d.AffectedQty, i.TotalPrice.Sub(newTotal))
 			i.TotalPrice = newTotal
 		}
 	}
-	for _, p := range ps.CartPresents {
+	for idx, p := range ps.CartPresents {
 		pc.items[p.ArtCode] = &Item{
 			Item:       cart.Item{ID: p.ArtCode, Quantity: p.Quantity},
-			UnitPrice:  0,
-			TotalPrice: 0,
+			UnitPrice:  money.Money{},
+			TotalPrice: money.Money{},
 		}
+		saving := pc.prices[p.ArtCode].Mul(int64(p.Quantity))
+		pc.addAppliedPromotion(ps.PresentRule(idx), p.ArtCode, p.Quantity, saving)
 	}
-	pc.subTotal = ps.CartSubtotalDiscount.Discount.ApplyTo(c.subTotal)
+	discounted := ps.CartSubtotalDiscount.Discount.ApplyTo(c.subTotal)
+	if ps.CartSubtotalDiscount.Discount.Mode != promotion.None {
+		pc.addAppliedPromotion(ps.CartSubtotalDiscountRule, "", pc.quantity, c.subTotal.Sub(discounted))
+	}
+	pc.subTotal = discounted
 	// ps.ShippingDiscount is used by checkout, not cart
 	return pc
 }
 
+// GetAppliedPromotions returns the promotions applied in order with the amount saved
+func (c *pricedCart) GetAppliedPromotions() []AppliedPromotion {
+	return c.promos
+}
+
+func (c *pricedCart) addAppliedPromotion(r promotion.RuleRef, itemID string, qty int, saving money.Money) {
+	c.promos = append(c.promos, AppliedPromotion{Rule: r, ItemID: itemID, Quantity: qty, Saving: saving})
+}
+
+// MarkDegraded flags the priced cart as computed without some promotions for the given reasons
+func (c *pricedCart) MarkDegraded(reasons ...string) PricedCart {
+	c.degraded = append(c.degraded, reasons...)
+	return c
+}
+
+// GetDegradedReasons returns why some promotions were not applied: the cart is degraded if not empty
+func (c *pricedCart) GetDegradedReasons() []string {
+	return c.degraded
+}
+
 func (c *pricedCart) String() string {
-	f := `{ "id": %d, "quantity": %d, "subTotal": %g, "items": %v}`
+	f := `{ "id": %d, "quantity": %d, "subTotal": "%s", "items": %v}`
 	return fmt.Sprintf(f, c.GetID(), c.GetQuantity(), c.GetSubtotal(), c.GetItems())
 }
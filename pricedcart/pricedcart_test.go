@@ -2,13 +2,14 @@ package pricedcart
 
 import (
 	"shopping-cart-kata/cart"
+	"shopping-cart-kata/money"
 	"shopping-cart-kata/promotion"
 	"testing"
 )
 
 func TestDummyPricedCart(t *testing.T) {
 	pc1 := NewPricedCart(nil, nil)
-	pc2 := NewPricedCart(nil, make(map[string]float64))
+	pc2 := NewPricedCart(nil, make(map[string]money.Money))
 	msg := "PricedCart %v returned instead of DummyPricedCart %v"
 	if pc1 != DummyPricedCart {
 		t.Errorf(msg, pc1, DummyPricedCart)
@@ -20,17 +21,17 @@ func TestDummyPricedCart(t *testing.T) {
 
 func TestPrices(t *testing.T) {
 	const (
-		cartID    = 1
-		artID     = "article"
-		artQty    = 2
-		unitPrice = 25.0
-		totPrice  = unitPrice * artQty
-		nArt      = 1
+		cartID = 1
+		artID  = "article"
+		artQty = 2
+		nArt   = 1
 	)
+	unitPrice := money.New(2500, "EUR")
+	totPrice := unitPrice.Mul(artQty)
 	c, _ := cart.NewCart(cartID)
 	c.AddArticle(artID, artQty)
 	pc1 := NewPricedCart(c, nil)
-	pc2 := NewPricedCart(c, map[string]float64{artID: unitPrice})
+	pc2 := NewPricedCart(c, map[string]money.Money{artID: unitPrice})
 	cartQty1 := pc1.GetQuantity()
 	cartQty2 := pc2.GetQuantity()
 	items1 := pc1.GetItems()
@@ -39,13 +40,13 @@ func TestPrices(t *testing.T) {
 	if cartQty1 != artQty {
 		t.Errorf("Cart 1 quantity is %d instead of %d", cartQty1, artQty)
 	}
-	if st := pc1.GetSubtotal(); st != 0 {
-		t.Errorf("Cart 1 subtotal is %g instead of %g", st, 0.0)
+	if st := pc1.GetSubtotal(); !st.IsZero() {
+		t.Errorf("Cart 1 subtotal is %s instead of 0", st)
 	}
 	if n := len(items1); n != nArt {
 		t.Fatalf("Cart 1 contains %d items instead of %d", n, nArt)
 	}
-	exp1 := Item{Item: cart.Item{ID: artID, Quantity: artQty}, UnitPrice: 0, TotalPrice: 0}
+	exp1 := Item{Item: cart.Item{ID: artID, Quantity: artQty}, UnitPrice: money.Money{}, TotalPrice: money.Money{}}
 	if items1[0] != exp1 {
 		t.Errorf("Cart item %s does not match %s", items1[0], exp1)
 	}
@@ -54,7 +55,7 @@ func TestPrices(t *testing.T) {
 		t.Errorf("Cart 2 quantity is %d instead of %d", cartQty2, artQty)
 	}
 	if st := pc2.GetSubtotal(); st != totPrice {
-		t.Errorf("Cart 2 subtotal is %g instead of %g", st, totPrice)
+		t.Errorf("Cart 2 subtotal is %s instead of %s", st, totPrice)
 	}
 	if n := len(items2); n != nArt {
 		t.Fatalf("Cart 2 contains %d items instead of %d", n, nArt)
@@ -67,19 +68,18 @@ func TestPrices(t *testing.T) {
 
 func TestPromotions(t *testing.T) {
 	const (
-		cartID    = 1
-		artID     = "article"
-		artQty    = 2
-		unitPrice = 25.0
-		totPrice  = unitPrice * artQty
-		promPrice = unitPrice
-		nArt      = 1
+		cartID = 1
+		artID  = "article"
+		artQty = 2
+		nArt   = 1
 	)
+	unitPrice := money.New(2500, "EUR")
+	promPrice := unitPrice
 	c, _ := cart.NewCart(cartID)
 	c.AddArticle(artID, artQty)
-	pc := NewPricedCart(c, map[string]float64{artID: unitPrice})
+	pc := NewPricedCart(c, map[string]money.Money{artID: unitPrice})
 	disc := promotion.CartItemDiscount{
-		Discount:    promotion.Discount{Mode: promotion.Percentage, Value: 100},
+		Discount:    promotion.Discount{Mode: promotion.Percentage, Rate: money.Percent(100)},
 		ItemID:      artID,
 		AffectedQty: (artQty / 2),
 	}
@@ -87,10 +87,88 @@ func TestPromotions(t *testing.T) {
 	items := pc.GetItems()
 
 	if st := pc.GetSubtotal(); st != promPrice {
-		t.Errorf("Cart 1 subtotal is %g instead of %g", st, promPrice)
+		t.Errorf("Cart 1 subtotal is %s instead of %s", st, promPrice)
 	}
 	exp := Item{Item: cart.Item{ID: artID, Quantity: artQty}, UnitPrice: unitPrice, TotalPrice: promPrice}
 	if items[0] != exp {
 		t.Errorf("Cart item %s does not match %s", items[0], exp)
 	}
 }
+
+func TestPercentagePromotionsAreExact(t *testing.T) {
+	const (
+		cartID = 1
+		artID  = "article"
+		artQty = 3
+	)
+	c, _ := cart.NewCart(cartID)
+	c.AddArticle(artID, artQty)
+	pc := NewPricedCart(c, map[string]money.Money{artID: money.MustParse("0.35", "EUR")})
+	disc := promotion.CartItemDiscount{
+		Discount:    promotion.Discount{Mode: promotion.Percentage, Rate: money.Percent(10)},
+		ItemID:      artID,
+		AffectedQty: artQty,
+	}
+	pc.ApplyPromotions(promotion.PromoSet{CartItemDiscounts: []promotion.CartItemDiscount{disc}})
+	exp := money.MustParse("0.93", "EUR")
+	if st := pc.GetSubtotal(); st != exp {
+		t.Errorf("Cart subtotal is %s instead of %s", st, exp)
+	}
+}
+
+func TestStackedPromotionsAddUpSavings(t *testing.T) {
+	const (
+		cartID = 1
+		artID  = "article"
+		artQty = 2
+	)
+	c, _ := cart.NewCart(cartID)
+	c.AddArticle(artID, artQty)
+	pc := NewPricedCart(c, map[string]money.Money{artID: money.MustParse("10.00", "EUR")})
+	pct := promotion.CartItemDiscount{
+		Discount:    promotion.Discount{Mode: promotion.Percentage, Rate: money.Percent(10)},
+		ItemID:      artID,
+		AffectedQty: artQty,
+	}
+	amt := promotion.CartItemDiscount{
+		Discount:    promotion.Discount{Mode: promotion.Amount, Value: money.MustParse("2.00", "EUR")},
+		ItemID:      artID,
+		AffectedQty: 1,
+	}
+	pc.ApplyPromotions(promotion.PromoSet{CartItemDiscounts: []promotion.CartItemDiscount{pct, amt}})
+	exp := money.MustParse("16.00", "EUR")
+	if st := pc.GetSubtotal(); st != exp {
+		t.Errorf("Cart subtotal is %s instead of %s", st, exp)
+	}
+}
+
+func TestAppliedPromotions(t *testing.T) {
+	c, _ := cart.NewCart(1)
+	c.AddArticle("VOUCHER", 3)
+	c.AddArticle("TSHIRT", 3)
+	prices := map[string]money.Money{"VOUCHER": money.New(500, "EUR"), "TSHIRT": money.New(2000, "EUR")}
+	pc := NewPricedCart(c, prices)
+	voucherRule := promotion.RuleRef{ID: 1, Code: "VOUCHER2X1", Label: "Buy one voucher get one free"}
+	tshirtRule := promotion.RuleRef{ID: 2, Code: "TSHIRT3PLUS", Label: "T-shirts at 19.00 when buying 3 or more"}
+	ps := promotion.PromoSet{
+		CartItemDiscounts: []promotion.CartItemDiscount{
+			{Discount: promotion.Discount{Mode: promotion.Percentage, Rate: money.Percent(100)}, ItemID: "VOUCHER", AffectedQty: 1},
+			{Discount: promotion.Discount{Mode: promotion.NewValue, Value: money.New(1900, "EUR")}, ItemID: "TSHIRT", AffectedQty: 3},
+		},
+		CartItemDiscountRules: []promotion.RuleRef{voucherRule, tshirtRule},
+	}
+	pc.ApplyPromotions(ps)
+	exp := []AppliedPromotion{
+		{Rule: voucherRule, ItemID: "VOUCHER", Quantity: 1, Saving: money.New(500, "EUR")},
+		{Rule: tshirtRule, ItemID: "TSHIRT", Quantity: 3, Saving: money.New(300, "EUR")},
+	}
+	applied := pc.GetAppliedPromotions()
+	if len(applied) != len(exp) {
+		t.Fatalf("Applied promotions %v instead of %v", applied, exp)
+	}
+	for i, ap := range applied {
+		if ap != exp[i] {
+			t.Errorf("Applied promotion %s instead of %s", ap, exp[i])
+		}
+	}
+}
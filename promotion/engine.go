@@ -1,46 +1,112 @@
 package promotion
 
 import (
+	"errors"
+	"reflect"
 	"shopping-cart-kata/cart"
+	"shopping-cart-kata/money"
+	"sort"
 	"sync"
+	"time"
 )
 
+// ErrRuleNotFound when no rule has the given code
+var ErrRuleNotFound = errors.New("Unable to find the rule")
+
+// ErrDuplicateRule when a rule with the same code already exists
+var ErrDuplicateRule = errors.New("Rule already existent")
+
 // Engine managing promotions
+// ApplyRules skips the rules that cannot be applied reporting them as *RuleError by rule ID
+// ApplyRulesAt applies the rule set in place at a past moment: moments before RecordedSince use the oldest recorded rule set
+// Rule sets are recorded in memory only, identical consecutive ones once, and the oldest are dropped beyond historyLimit
 type Engine interface {
-	ApplyRules(c cart.Cart, prices map[string]float64) (PromoSet, map[int64]error)
-	AddRule(f *func(c cart.Cart, prices map[string]float64) []interface{}) (int64, bool)
+	ApplyRules(c cart.Cart, prices map[string]money.Money) (PromoSet, map[int64]error)
+	ApplyRulesAt(at time.Time, c cart.Cart, prices map[string]money.Money) (PromoSet, map[int64]error)
+	AddRule(f *func(c cart.Cart, prices map[string]money.Money) []interface{}) (int64, bool)
+	AddRuleDef(d RuleDef) (int64, error)
+	GetRuleDefs() []RuleDef
+	GetRuleDef(code string) (RuleDef, bool)
+	UpdateRuleDef(d RuleDef) error
+	EnableRule(code string, enabled bool) error
+	DelRuleDef(code string) error
+	ReplaceRules(defs []RuleDef) error
 	DelRule(id int64)
+	RecordedSince() time.Time
 }
 
 type engine struct {
 	sync.RWMutex
+	currency string
 	numRules int64
 	rules    map[int64]rule
+	codes    map[string]int64
+	history  []ruleSet
+	created  time.Time
 }
 
-// NewEngine creates a promotion engine
+// ruleSet is a rule set in place from a moment on
+type ruleSet struct {
+	from  time.Time
+	rules map[int64]rule
+}
+
+// now is the clock stamping rule set changes
+var now = time.Now
+
+// historyLimit is the number of rule sets recorded
+var historyLimit = 1000
+
+// NewEngine creates a promotion engine for a catalog priced in the default currency
 func NewEngine() Engine {
+	return NewEngineIn(money.DefaultCurrency)
+}
+
+// NewEngineIn creates a promotion engine rejecting rule definitions not in the catalog currency
+func NewEngineIn(currency string) Engine {
 	e := new(engine)
+	e.currency = currency
+	e.created = now()
 	e.rules = make(map[int64]rule)
+	e.codes = make(map[string]int64)
 	return e
 }
 
-func (e *engine) ApplyRules(c cart.Cart, prices map[string]float64) (PromoSet, map[int64]error) {
+func (e *engine) ApplyRules(c cart.Cart, prices map[string]money.Money) (PromoSet, map[int64]error) {
 	e.RLock()
 	defer e.RUnlock()
-	var promoSet PromoSet
-	errors := make(map[int64]error)
-	for i, r := range e.rules {
-		for _, p := range r.apply(c, prices) {
-			if err := promoSet.addPromo(p); err != nil {
-				errors[i] = err
-			}
+	return applyRules(e.rules, c, prices)
+}
+
+// ApplyRulesAt applies the rule set in place at the given moment
+func (e *engine) ApplyRulesAt(at time.Time, c cart.Cart, prices map[string]money.Money) (PromoSet, map[int64]error) {
+	e.RLock()
+	defer e.RUnlock()
+	if len(e.history) == 0 {
+		return applyRules(e.rules, c, prices)
+	}
+	i := sort.Search(len(e.history), func(i int) bool { return e.history[i].from.After(at) })
+	if i > 0 {
+		i--
+	}
+	return applyRules(e.history[i].rules, c, prices)
+}
+
+func applyRules(rules map[int64]rule, c cart.Cart, prices map[string]money.Money) (PromoSet, map[int64]error) {
+	enabled := make(map[int64]rule, len(rules))
+	for id, r := range rules {
+		if r.def == nil || !r.def.Disabled {
+			enabled[id] = r
 		}
 	}
-	return promoSet, nil
+	promoSet, errors := resolve(orderedRules(enabled), c, prices)
+	if len(errors) == 0 {
+		return promoSet, nil
+	}
+	return promoSet, errors
 }
 
-func (e *engine) AddRule(f *func(c cart.Cart, prices map[string]float64) []interface{}) (int64, bool) {
+func (e *engine) AddRule(f *func(c cart.Cart, prices map[string]money.Money) []interface{}) (int64, bool) {
 	if f == nil {
 		return 0, false
 	}
@@ -49,11 +115,180 @@ func (e *engine) AddRule(f *func(c cart.Cart, prices map[string]float64) []inter
 	r := rule{funcPtr: f}
 	e.numRules++
 	e.rules[e.numRules] = r
+	e.record()
 	return e.numRules, true
 }
 
+// AddRuleDef validates a declarative rule definition and adds the rule it describes
+func (e *engine) AddRuleDef(d RuleDef) (int64, error) {
+	if err := d.ValidateIn(e.currency); err != nil {
+		return 0, err
+	}
+	r := ruleFromDef(d)
+	e.Lock()
+	defer e.Unlock()
+	if _, ok := e.codes[d.Code]; ok {
+		return 0, ErrDuplicateRule
+	}
+	e.numRules++
+	e.rules[e.numRules] = r
+	e.codes[d.Code] = e.numRules
+	e.record()
+	return e.numRules, nil
+}
+
+// GetRuleDefs returns the declarative rule definitions in insertion order
+func (e *engine) GetRuleDefs() []RuleDef {
+	e.RLock()
+	defer e.RUnlock()
+	ids := make([]int64, 0, len(e.codes))
+	for _, id := range e.codes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	defs := make([]RuleDef, len(ids))
+	for i, id := range ids {
+		defs[i] = *e.rules[id].def
+	}
+	return defs
+}
+
+// GetRuleDef returns the declarative rule definition with the given code
+func (e *engine) GetRuleDef(code string) (RuleDef, bool) {
+	e.RLock()
+	defer e.RUnlock()
+	id, ok := e.codes[code]
+	if !ok {
+		return RuleDef{}, false
+	}
+	return *e.rules[id].def, true
+}
+
+// UpdateRuleDef replaces the rule having the same code of the definition keeping its position
+func (e *engine) UpdateRuleDef(d RuleDef) error {
+	if err := d.ValidateIn(e.currency); err != nil {
+		return err
+	}
+	r := ruleFromDef(d)
+	e.Lock()
+	defer e.Unlock()
+	id, ok := e.codes[d.Code]
+	if !ok {
+		return ErrRuleNotFound
+	}
+	e.rules[id] = r
+	e.record()
+	return nil
+}
+
+// EnableRule enables or disables the rule with the given code
+func (e *engine) EnableRule(code string, enabled bool) error {
+	e.Lock()
+	defer e.Unlock()
+	id, ok := e.codes[code]
+	if !ok {
+		return ErrRuleNotFound
+	}
+	d := *e.rules[id].def
+	d.Disabled = !enabled
+	e.rules[id] = rule{funcPtr: e.rules[id].funcPtr, def: &d}
+	e.record()
+	return nil
+}
+
+// DelRuleDef removes the rule with the given code
+func (e *engine) DelRuleDef(code string) error {
+	e.Lock()
+	defer e.Unlock()
+	id, ok := e.codes[code]
+	if !ok {
+		return ErrRuleNotFound
+	}
+	delete(e.rules, id)
+	delete(e.codes, code)
+	e.record()
+	return nil
+}
+
+// ReplaceRules atomically swaps the whole rule set with the one described by the definitions
+// If any definition is not valid the current rule set is kept
+func (e *engine) ReplaceRules(defs []RuleDef) error {
+	rules := make([]rule, len(defs))
+	codes := make(map[string]bool, len(defs))
+	for i, d := range defs {
+		if err := d.ValidateIn(e.currency); err != nil {
+			return err
+		}
+		if codes[d.Code] {
+			return &ValidationError{Code: d.Code, Fields: []FieldError{{"code", "is duplicated"}}}
+		}
+		codes[d.Code] = true
+		rules[i] = ruleFromDef(d)
+	}
+	e.Lock()
+	defer e.Unlock()
+	e.rules = make(map[int64]rule, len(rules))
+	e.codes = make(map[string]int64, len(rules))
+	for _, r := range rules {
+		e.numRules++
+		e.rules[e.numRules] = r
+		e.codes[r.def.Code] = e.numRules
+	}
+	e.record()
+	return nil
+}
+
 func (e *engine) DelRule(id int64) {
 	e.Lock()
 	defer e.Unlock()
+	if r, ok := e.rules[id]; ok && r.def != nil {
+		delete(e.codes, r.def.Code)
+	}
 	delete(e.rules, id)
+	e.record()
+}
+
+// RecordedSince returns the moment of the oldest recorded rule set, the creation of the engine if none
+func (e *engine) RecordedSince() time.Time {
+	e.RLock()
+	defer e.RUnlock()
+	if len(e.history) == 0 {
+		return e.created
+	}
+	return e.history[0].from
+}
+
+// record appends a copy of the current rule set to the history unless it is the same as the last one
+func (e *engine) record() {
+	if n := len(e.history); n > 0 && sameRules(e.history[n-1].rules, e.rules) {
+		return
+	}
+	rules := make(map[int64]rule, len(e.rules))
+	for id, r := range e.rules {
+		rules[id] = r
+	}
+	e.history = append(e.history, ruleSet{from: now(), rules: rules})
+	if over := len(e.history) - historyLimit; over > 0 {
+		e.history = append([]ruleSet(nil), e.history[over:]...)
+	}
+}
+
+// sameRules tells if two rule sets apply the same rules in the same order, even if with different IDs
+func sameRules(a, b map[int64]rule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	ra, rb := orderedRules(a), orderedRules(b)
+	for i := range ra {
+		da, db := ra[i].def, rb[i].def
+		switch {
+		case da == nil && db == nil:
+			if ra[i].funcPtr != rb[i].funcPtr {
+				return false
+			}
+		case da == nil || db == nil || !reflect.DeepEqual(*da, *db):
+			return false
+		}
+	}
+	return true
 }
@@ -2,7 +2,11 @@ package promotion
 
 import (
 	"shopping-cart-kata/cart"
+	"shopping-cart-kata/money"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestAddRule(t *testing.T) {
@@ -25,7 +29,7 @@ func TestApplyRule(t *testing.T) {
 		discPrice = 19.0
 	)
 	c.AddArticle(artCod, artQty)
-	exp := CartItemDiscount{Discount: Discount{Mode: NewValue, Value: 19}, ItemID: artCod, AffectedQty: artQty}
+	exp := CartItemDiscount{Discount: Discount{Mode: NewValue, Value: money.New(1900, "EUR")}, ItemID: artCod, AffectedQty: artQty}
 	promos := e.rules[id].apply(c, getPrices(c))
 	if n := len(promos); n != 1 {
 		t.Fatalf("Generated %d promos instead of 1", n)
@@ -52,7 +56,7 @@ func TestDiscountForThreeOrMore(t *testing.T) {
 		t.Fatalf("Retrieved %d discounts instead of 1", n)
 	}
 	discount := promoSet.CartItemDiscounts[0]
-	exp := CartItemDiscount{Discount: Discount{Mode: NewValue, Value: 19}, ItemID: artCod, AffectedQty: artQty}
+	exp := CartItemDiscount{Discount: Discount{Mode: NewValue, Value: money.New(1900, "EUR")}, ItemID: artCod, AffectedQty: artQty}
 	if discount != exp {
 		t.Errorf("Discount %v not as expected: %v", discount, exp)
 	}
@@ -81,7 +85,7 @@ func TestTwoForOne(t *testing.T) {
 	if n2 := len(promos2.CartItemDiscounts); n2 != 1 {
 		t.Fatalf("Retrieved %d discounts instead of 1 for cart %v", n2, c2)
 	}
-	exp := CartItemDiscount{Discount: Discount{Mode: Percentage, Value: 100}, ItemID: artCod, AffectedQty: 0}
+	exp := CartItemDiscount{Discount: Discount{Mode: Percentage, Rate: money.Percent(100)}, ItemID: artCod, AffectedQty: 0}
 	exp.AffectedQty = aff1
 	if promos1.CartItemDiscounts[0] != exp {
 		t.Errorf("Discount %v not as expected: %v", promos1.CartItemDiscounts[0], exp)
@@ -130,7 +134,7 @@ func Test2VoucherTshirt(t *testing.T) {
 	c1.AddArticle(tshirt, 1)
 	c1.SetArticleQty(voucher, 2)
 	promos, _ := e.ApplyRules(c1, getPrices(c1))
-	exp := CartItemDiscount{Discount: Discount{Mode: Percentage, Value: 100}, ItemID: voucher, AffectedQty: 1}
+	exp := CartItemDiscount{Discount: Discount{Mode: Percentage, Rate: money.Percent(100)}, ItemID: voucher, AffectedQty: 1}
 	if n := len(promos.CartItemDiscounts); n != 1 {
 		t.Fatalf("Discounts are %d instead of 1", n)
 	}
@@ -157,7 +161,7 @@ func TestVoucher4Tshirt(t *testing.T) {
 	c1.AddArticle(voucher, 1)
 	c1.SetArticleQty(tshirt, 4)
 	promos, _ := e.ApplyRules(c1, getPrices(c1))
-	exp := CartItemDiscount{Discount: Discount{Mode: NewValue, Value: 19}, ItemID: tshirt, AffectedQty: 4}
+	exp := CartItemDiscount{Discount: Discount{Mode: NewValue, Value: money.New(1900, "EUR")}, ItemID: tshirt, AffectedQty: 4}
 	if n := len(promos.CartItemDiscounts); n != 1 {
 		t.Fatalf("Discounts are %d instead of 1", n)
 	}
@@ -187,8 +191,8 @@ func Test3Voucher3TshirtMug(t *testing.T) {
 	c1.SetArticleQty(tshirt, 2)
 	c1.SetArticleQty(tshirt, 3)
 	promos, _ := e.ApplyRules(c1, getPrices(c1))
-	exp1 := CartItemDiscount{Discount: Discount{Mode: Percentage, Value: 100}, ItemID: voucher, AffectedQty: 1}
-	exp2 := CartItemDiscount{Discount: Discount{Mode: NewValue, Value: 19}, ItemID: tshirt, AffectedQty: 3}
+	exp1 := CartItemDiscount{Discount: Discount{Mode: Percentage, Rate: money.Percent(100)}, ItemID: voucher, AffectedQty: 1}
+	exp2 := CartItemDiscount{Discount: Discount{Mode: NewValue, Value: money.New(1900, "EUR")}, ItemID: tshirt, AffectedQty: 3}
 	if n := len(promos.CartItemDiscounts); n != 2 {
 		t.Fatalf("Discounts are %d instead of 2", n)
 	}
@@ -200,10 +204,256 @@ func Test3Voucher3TshirtMug(t *testing.T) {
 	}
 }
 
-func getPrices(c cart.Cart) map[string]float64 {
-	return map[string]float64{
-		"VOUCHER": 5.0,
-		"TSHIRT":  20.0,
-		"MUG":     7.5,
+func TestReplaceRules(t *testing.T) {
+	e := NewEngine()
+	f1 := TwoForOne
+	e.AddRule(&f1)
+	c, _ := cart.NewCart(1)
+	c.AddArticle("MUG", 2)
+	c.AddArticle("VOUCHER", 2)
+	defs := []RuleDef{{Code: "MUG10", Type: PercentOff, Articles: []string{"MUG"}, Percent: money.Percent(10)}}
+	if err := e.ReplaceRules(defs); err != nil {
+		t.Fatalf("Error replacing rules %v", err)
+	}
+	promos, _ := e.ApplyRules(c, getPrices(c))
+	if n := len(promos.CartItemDiscounts); n != 1 || promos.CartItemDiscounts[0].ItemID != "MUG" {
+		t.Fatalf("Discounts %v instead of only the MUG one", promos.CartItemDiscounts)
+	}
+	if err := e.ReplaceRules([]RuleDef{{Code: "BAD", Type: PercentOff}}); err == nil {
+		t.Fatalf("Invalid rule set replaced the current one")
+	}
+	promos, _ = e.ApplyRules(c, getPrices(c))
+	if n := len(promos.CartItemDiscounts); n != 1 || promos.CartItemDiscounts[0].ItemID != "MUG" {
+		t.Errorf("Rule set changed after invalid replacement: %v", promos.CartItemDiscounts)
+	}
+}
+
+func TestApplyRulesAt(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := start
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+	e := NewEngine()
+	mug := RuleDef{Code: "MUG10", Type: PercentOff, Articles: []string{"MUG"}, Percent: money.Percent(10)}
+	e.ReplaceRules([]RuleDef{mug})
+	clock = clock.Add(time.Hour)
+	e.EnableRule("MUG10", false)
+	clock = clock.Add(time.Hour)
+	e.AddRuleDef(RuleDef{Code: "VOUCHER2X1", Type: BuyXGetY, Articles: []string{"VOUCHER"}, Buy: 1, Free: 1})
+	c, _ := cart.NewCart(1)
+	c.AddArticle("MUG", 2)
+	c.AddArticle("VOUCHER", 2)
+	for at, exp := range map[time.Duration]string{-time.Hour: "MUG", 30 * time.Minute: "MUG", 90 * time.Minute: "", 3 * time.Hour: "VOUCHER"} {
+		promos, _ := e.ApplyRulesAt(start.Add(at), c, getPrices(c))
+		var got string
+		for _, d := range promos.CartItemDiscounts {
+			got += d.ItemID
+		}
+		if got != exp {
+			t.Errorf("Discounted items %q at %v instead of %q", got, at, exp)
+		}
+	}
+}
+
+func TestRuleHistoryIsBounded(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := start
+	now = func() time.Time { return clock }
+	limit := historyLimit
+	defer func() { now, historyLimit = time.Now, limit }()
+	historyLimit = 3
+	e := NewEngine()
+	if !e.RecordedSince().Equal(start) {
+		t.Errorf("Recorded since %v instead of the engine creation", e.RecordedSince())
+	}
+	mug := []RuleDef{{Code: "MUG10", Type: PercentOff, Articles: []string{"MUG"}, Percent: money.Percent(10)}}
+	for i := 0; i < 5; i++ {
+		clock = clock.Add(time.Hour)
+		e.ReplaceRules(mug)
+	}
+	if h := e.(*engine).history; len(h) != 1 || !h[0].from.Equal(start.Add(time.Hour)) {
+		t.Errorf("History %v instead of the first of the identical rule sets", h)
+	}
+	for i := 0; i < 5; i++ {
+		clock = clock.Add(time.Hour)
+		e.EnableRule("MUG10", i%2 == 1)
+	}
+	if h := e.(*engine).history; len(h) != historyLimit {
+		t.Errorf("History of %d rule sets instead of %d", len(h), historyLimit)
+	}
+	if since := e.RecordedSince(); !since.Equal(clock.Add(-2 * time.Hour)) {
+		t.Errorf("Recorded since %v instead of the oldest rule set kept", since)
+	}
+}
+
+func TestReplaceRulesIsAtomic(t *testing.T) {
+	e := NewEngine()
+	setA := []RuleDef{
+		{Code: "A1", Type: PercentOff, Articles: []string{"MUG"}, Percent: money.Percent(10)},
+		{Code: "A2", Type: PercentOff, Articles: []string{"TSHIRT"}, Percent: money.Percent(10)},
+	}
+	setB := []RuleDef{
+		{Code: "B1", Type: PercentOff, Articles: []string{"MUG"}, Percent: money.Percent(20)},
+		{Code: "B2", Type: PercentOff, Articles: []string{"TSHIRT"}, Percent: money.Percent(20)},
+	}
+	e.ReplaceRules(setA)
+	c, _ := cart.NewCart(1)
+	c.AddArticle("MUG", 1)
+	c.AddArticle("TSHIRT", 1)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			if i%2 == 0 {
+				e.ReplaceRules(setB)
+			} else {
+				e.ReplaceRules(setA)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			promos, _ := e.ApplyRules(c, getPrices(c))
+			ds := promos.CartItemDiscounts
+			if len(ds) != 2 || ds[0].Rate != ds[1].Rate {
+				t.Errorf("Discounts from mixed rule sets: %v", ds)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+func TestRuleDefLifecycle(t *testing.T) {
+	e := NewEngine()
+	d := RuleDef{Code: "MUG10", Type: PercentOff, Articles: []string{"MUG"}, Percent: money.Percent(10)}
+	if _, err := e.AddRuleDef(d); err != nil {
+		t.Fatalf("Error adding rule %v", err)
+	}
+	if _, err := e.AddRuleDef(d); err != ErrDuplicateRule {
+		t.Errorf("Add duplicated rule: %v instead of %v", err, ErrDuplicateRule)
+	}
+	c, _ := cart.NewCart(1)
+	c.AddArticle("MUG", 1)
+	d.Percent = money.Percent(50)
+	if err := e.UpdateRuleDef(d); err != nil {
+		t.Fatalf("Error updating rule %v", err)
+	}
+	promos, _ := e.ApplyRules(c, getPrices(c))
+	if n := len(promos.CartItemDiscounts); n != 1 || promos.CartItemDiscounts[0].Rate != money.Percent(50) {
+		t.Errorf("Discounts %v instead of 50%% off MUG", promos.CartItemDiscounts)
+	}
+	e.EnableRule(d.Code, false)
+	if promos, _ := e.ApplyRules(c, getPrices(c)); len(promos.CartItemDiscounts) != 0 {
+		t.Errorf("Disabled rule applied: %v", promos.CartItemDiscounts)
+	}
+	if got, _ := e.GetRuleDef(d.Code); !got.Disabled {
+		t.Errorf("Rule %v not disabled", got)
+	}
+	if err := e.DelRuleDef(d.Code); err != nil {
+		t.Fatalf("Error deleting rule %v", err)
+	}
+	if defs := e.GetRuleDefs(); len(defs) != 0 {
+		t.Errorf("Rules %v still present after delete", defs)
+	}
+	if err := e.UpdateRuleDef(d); err != ErrRuleNotFound {
+		t.Errorf("Update deleted rule: %v instead of %v", err, ErrRuleNotFound)
+	}
+}
+
+func TestApplyRulesReportsFailingRules(t *testing.T) {
+	e := NewEngine()
+	nilPromo := func(c cart.Cart, prices map[string]money.Money) []interface{} {
+		return []interface{}{nil}
+	}
+	unknownPromo := func(c cart.Cart, prices map[string]money.Money) []interface{} {
+		return []interface{}{"free shipping"}
+	}
+	panicking := func(c cart.Cart, prices map[string]money.Money) []interface{} {
+		panic("boom")
+	}
+	id1, _ := e.AddRule(&nilPromo)
+	id2, _ := e.AddRule(&unknownPromo)
+	id3, _ := e.AddRule(&panicking)
+	f := TwoForOne
+	e.AddRule(&f)
+	c, _ := cart.NewCart(1)
+	c.AddArticle("VOUCHER", 2)
+	promos, errs := e.ApplyRules(c, getPrices(c))
+	if len(errs) != 3 {
+		t.Fatalf("Errors %v instead of one for each failing rule", errs)
+	}
+	expErrs := map[int64]error{id1: ErrNilPromo, id2: ErrUnknownPromoType}
+	for id, exp := range expErrs {
+		if re, ok := errs[id].(*RuleError); !ok || re.RuleID != id || re.Err != exp {
+			t.Errorf("Rule %d error %v instead of %v", id, errs[id], exp)
+		}
+	}
+	if re, ok := errs[id3].(*RuleError); !ok || !strings.HasPrefix(re.Err.Error(), ErrRulePanic.Error()) {
+		t.Errorf("Rule %d error %v instead of %v", id3, errs[id3], ErrRulePanic)
+	}
+	if n := len(promos.CartItemDiscounts); n != 1 {
+		t.Errorf("Discounts %v instead of the one of the working rule", promos.CartItemDiscounts)
+	}
+}
+
+func TestApplyRulesWithoutErrors(t *testing.T) {
+	e := NewEngine()
+	f1 := TwoForOne
+	f2 := DiscountForThreeOrMore
+	e.AddRule(&f1)
+	e.AddRule(&f2)
+	c, _ := cart.NewCart(1)
+	c.AddArticle("MUG", 1)
+	if _, errs := e.ApplyRules(c, getPrices(c)); errs != nil {
+		t.Errorf("Errors %v applying working rules", errs)
+	}
+}
+
+func getPrices(c cart.Cart) map[string]money.Money {
+	return map[string]money.Money{
+		"VOUCHER": money.New(500, "EUR"),
+		"TSHIRT":  money.New(2000, "EUR"),
+		"MUG":     money.New(750, "EUR"),
+	}
+}
+
+func TestRulesInOtherCurrencyAreRejected(t *testing.T) {
+	e := NewEngineIn("EUR")
+	usd := money.New(100, "USD")
+	d := RuleDef{Code: "MUGOFF", Type: AmountOff, Articles: []string{"MUG"}, Amount: &usd}
+	if _, err := e.AddRuleDef(d); err == nil {
+		t.Errorf("Rule %v in USD added to an EUR engine", d)
+	}
+	if err := e.ReplaceRules([]RuleDef{d}); err == nil {
+		t.Errorf("Rules replaced with %v in USD on an EUR engine", d)
+	}
+	eur := money.New(100, "EUR")
+	d.Amount = &eur
+	if _, err := e.AddRuleDef(d); err != nil {
+		t.Fatalf("Error adding rule in EUR %v", err)
+	}
+	d.Amount = &usd
+	if err := e.UpdateRuleDef(d); err == nil {
+		t.Errorf("Rule updated to %v in USD on an EUR engine", d)
+	}
+}
+
+func TestApplyRulesReportsCurrencyMismatch(t *testing.T) {
+	e := NewEngine()
+	usdOff := func(c cart.Cart, prices map[string]money.Money) []interface{} {
+		return []interface{}{CartItemDiscount{Discount{Amount, money.New(100, "USD"), 0}, "MUG", 1}}
+	}
+	id, _ := e.AddRule(&usdOff)
+	c, _ := cart.NewCart(1)
+	c.AddArticle("MUG", 1)
+	promos, errs := e.ApplyRules(c, getPrices(c))
+	if re, ok := errs[id].(*RuleError); !ok || re.Err != ErrPromoCurrency {
+		t.Errorf("Rule %d error %v instead of %v", id, errs[id], ErrPromoCurrency)
+	}
+	if len(promos.CartItemDiscounts) != 0 {
+		t.Errorf("Discounts %v applied in another currency", promos.CartItemDiscounts)
 	}
 }
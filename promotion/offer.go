@@ -1,5 +1,7 @@
 package promotion
 
+import "shopping-cart-kata/money"
+
 // DiscountMode is the type of discount
 type DiscountMode int
 
@@ -14,14 +16,15 @@ const (
 	NewValue
 )
 
-// Discount mode and value
+// Discount mode and value: Value is used by Amount and NewValue modes, Rate by Percentage mode
 type Discount struct {
 	Mode  DiscountMode
-	Value float64
+	Value money.Money
+	Rate  money.Rate
 }
 
-// ApplyTo applies a discount to a price
-func (d Discount) ApplyTo(price float64) float64 {
+// ApplyTo applies a discount to a price rounding percentages half to even
+func (d Discount) ApplyTo(price money.Money) money.Money {
 	if d.Mode == None {
 		return price
 	}
@@ -29,9 +32,9 @@ func (d Discount) ApplyTo(price float64) float64 {
 		return d.Value
 	}
 	if d.Mode == Amount {
-		return price - d.Value
+		return price.Sub(d.Value)
 	}
-	return price * (100 - d.Value) / 100
+	return price.Sub(price.Percent(d.Rate, money.HalfEven))
 }
 
 // CartItemDiscount is the discount to be applied to part of a cart item
@@ -1,47 +1,52 @@
 package promotion
 
 import (
+	"shopping-cart-kata/money"
 	"testing"
 )
 
 func TestDiscountModeNone(t *testing.T) {
 	var d Discount
-	const p = 1000.0
+	p := money.New(100000, "EUR")
 	if res := d.ApplyTo(p); res != p {
-		t.Errorf("None mode discount resulted in price %g instead of %g", res, p)
+		t.Errorf("None mode discount resulted in price %s instead of %s", res, p)
 	}
 }
 
 func TestDiscountModeNewValue(t *testing.T) {
-	const (
-		p1 = 1000.0
-		p2 = 25.0
-	)
+	p1 := money.New(100000, "EUR")
+	p2 := money.New(2500, "EUR")
 	d := Discount{Mode: NewValue, Value: p2}
 	if res := d.ApplyTo(p1); res != p2 {
-		t.Errorf("NewValue mode discount resulted in price %g instead of %g", res, p2)
+		t.Errorf("NewValue mode discount resulted in price %s instead of %s", res, p2)
 	}
 }
 
 func TestDiscountModeAmount(t *testing.T) {
-	const (
-		p1  = 1000.0
-		p2  = 200.0
-		exp = 800.0
-	)
+	p1 := money.New(100000, "EUR")
+	p2 := money.New(20000, "EUR")
+	exp := money.New(80000, "EUR")
 	d := Discount{Mode: Amount, Value: p2}
 	if res := d.ApplyTo(p1); res != exp {
-		t.Errorf("NewValue mode discount resulted in price %g instead of %g", res, exp)
+		t.Errorf("NewValue mode discount resulted in price %s instead of %s", res, exp)
 	}
 }
 func TestDiscountModePercentage(t *testing.T) {
-	const (
-		p   = 100.0
-		pc  = 20.0
-		exp = 80.0
-	)
-	d := Discount{Mode: Percentage, Value: pc}
+	p := money.New(10000, "EUR")
+	pc := money.Percent(20)
+	exp := money.New(8000, "EUR")
+	d := Discount{Mode: Percentage, Rate: pc}
 	if res := d.ApplyTo(p); res != exp {
-		t.Errorf("NewValue mode discount resulted in price %g instead of %g", res, exp)
+		t.Errorf("NewValue mode discount resulted in price %s instead of %s", res, exp)
+	}
+}
+
+func TestDiscountModePercentageIsExact(t *testing.T) {
+	p := money.MustParse("19.99", "EUR")
+	pc, _ := money.ParseRate("15")
+	exp := money.MustParse("16.99", "EUR")
+	d := Discount{Mode: Percentage, Rate: pc}
+	if res := d.ApplyTo(p); res != exp {
+		t.Errorf("Percentage mode discount resulted in price %s instead of %s", res, exp)
 	}
 }
@@ -10,26 +10,68 @@ var ErrNilPromo = errors.New("Promo is nil")
 // ErrUnknownPromoType when the promo type is unknown
 var ErrUnknownPromoType = errors.New("Promo type is unknown")
 
+// RuleRef identifies the rule a promo comes from
+type RuleRef struct {
+	ID    int64
+	Code  string
+	Label string
+}
+
 // PromoSet is the set of promotions to be applied
+// The rule references are aligned by index with the promos they refer to
 type PromoSet struct {
-	CartItemDiscounts    []CartItemDiscount
-	CartPresents         []CartPresent
-	CartSubtotalDiscount CartSubtotalDiscount
-	ShippingDiscount     ShippingDiscount
+	CartItemDiscounts        []CartItemDiscount
+	CartItemDiscountRules    []RuleRef
+	CartPresents             []CartPresent
+	CartPresentRules         []RuleRef
+	CartSubtotalDiscount     CartSubtotalDiscount
+	CartSubtotalDiscountRule RuleRef
+	ShippingDiscount         ShippingDiscount
+	ShippingDiscountRule     RuleRef
+}
+
+// ItemDiscountRule returns the rule of the i-th cart item discount (zero if unknown)
+func (ps PromoSet) ItemDiscountRule(i int) RuleRef {
+	if i < len(ps.CartItemDiscountRules) {
+		return ps.CartItemDiscountRules[i]
+	}
+	return RuleRef{}
+}
+
+// PresentRule returns the rule of the i-th cart present (zero if unknown)
+func (ps PromoSet) PresentRule(i int) RuleRef {
+	if i < len(ps.CartPresentRules) {
+		return ps.CartPresentRules[i]
+	}
+	return RuleRef{}
+}
+
+func checkPromo(p interface{}) error {
+	switch p.(type) {
+	case nil:
+		return ErrNilPromo
+	case CartItemDiscount, CartPresent, CartSubtotalDiscount, ShippingDiscount:
+		return nil
+	}
+	return ErrUnknownPromoType
 }
 
-func (ps *PromoSet) addPromo(p interface{}) error {
+func (ps *PromoSet) addPromo(p interface{}, ref RuleRef) error {
 	switch promo := p.(type) {
 	case nil:
 		return ErrNilPromo
 	case CartItemDiscount:
 		ps.CartItemDiscounts = append(ps.CartItemDiscounts, promo)
+		ps.CartItemDiscountRules = append(ps.CartItemDiscountRules, ref)
 	case CartPresent:
 		ps.CartPresents = append(ps.CartPresents, promo)
+		ps.CartPresentRules = append(ps.CartPresentRules, ref)
 	case CartSubtotalDiscount:
 		ps.CartSubtotalDiscount = promo
+		ps.CartSubtotalDiscountRule = ref
 	case ShippingDiscount:
 		ps.ShippingDiscount = promo
+		ps.ShippingDiscountRule = ref
 	default:
 		return ErrUnknownPromoType
 	}
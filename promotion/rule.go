@@ -1,47 +1,152 @@
 package promotion
 
-import "shopping-cart-kata/cart"
+import (
+	"errors"
+	"fmt"
+	"shopping-cart-kata/cart"
+	"shopping-cart-kata/money"
+)
+
+// ErrRulePanic when a rule panics while being applied
+var ErrRulePanic = errors.New("Rule panicked")
+
+// ErrPromoCurrency when a rule discounts by an amount not in the currency of the prices
+var ErrPromoCurrency = errors.New("Promotion currency does not match the prices")
+
+// RuleError reports why a rule could not be applied
+type RuleError struct {
+	RuleID int64
+	Code   string
+	Err    error
+}
+
+func (e *RuleError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("Rule %d: %v", e.RuleID, e.Err)
+	}
+	return fmt.Sprintf("Rule %d (%s): %v", e.RuleID, e.Code, e.Err)
+}
 
 type rule struct {
-	funcPtr *func(c cart.Cart, prices map[string]float64) []interface{}
+	funcPtr *func(c cart.Cart, prices map[string]money.Money) []interface{}
+	def     *RuleDef
+}
+
+func ruleFromDef(d RuleDef) rule {
+	f := d.compile()
+	return rule{funcPtr: &f, def: &d}
+}
+
+func (r rule) priority() int {
+	if r.def == nil {
+		return 0
+	}
+	return r.def.Priority
+}
+
+func (r rule) policy() StackingPolicy {
+	if r.def == nil || r.def.Stacking == "" {
+		return Stackable
+	}
+	return r.def.Stacking
+}
+
+func (r rule) code() string {
+	if r.def == nil {
+		return ""
+	}
+	return r.def.Code
 }
 
-func (r rule) apply(c cart.Cart, prices map[string]float64) []interface{} {
+func (r rule) group() string {
+	if r.def == nil {
+		return ""
+	}
+	return r.def.Group
+}
+
+func (r rule) apply(c cart.Cart, prices map[string]money.Money) []interface{} {
 	return (*r.funcPtr)(c, prices)
 }
 
+// safeApply applies the rule checking its promos and recovering from panics
+func (r rule) safeApply(c cart.Cart, prices map[string]money.Money) (promos []interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			promos, err = nil, fmt.Errorf("%v: %v", ErrRulePanic, p)
+		}
+	}()
+	promos = r.apply(c, prices)
+	for _, p := range promos {
+		if err := checkPromo(p); err != nil {
+			return nil, err
+		}
+		if err := checkCurrency(p, c, prices); err != nil {
+			return nil, err
+		}
+	}
+	return promos, nil
+}
+
+// checkCurrency checks that a discount by amount is in the currency of the price it discounts
+func checkCurrency(p interface{}, c cart.Cart, prices map[string]money.Money) error {
+	var d Discount
+	var price money.Money
+	switch promo := p.(type) {
+	case CartItemDiscount:
+		d, price = promo.Discount, prices[promo.ItemID]
+	case CartSubtotalDiscount:
+		d = promo.Discount
+		for _, i := range c.GetItems() {
+			if p, ok := prices[i.ID]; ok {
+				price = p
+				break
+			}
+		}
+	default:
+		return nil
+	}
+	if d.Mode != Amount && d.Mode != NewValue {
+		return nil
+	}
+	if d.Value.Amount() != 0 && price.Amount() != 0 && d.Value.Currency() != price.Currency() {
+		return ErrPromoCurrency
+	}
+	return nil
+}
+
 // TwoForOne promotion
-func TwoForOne(c cart.Cart, prices map[string]float64) []interface{} {
+func TwoForOne(c cart.Cart, prices map[string]money.Money) []interface{} {
 	items := c.GetItems()
 	promos := make([]interface{}, len(items))
 	i := 0
 	for _, item := range items {
 		if item.ID == "VOUCHER" && item.Quantity >= 2 {
 			promos[i] = CartItemDiscount{
-				Discount:    Discount{Mode: Percentage, Value: 100},
+				Discount:    Discount{Mode: Percentage, Rate: money.Percent(100)},
 				ItemID:      item.ID,
 				AffectedQty: (item.Quantity / 2),
 			}
 			i++
 		}
 	}
-	return promos
+	return promos[:i]
 }
 
 // DiscountForThreeOrMore promotion
-func DiscountForThreeOrMore(c cart.Cart, prices map[string]float64) []interface{} {
+func DiscountForThreeOrMore(c cart.Cart, prices map[string]money.Money) []interface{} {
 	items := c.GetItems()
 	promos := make([]interface{}, len(items))
 	i := 0
 	for _, item := range items {
 		if item.ID == "TSHIRT" && item.Quantity >= 3 {
 			promos[i] = CartItemDiscount{
-				Discount:    Discount{Mode: NewValue, Value: 19},
+				Discount:    Discount{Mode: NewValue, Value: money.New(1900, "EUR")},
 				ItemID:      item.ID,
 				AffectedQty: item.Quantity,
 			}
 			i++
 		}
 	}
-	return promos
+	return promos[:i]
 }
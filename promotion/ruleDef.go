@@ -0,0 +1,219 @@
+package promotion
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"shopping-cart-kata/cart"
+	"shopping-cart-kata/money"
+	"strings"
+)
+
+// ErrRulesDecode when the rule definitions cannot be decoded
+var ErrRulesDecode = errors.New("Rule definitions cannot be decoded")
+
+// RuleType is the kind of a declarative rule
+type RuleType string
+
+const (
+	// BuyXGetY makes Free units out of every Buy + Free units free of charge
+	BuyXGetY RuleType = "buyXGetY"
+	// Multibuy sets a new unit price when at least MinQuantity units are bought
+	Multibuy RuleType = "multibuy"
+	// PercentOff discounts the unit price by Percent when at least MinQuantity units are bought
+	PercentOff RuleType = "percentOff"
+	// AmountOff discounts the unit price by Amount when at least MinQuantity units are bought
+	AmountOff RuleType = "amountOff"
+)
+
+// RuleDef is the declarative definition of a promotion rule targeting some articles
+type RuleDef struct {
+	Code        string         `json:"code"`
+	Label       string         `json:"label,omitempty"`
+	Type        RuleType       `json:"type"`
+	Articles    []string       `json:"articles"`
+	Buy         int            `json:"buy,omitempty"`
+	Free        int            `json:"free,omitempty"`
+	MinQuantity int            `json:"minQuantity,omitempty"`
+	UnitPrice   *money.Money   `json:"unitPrice,omitempty"`
+	Percent     money.Rate     `json:"percent,omitempty"`
+	Amount      *money.Money   `json:"amount,omitempty"`
+	Priority    int            `json:"priority,omitempty"`
+	Stacking    StackingPolicy `json:"stacking,omitempty"`
+	Group       string         `json:"group,omitempty"`
+	Disabled    bool           `json:"disabled,omitempty"`
+}
+
+// FieldError describes why a field of a rule definition is not valid
+type FieldError struct {
+	Field string `json:"field"`
+	Msg   string `json:"message"`
+}
+
+// ValidationError lists the invalid fields of a rule definition
+type ValidationError struct {
+	Code   string       `json:"code"`
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = fmt.Sprintf("%s %s", f.Field, f.Msg)
+	}
+	return fmt.Sprintf("Rule %q is not valid: %s", e.Code, strings.Join(msgs, ", "))
+}
+
+type ruleFile struct {
+	Rules []RuleDef `json:"rules"`
+}
+
+// WriteRules encodes the rule definitions as a JSON document read back by ParseRules
+func WriteRules(w io.Writer, defs []RuleDef) error {
+	if defs == nil {
+		defs = []RuleDef{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ruleFile{Rules: defs})
+}
+
+// ParseRules decodes and validates a JSON document of the form { "rules": [ ... ] }
+func ParseRules(r io.Reader) ([]RuleDef, error) {
+	var rf ruleFile
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rf); err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrRulesDecode, err)
+	}
+	codes := make(map[string]bool, len(rf.Rules))
+	for _, d := range rf.Rules {
+		if err := d.Validate(); err != nil {
+			return nil, err
+		}
+		if codes[d.Code] {
+			return nil, &ValidationError{Code: d.Code, Fields: []FieldError{{"code", "is duplicated"}}}
+		}
+		codes[d.Code] = true
+	}
+	return rf.Rules, nil
+}
+
+// Validate checks that the definition can be instantiated as a rule
+func (d RuleDef) Validate() error {
+	return d.validate("")
+}
+
+// ValidateIn checks that the definition can be instantiated as a rule pricing in the given currency
+func (d RuleDef) ValidateIn(currency string) error {
+	return d.validate(currency)
+}
+
+func (d RuleDef) validate(currency string) error {
+	var fe []FieldError
+	add := func(field, msg string) {
+		fe = append(fe, FieldError{Field: field, Msg: msg})
+	}
+	if strings.TrimSpace(d.Code) == "" {
+		add("code", "is required")
+	}
+	if len(d.Articles) == 0 {
+		add("articles", "must contain at least one article code")
+	}
+	for _, a := range d.Articles {
+		if strings.TrimSpace(a) == "" {
+			add("articles", "must not contain empty article codes")
+			break
+		}
+	}
+	if d.MinQuantity < 0 {
+		add("minQuantity", "must not be negative")
+	}
+	switch d.Stacking {
+	case "", Stackable, Exclusive:
+		if d.Group != "" {
+			add("group", fmt.Sprintf("is allowed only with %s stacking", BestOfGroup))
+		}
+	case BestOfGroup:
+		if strings.TrimSpace(d.Group) == "" {
+			add("group", fmt.Sprintf("is required with %s stacking", BestOfGroup))
+		}
+	default:
+		add("stacking", fmt.Sprintf("must be one of %s, %s, %s", Stackable, Exclusive, BestOfGroup))
+	}
+	switch d.Type {
+	case BuyXGetY:
+		if d.Buy <= 0 {
+			add("buy", "must be positive")
+		}
+		if d.Free <= 0 {
+			add("free", "must be positive")
+		}
+	case Multibuy:
+		if d.MinQuantity <= 0 {
+			add("minQuantity", "must be positive")
+		}
+		if d.UnitPrice == nil || d.UnitPrice.Amount() < 0 {
+			add("unitPrice", "is required and must not be negative")
+		} else if currency != "" && d.UnitPrice.Currency() != currency {
+			add("unitPrice", fmt.Sprintf("must be in %s", currency))
+		}
+	case PercentOff:
+		if d.Percent <= 0 || d.Percent > money.Percent(100) {
+			add("percent", "must be greater than 0 and at most 100")
+		}
+	case AmountOff:
+		if d.Amount == nil || d.Amount.Amount() <= 0 {
+			add("amount", "is required and must be positive")
+		} else if currency != "" && d.Amount.Currency() != currency {
+			add("amount", fmt.Sprintf("must be in %s", currency))
+		}
+	default:
+		add("type", fmt.Sprintf("must be one of %s, %s, %s, %s", BuyXGetY, Multibuy, PercentOff, AmountOff))
+	}
+	if len(fe) > 0 {
+		return &ValidationError{Code: d.Code, Fields: fe}
+	}
+	return nil
+}
+
+func (d RuleDef) compile() func(c cart.Cart, prices map[string]money.Money) []interface{} {
+	targets := make(map[string]bool, len(d.Articles))
+	for _, a := range d.Articles {
+		targets[a] = true
+	}
+	minQty := d.MinQuantity
+	if minQty == 0 {
+		minQty = 1
+	}
+	return func(c cart.Cart, prices map[string]money.Money) []interface{} {
+		var promos []interface{}
+		for _, item := range c.GetItems() {
+			if !targets[item.ID] {
+				continue
+			}
+			if disc, ok := d.discount(item, minQty); ok {
+				promos = append(promos, disc)
+			}
+		}
+		return promos
+	}
+}
+
+func (d RuleDef) discount(item cart.Item, minQty int) (CartItemDiscount, bool) {
+	disc := CartItemDiscount{ItemID: item.ID, AffectedQty: item.Quantity}
+	switch d.Type {
+	case BuyXGetY:
+		disc.Discount = Discount{Mode: Percentage, Rate: money.Percent(100)}
+		disc.AffectedQty = item.Quantity / (d.Buy + d.Free) * d.Free
+		return disc, disc.AffectedQty > 0 && item.Quantity >= minQty
+	case Multibuy:
+		disc.Discount = Discount{Mode: NewValue, Value: *d.UnitPrice}
+	case PercentOff:
+		disc.Discount = Discount{Mode: Percentage, Rate: d.Percent}
+	case AmountOff:
+		disc.Discount = Discount{Mode: Amount, Value: *d.Amount}
+	}
+	return disc, item.Quantity >= minQty
+}
@@ -0,0 +1,115 @@
+package promotion
+
+import (
+	"reflect"
+	"shopping-cart-kata/cart"
+	"shopping-cart-kata/money"
+	"strings"
+	"testing"
+)
+
+const readmeRules = `{
+	"rules": [
+		{ "code": "VOUCHER2X1", "type": "buyXGetY", "articles": ["VOUCHER"], "buy": 1, "free": 1 },
+		{
+			"code": "TSHIRT3PLUS",
+			"type": "multibuy",
+			"articles": ["TSHIRT"],
+			"minQuantity": 3,
+			"unitPrice": { "amount": "19.00", "currency": "EUR" }
+		}
+	]
+}`
+
+func TestParseRules(t *testing.T) {
+	defs, err := ParseRules(strings.NewReader(readmeRules))
+	if err != nil {
+		t.Fatalf("Error parsing rules %v", err)
+	}
+	if n := len(defs); n != 2 {
+		t.Fatalf("Parsed %d rules instead of 2", n)
+	}
+	if defs[1].UnitPrice == nil || *defs[1].UnitPrice != money.New(1900, "EUR") {
+		t.Errorf("Multibuy unit price %v instead of 19.00 EUR", defs[1].UnitPrice)
+	}
+}
+
+func TestWriteRulesRoundTrip(t *testing.T) {
+	defs, _ := ParseRules(strings.NewReader(readmeRules))
+	defs = append(defs, RuleDef{Code: "MUG10", Type: PercentOff, Articles: []string{"MUG"}, Percent: money.Percent(10), Disabled: true})
+	var b strings.Builder
+	if err := WriteRules(&b, defs); err != nil {
+		t.Fatalf("Error writing rules %v", err)
+	}
+	read, err := ParseRules(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatalf("Error parsing written rules %v\n%s", err, b.String())
+	}
+	if !reflect.DeepEqual(read, defs) {
+		t.Errorf("Read back %v instead of %v", read, defs)
+	}
+}
+
+func TestParseInvalidRules(t *testing.T) {
+	docs := []string{
+		`{ "rules": [ { "code": "X", "type": "unknown", "articles": ["A"] } ] }`,
+		`{ "rules": [ { "code": "X", "type": "buyXGetY", "articles": ["A"], "buy": 1 } ] }`,
+		`{ "rules": [ { "code": "X", "type": "multibuy", "articles": ["A"], "minQuantity": 3 } ] }`,
+		`{ "rules": [ { "code": "X", "type": "percentOff", "articles": ["A"], "percent": 120 } ] }`,
+		`{ "rules": [ { "code": "X", "type": "amountOff", "articles": [] } ] }`,
+		`{ "rules": [ { "type": "percentOff", "articles": ["A"], "percent": 10 } ] }`,
+		`{ "rules": [ { "code": "X", "type": "percentOff", "articles": ["A"], "percent": 10 },
+		              { "code": "X", "type": "percentOff", "articles": ["B"], "percent": 10 } ] }`,
+	}
+	for _, d := range docs {
+		_, err := ParseRules(strings.NewReader(d))
+		if _, ok := err.(*ValidationError); !ok {
+			t.Errorf("Validation error not returned for %s: %v", d, err)
+		}
+	}
+	if _, err := ParseRules(strings.NewReader(`{ "rules": [ { "unknownField": 1 } ] }`)); err == nil {
+		t.Errorf("Unknown field not reported")
+	}
+}
+
+func TestReadmeRulesFromDefinitions(t *testing.T) {
+	e := NewEngine()
+	defs, _ := ParseRules(strings.NewReader(readmeRules))
+	for _, d := range defs {
+		if _, err := e.AddRuleDef(d); err != nil {
+			t.Fatalf("Error adding rule %s: %v", d.Code, err)
+		}
+	}
+	c, _ := cart.NewCart(1)
+	c.AddArticle("VOUCHER", 3)
+	c.AddArticle("TSHIRT", 3)
+	c.AddArticle("MUG", 1)
+	promos, _ := e.ApplyRules(c, getPrices(c))
+	exp1 := CartItemDiscount{Discount: Discount{Mode: Percentage, Rate: money.Percent(100)}, ItemID: "VOUCHER", AffectedQty: 1}
+	exp2 := CartItemDiscount{Discount: Discount{Mode: NewValue, Value: money.New(1900, "EUR")}, ItemID: "TSHIRT", AffectedQty: 3}
+	if n := len(promos.CartItemDiscounts); n != 2 {
+		t.Fatalf("Discounts are %d instead of 2", n)
+	}
+	for _, d := range promos.CartItemDiscounts {
+		if d != exp1 && d != exp2 {
+			t.Errorf("Discount %v not in the expected:\n%v\n%v", d, exp1, exp2)
+		}
+	}
+}
+
+func TestPercentAndAmountOff(t *testing.T) {
+	pct := RuleDef{Code: "P", Type: PercentOff, Articles: []string{"MUG"}, MinQuantity: 2, Percent: money.Percent(10)}
+	amt := money.New(100, "EUR")
+	off := RuleDef{Code: "A", Type: AmountOff, Articles: []string{"TSHIRT"}, Amount: &amt}
+	c, _ := cart.NewCart(1)
+	c.AddArticle("MUG", 1)
+	c.AddArticle("TSHIRT", 2)
+	if promos := pct.compile()(c, getPrices(c)); len(promos) != 0 {
+		t.Errorf("Percent off applied below minimum quantity: %v", promos)
+	}
+	promos := off.compile()(c, getPrices(c))
+	exp := CartItemDiscount{Discount: Discount{Mode: Amount, Value: amt}, ItemID: "TSHIRT", AffectedQty: 2}
+	if len(promos) != 1 || promos[0] != exp {
+		t.Errorf("Amount off promos %v instead of %v", promos, exp)
+	}
+}
@@ -0,0 +1,162 @@
+package promotion
+
+import (
+	"shopping-cart-kata/cart"
+	"shopping-cart-kata/money"
+	"sort"
+)
+
+// StackingPolicy tells how the promos of a rule combine with the ones of other rules on the same target
+// Targets are a cart item, the cart subtotal and shipping: presents have no target and always stack
+type StackingPolicy string
+
+const (
+	// Stackable promos combine with the others unless the target is claimed by an exclusive promo
+	Stackable StackingPolicy = "stackable"
+	// Exclusive promos apply only to targets without other promos and prevent any further promo on them
+	Exclusive StackingPolicy = "exclusive"
+	// BestOfGroup promos compete with the ones of the same group: only the biggest saving stacks
+	BestOfGroup StackingPolicy = "bestOfGroup"
+)
+
+type claim int
+
+const (
+	unclaimed claim = iota
+	shared
+	exclusive
+)
+
+type rankedRule struct {
+	id int64
+	rule
+}
+
+func (r rankedRule) ref() RuleRef {
+	if r.def == nil {
+		return RuleRef{ID: r.id}
+	}
+	return RuleRef{ID: r.id, Code: r.def.Code, Label: r.def.Label}
+}
+
+// orderedRules returns the rules by decreasing priority and then by insertion order
+func orderedRules(rules map[int64]rule) []rankedRule {
+	ranked := make([]rankedRule, 0, len(rules))
+	for id, r := range rules {
+		ranked = append(ranked, rankedRule{id: id, rule: r})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		pi, pj := ranked[i].priority(), ranked[j].priority()
+		if pi != pj {
+			return pi > pj
+		}
+		return ranked[i].id < ranked[j].id
+	})
+	return ranked
+}
+
+type candidate struct {
+	ruleID int64
+	index  int
+	saving money.Money
+}
+
+// resolve builds the promo set applying the rules in order and resolving conflicts by stacking policy
+// Rules failing or producing invalid promos are skipped and reported
+func resolve(ranked []rankedRule, c cart.Cart, prices map[string]money.Money) (PromoSet, map[int64]error) {
+	errors := make(map[int64]error)
+	outputs := make([][]interface{}, len(ranked))
+	for i, r := range ranked {
+		promos, err := r.safeApply(c, prices)
+		if err != nil {
+			errors[r.id] = &RuleError{RuleID: r.id, Code: r.code(), Err: err}
+			continue
+		}
+		outputs[i] = promos
+	}
+	winners := bestOfGroups(ranked, outputs, c, prices)
+	var promoSet PromoSet
+	claims := make(map[string]claim)
+	for i, r := range ranked {
+		policy := r.policy()
+		for j, p := range outputs[i] {
+			key := targetKey(p)
+			if w := winners[r.group()+"|"+key]; policy == BestOfGroup && (w.ruleID != r.id || w.index != j) {
+				continue
+			}
+			if !claimTarget(claims, key, policy) {
+				continue
+			}
+			promoSet.addPromo(p, r.ref())
+		}
+	}
+	return promoSet, errors
+}
+
+// bestOfGroups selects for each group and target the promo with the biggest saving (earlier rules win ties)
+func bestOfGroups(ranked []rankedRule, outputs [][]interface{}, c cart.Cart, prices map[string]money.Money) map[string]candidate {
+	winners := make(map[string]candidate)
+	for i, r := range ranked {
+		if r.policy() != BestOfGroup {
+			continue
+		}
+		for j, p := range outputs[i] {
+			k := r.group() + "|" + targetKey(p)
+			s := saving(p, c, prices)
+			if w, ok := winners[k]; !ok || s.Cmp(w.saving) > 0 {
+				winners[k] = candidate{ruleID: r.id, index: j, saving: s}
+			}
+		}
+	}
+	return winners
+}
+
+func claimTarget(claims map[string]claim, key string, policy StackingPolicy) bool {
+	if key == "" {
+		return true
+	}
+	singleSlot := key == "subtotal" || key == "shipping"
+	switch {
+	case claims[key] == exclusive:
+		return false
+	case policy == Exclusive && claims[key] != unclaimed:
+		return false
+	case singleSlot && claims[key] != unclaimed:
+		return false
+	}
+	if policy == Exclusive {
+		claims[key] = exclusive
+	} else {
+		claims[key] = shared
+	}
+	return true
+}
+
+func targetKey(p interface{}) string {
+	switch promo := p.(type) {
+	case CartItemDiscount:
+		return "item:" + promo.ItemID
+	case CartSubtotalDiscount:
+		return "subtotal"
+	case ShippingDiscount:
+		return "shipping"
+	}
+	return ""
+}
+
+func saving(p interface{}, c cart.Cart, prices map[string]money.Money) money.Money {
+	switch promo := p.(type) {
+	case CartItemDiscount:
+		price := prices[promo.ItemID]
+		return price.Sub(promo.Discount.ApplyTo(price)).Mul(int64(promo.AffectedQty))
+	case CartPresent:
+		return prices[promo.ArtCode].Mul(int64(promo.Quantity))
+	case CartSubtotalDiscount:
+		var subtotal money.Money
+		for _, i := range c.GetItems() {
+			subtotal = subtotal.Add(prices[i.ID].Mul(int64(i.Quantity)))
+		}
+		return subtotal.Sub(promo.Discount.ApplyTo(subtotal))
+	}
+	return money.Money{}
+}
@@ -0,0 +1,108 @@
+package promotion
+
+import (
+	"reflect"
+	"shopping-cart-kata/cart"
+	"shopping-cart-kata/money"
+	"testing"
+)
+
+func TestRulesAppliedByPriority(t *testing.T) {
+	e := NewEngine()
+	e.AddRuleDef(RuleDef{Code: "LOW", Type: PercentOff, Articles: []string{"MUG"}, Percent: money.Percent(10)})
+	e.AddRuleDef(RuleDef{Code: "HIGH", Type: PercentOff, Articles: []string{"TSHIRT"}, Percent: money.Percent(10), Priority: 10})
+	c, _ := cart.NewCart(1)
+	c.AddArticle("MUG", 1)
+	c.AddArticle("TSHIRT", 1)
+	first, _ := e.ApplyRules(c, getPrices(c))
+	if n := len(first.CartItemDiscounts); n != 2 || first.CartItemDiscounts[0].ItemID != "TSHIRT" {
+		t.Fatalf("Discounts %v not ordered by priority", first.CartItemDiscounts)
+	}
+	for i := 0; i < 50; i++ {
+		if ps, _ := e.ApplyRules(c, getPrices(c)); !reflect.DeepEqual(ps, first) {
+			t.Fatalf("Promo set %v differs from %v", ps, first)
+		}
+	}
+}
+
+func TestExclusiveRules(t *testing.T) {
+	excl := RuleDef{Code: "EXCL", Type: PercentOff, Articles: []string{"MUG"}, Percent: money.Percent(50), Stacking: Exclusive}
+	stack := RuleDef{Code: "STACK", Type: PercentOff, Articles: []string{"MUG", "TSHIRT"}, Percent: money.Percent(10)}
+	c, _ := cart.NewCart(1)
+	c.AddArticle("MUG", 1)
+	c.AddArticle("TSHIRT", 1)
+
+	excl.Priority, stack.Priority = 2, 1
+	e := NewEngine()
+	e.AddRuleDef(stack)
+	e.AddRuleDef(excl)
+	ps, _ := e.ApplyRules(c, getPrices(c))
+	exp := []CartItemDiscount{
+		{Discount: Discount{Mode: Percentage, Rate: money.Percent(50)}, ItemID: "MUG", AffectedQty: 1},
+		{Discount: Discount{Mode: Percentage, Rate: money.Percent(10)}, ItemID: "TSHIRT", AffectedQty: 1},
+	}
+	if !reflect.DeepEqual(ps.CartItemDiscounts, exp) {
+		t.Errorf("Exclusive first: discounts %v instead of %v", ps.CartItemDiscounts, exp)
+	}
+
+	excl.Priority, stack.Priority = 1, 2
+	e = NewEngine()
+	e.AddRuleDef(stack)
+	e.AddRuleDef(excl)
+	ps, _ = e.ApplyRules(c, getPrices(c))
+	exp = []CartItemDiscount{
+		{Discount: Discount{Mode: Percentage, Rate: money.Percent(10)}, ItemID: "MUG", AffectedQty: 1},
+		{Discount: Discount{Mode: Percentage, Rate: money.Percent(10)}, ItemID: "TSHIRT", AffectedQty: 1},
+	}
+	if !reflect.DeepEqual(ps.CartItemDiscounts, exp) {
+		t.Errorf("Stackable first: discounts %v instead of %v", ps.CartItemDiscounts, exp)
+	}
+}
+
+func TestBestOfGroupRules(t *testing.T) {
+	five := money.MustParse("5.00", "EUR")
+	e := NewEngine()
+	e.AddRuleDef(RuleDef{Code: "PCT", Type: PercentOff, Articles: []string{"TSHIRT"}, Percent: money.Percent(10), Stacking: BestOfGroup, Group: "tshirt"})
+	e.AddRuleDef(RuleDef{Code: "AMT", Type: AmountOff, Articles: []string{"TSHIRT"}, Amount: &five, Stacking: BestOfGroup, Group: "tshirt"})
+	e.AddRuleDef(RuleDef{Code: "ALL", Type: PercentOff, Articles: []string{"TSHIRT"}, Percent: money.Percent(1)})
+	c, _ := cart.NewCart(1)
+	c.AddArticle("TSHIRT", 2)
+	ps, _ := e.ApplyRules(c, getPrices(c))
+	exp := []CartItemDiscount{
+		{Discount: Discount{Mode: Amount, Value: five}, ItemID: "TSHIRT", AffectedQty: 2},
+		{Discount: Discount{Mode: Percentage, Rate: money.Percent(1)}, ItemID: "TSHIRT", AffectedQty: 2},
+	}
+	if !reflect.DeepEqual(ps.CartItemDiscounts, exp) {
+		t.Errorf("Discounts %v instead of %v", ps.CartItemDiscounts, exp)
+	}
+}
+
+func TestSubtotalDiscountIsNotOverwritten(t *testing.T) {
+	e := NewEngine()
+	ten := func(c cart.Cart, prices map[string]money.Money) []interface{} {
+		return []interface{}{CartSubtotalDiscount{Discount{Mode: Percentage, Rate: money.Percent(10)}}}
+	}
+	twenty := func(c cart.Cart, prices map[string]money.Money) []interface{} {
+		return []interface{}{CartSubtotalDiscount{Discount{Mode: Percentage, Rate: money.Percent(20)}}}
+	}
+	e.AddRule(&ten)
+	e.AddRule(&twenty)
+	c, _ := cart.NewCart(1)
+	ps, _ := e.ApplyRules(c, getPrices(c))
+	if ps.CartSubtotalDiscount.Rate != money.Percent(10) {
+		t.Errorf("Subtotal discount %v instead of the first rule one", ps.CartSubtotalDiscount)
+	}
+}
+
+func TestStackingValidation(t *testing.T) {
+	defs := []RuleDef{
+		{Code: "X", Type: PercentOff, Articles: []string{"A"}, Percent: 10, Stacking: BestOfGroup},
+		{Code: "X", Type: PercentOff, Articles: []string{"A"}, Percent: 10, Stacking: Exclusive, Group: "g"},
+		{Code: "X", Type: PercentOff, Articles: []string{"A"}, Percent: 10, Stacking: "sometimes"},
+	}
+	for _, d := range defs {
+		if _, ok := d.Validate().(*ValidationError); !ok {
+			t.Errorf("Validation error not returned for %+v", d)
+		}
+	}
+}
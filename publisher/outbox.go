@@ -0,0 +1,140 @@
+package publisher
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrCorruptedOutbox when the outbox contains an invalid record that is not the last one
+var ErrCorruptedOutbox = errors.New("Event outbox is corrupted")
+
+// compactEvery is the number of outbox records after which the outbox is rewritten with the pending events only
+var compactEvery = 1000
+
+// outboxRecord is either an event with its sequence number or the sequence number of the next event a sink has to acknowledge
+type outboxRecord struct {
+	Seq   int64  `json:"seq,omitempty"`
+	Event *Event `json:"event,omitempty"`
+	Sink  string `json:"sink,omitempty"`
+	Next  int64  `json:"next,omitempty"`
+}
+
+// outbox is an append-only file of JSON records, one per line, keeping the events until every sink acknowledged them
+// A failed append is undone truncating the outbox to its previous size
+type outbox struct {
+	path    string
+	f       *os.File
+	size    int64
+	records int
+}
+
+// openOutbox returns the records of the outbox at path discarding a record torn by a crash at its end
+func openOutbox(path string) (*outbox, []outboxRecord, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	recs, valid, err := readRecords(f)
+	if err == nil {
+		err = f.Truncate(valid)
+	}
+	if err == nil {
+		_, err = f.Seek(valid, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return &outbox{path: path, f: f, size: valid, records: len(recs)}, recs, nil
+}
+
+// readRecords returns the valid records with their length
+func readRecords(f io.Reader) ([]outboxRecord, int64, error) {
+	var recs []outboxRecord
+	var valid int64
+	rd := bufio.NewReader(f)
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return recs, valid, nil
+		}
+		if err != nil && err != io.EOF {
+			return recs, valid, err
+		}
+		var r outboxRecord
+		if line[len(line)-1] != '\n' || json.Unmarshal(line, &r) != nil {
+			if _, err := rd.Peek(1); err != io.EOF {
+				return recs, valid, fmt.Errorf("%v: invalid record at offset %d", ErrCorruptedOutbox, valid)
+			}
+			return recs, valid, nil
+		}
+		recs = append(recs, r)
+		valid += int64(len(line))
+	}
+}
+
+// append writes the record flushing it to disk if durable is true
+func (o *outbox) append(r outboxRecord, durable bool) error {
+	j, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	n, err := o.f.Write(append(j, '\n'))
+	if err == nil && durable {
+		err = o.f.Sync()
+	}
+	if err != nil {
+		if n > 0 {
+			o.f.Truncate(o.size)
+			o.f.Seek(o.size, io.SeekStart)
+		}
+		return err
+	}
+	o.size += int64(n)
+	o.records++
+	return nil
+}
+
+// rewrite atomically replaces the outbox with the given records
+func (o *outbox) rewrite(recs []outboxRecord) error {
+	tmp := o.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	var size int64
+	for _, r := range recs {
+		j, err := json.Marshal(r)
+		if err == nil {
+			_, err = w.Write(append(j, '\n'))
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+		size += int64(len(j) + 1)
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, o.path)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	o.f.Close()
+	o.f, o.size, o.records = f, size, len(recs)
+	return nil
+}
+
+func (o *outbox) close() error {
+	return o.f.Close()
+}
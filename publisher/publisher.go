@@ -0,0 +1,460 @@
+package publisher
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrClosed when events are published after the publisher is closed
+var ErrClosed = errors.New("Publisher is closed")
+
+// ErrPendingEvents when the publisher is closed before delivering all the events
+var ErrPendingEvents = errors.New("Events not delivered before closing")
+
+// Type is the kind of an integration event
+type Type string
+
+const (
+	// CartCreated when a cart is created
+	CartCreated Type = "cart.created"
+	// ArticleAdded when an article is added to a cart
+	ArticleAdded Type = "cart.articleAdded"
+	// QuantityChanged when the quantity of an article in a cart is changed
+	QuantityChanged Type = "cart.quantityChanged"
+	// ArticleRemoved when an article is removed from a cart
+	ArticleRemoved Type = "cart.articleRemoved"
+	// CartDeleted when a cart is deleted
+	CartDeleted Type = "cart.deleted"
+	// CartExpired when a cart is removed because it was not modified within its TTL
+	CartExpired Type = "cart.expired"
+	// PromotionApplied when a change of a cart makes a promotion rule apply to it
+	PromotionApplied Type = "cart.promotionApplied"
+)
+
+// Types are all the kinds of integration events
+var Types = []Type{CartCreated, ArticleAdded, QuantityChanged, ArticleRemoved, CartDeleted, CartExpired, PromotionApplied}
+
+// Event is an integration event about a cart
+// Events are delivered at least once: retries and restarts can deliver an event again, consumers recognize duplicates by ID
+type Event struct {
+	ID         string    `json:"id"`
+	Type       Type      `json:"type"`
+	CartID     int64     `json:"cartId"`
+	ArticleID  string    `json:"articleId,omitempty"`
+	Quantity   int       `json:"quantity,omitempty"`
+	RuleCode   string    `json:"ruleCode,omitempty"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+// Sink delivers events to a destination
+type Sink interface {
+	Deliver(e Event) error
+}
+
+// Filter is implemented by the sinks delivering only some types of events
+type Filter interface {
+	Wants(t Type) bool
+}
+
+// Named is implemented by the sinks with a name identifying them in the outbox, so that they resume from their last acknowledged
+// event also when other sinks are added or removed: the others are identified by their position
+type Named interface {
+	Name() string
+}
+
+// Publisher publishes events to sinks
+// Wants tells if any sink delivers the events of a type so that costly events are computed only when needed
+type Publisher interface {
+	Publish(e Event) error
+	Wants(t Type) bool
+	Close() error
+}
+
+// Options tunes the delivery of the events
+// Failed deliveries are retried until the sink acknowledges the event waiting from RetryMin doubling up to RetryMax
+// Close waits at most CloseTimeout for the pending events
+// DeliveryFailed, if set, is called with every failed delivery attempt to a sink
+// DeadLetter, if set, is called with the events a sink will never receive and the reason: the events still pending
+// when a publisher without outbox is closed
+type Options struct {
+	RetryMin       time.Duration
+	RetryMax       time.Duration
+	CloseTimeout   time.Duration
+	DeliveryFailed func(sink string, e Event, err error)
+	DeadLetter     func(sink string, e Event, err error)
+}
+
+// DefaultOptions are the options used by NewPublisher when not given
+var DefaultOptions = Options{RetryMin: 100 * time.Millisecond, RetryMax: 30 * time.Second, CloseTimeout: 5 * time.Second}
+
+type dummyPublisher struct{}
+
+func (p dummyPublisher) Publish(e Event) error {
+	return nil
+}
+
+func (p dummyPublisher) Wants(t Type) bool {
+	return false
+}
+
+func (p dummyPublisher) Close() error {
+	return nil
+}
+
+// DummyPublisher is the implementation of the null object pattern
+var DummyPublisher Publisher = dummyPublisher{}
+
+type publisher struct {
+	sync.Mutex
+	opts    Options
+	workers []*worker
+	// pending are the events from sequence number first on, not yet acknowledged by every sink
+	pending []Event
+	first   int64
+	outbox  *outbox
+	// dirty when the outbox misses a pending event because appending it failed
+	dirty  bool
+	closed bool
+}
+
+// worker delivers in order the events of a sink from the sequence number next on, retrying each one until acknowledged
+type worker struct {
+	p       *publisher
+	sink    Sink
+	name    string
+	next    int64
+	notify  chan struct{}
+	closing chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewPublisher creates a publisher delivering the events asynchronously to every sink, keeping them in memory until delivered
+// A sink failing does not delay the others or Publish: the events wait for it however long it fails
+func NewPublisher(opts Options, sinks ...Sink) Publisher {
+	p := newPublisher(opts, sinks)
+	p.start()
+	return p
+}
+
+// NewDurablePublisher creates a publisher keeping the events in the outbox at path until every sink acknowledged them
+// The events pending when the publisher was closed or crashed are delivered again, a sink new to the outbox gets only
+// the events published from then on
+func NewDurablePublisher(path string, opts Options, sinks ...Sink) (Publisher, error) {
+	o, recs, err := openOutbox(path)
+	if err != nil {
+		return nil, err
+	}
+	p := newPublisher(opts, sinks)
+	p.outbox = o
+	p.recover(recs)
+	if err := p.compact(); err != nil {
+		o.close()
+		return nil, err
+	}
+	p.start()
+	return p, nil
+}
+
+func newPublisher(opts Options, sinks []Sink) *publisher {
+	if opts.RetryMin <= 0 {
+		opts.RetryMin = DefaultOptions.RetryMin
+	}
+	if opts.RetryMax < opts.RetryMin {
+		opts.RetryMax = opts.RetryMin
+	}
+	if opts.CloseTimeout <= 0 {
+		opts.CloseTimeout = DefaultOptions.CloseTimeout
+	}
+	p := &publisher{opts: opts, first: 1}
+	names := make(map[string]bool)
+	for i, s := range sinks {
+		name := fmt.Sprintf("sink%d", i)
+		if n, ok := s.(Named); ok && !names[n.Name()] {
+			name = n.Name()
+		}
+		names[name] = true
+		p.workers = append(p.workers, &worker{
+			p:       p,
+			sink:    s,
+			name:    name,
+			next:    1,
+			notify:  make(chan struct{}, 1),
+			closing: make(chan struct{}),
+			stop:    make(chan struct{}),
+			done:    make(chan struct{}),
+		})
+	}
+	return p
+}
+
+// recover restores the pending events and where every sink left them
+func (p *publisher) recover(recs []outboxRecord) {
+	events := make(map[int64]Event)
+	acked := make(map[string]int64)
+	last := int64(0)
+	for _, r := range recs {
+		if r.Event != nil {
+			events[r.Seq] = *r.Event
+			if r.Seq > last {
+				last = r.Seq
+			}
+		} else if r.Sink != "" {
+			acked[r.Sink] = r.Next
+		}
+	}
+	first := last + 1
+	for _, w := range p.workers {
+		w.next = last + 1
+		if next, ok := acked[w.name]; ok && next <= last {
+			w.next = next
+		}
+		if w.next < first {
+			first = w.next
+		}
+	}
+	// the events are numbered again from 1 skipping the ones never written
+	seqs := make(map[int64]int64)
+	for seq := first; seq <= last; seq++ {
+		seqs[seq] = p.first + int64(len(p.pending))
+		if e, ok := events[seq]; ok {
+			p.pending = append(p.pending, e)
+		}
+	}
+	for _, w := range p.workers {
+		if next, ok := seqs[w.next]; ok {
+			w.next = next
+		} else {
+			w.next = p.first + int64(len(p.pending))
+		}
+	}
+}
+
+func (p *publisher) start() {
+	for _, w := range p.workers {
+		w.notify <- struct{}{}
+		go w.run()
+	}
+}
+
+// Publish queues the event for every sink assigning its ID and time if missing
+// It never blocks on the sinks: with an outbox it returns once the event is on disk, an error means that the event
+// is delivered unless the publisher stops before it is written
+func (p *publisher) Publish(e Event) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	if len(p.workers) == 0 {
+		return nil
+	}
+	if e.ID == "" {
+		e.ID = newID()
+	}
+	if e.OccurredAt.IsZero() {
+		e.OccurredAt = time.Now()
+	}
+	seq := p.first + int64(len(p.pending))
+	p.pending = append(p.pending, e)
+	var err error
+	if p.outbox != nil && !p.dirty {
+		err = p.outbox.append(outboxRecord{Seq: seq, Event: &e}, true)
+	}
+	if p.outbox != nil && (p.dirty || err != nil) {
+		err = p.compact()
+		p.dirty = err != nil
+	}
+	for _, w := range p.workers {
+		select {
+		case w.notify <- struct{}{}:
+		default:
+		}
+	}
+	return err
+}
+
+// Wants tells if any sink delivers the events of the type: sinks not implementing Filter deliver them all
+func (p *publisher) Wants(t Type) bool {
+	for _, w := range p.workers {
+		if f, ok := w.sink.(Filter); !ok || f.Wants(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close stops accepting events and waits for the pending ones to be delivered
+// Without an outbox the events still pending are lost, reported to DeadLetter
+func (p *publisher) Close() error {
+	p.Lock()
+	if p.closed {
+		p.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.Unlock()
+	for _, w := range p.workers {
+		close(w.closing)
+	}
+	deadline := time.NewTimer(p.opts.CloseTimeout)
+	defer deadline.Stop()
+	var err error
+wait:
+	for _, w := range p.workers {
+		select {
+		case <-w.done:
+		case <-deadline.C:
+			err = ErrPendingEvents
+			break wait
+		}
+	}
+	for _, w := range p.workers {
+		close(w.stop)
+	}
+	p.Lock()
+	defer p.Unlock()
+	if p.outbox == nil {
+		for _, w := range p.workers {
+			for seq := w.next; seq < p.first+int64(len(p.pending)); seq++ {
+				p.deadLetter(w.name, p.pending[seq-p.first], ErrPendingEvents)
+			}
+		}
+		return err
+	}
+	if closeErr := p.outbox.close(); err == nil {
+		err = closeErr
+	}
+	p.outbox = nil
+	return err
+}
+
+// next returns the next event the worker has to deliver if any
+func (p *publisher) next(w *worker) (Event, bool) {
+	p.Lock()
+	defer p.Unlock()
+	if w.next >= p.first+int64(len(p.pending)) {
+		return Event{}, false
+	}
+	return p.pending[w.next-p.first], true
+}
+
+// ack records that the worker delivered its next event dropping the events every sink acknowledged
+func (p *publisher) ack(w *worker) {
+	p.Lock()
+	defer p.Unlock()
+	w.next++
+	if p.outbox == nil {
+		p.trim()
+		return
+	}
+	p.outbox.append(outboxRecord{Sink: w.name, Next: w.next}, false)
+	p.trim()
+	if p.dirty || p.outbox.records >= compactEvery {
+		p.dirty = p.compact() != nil
+	}
+}
+
+// trim drops the events acknowledged by every sink
+func (p *publisher) trim() {
+	min := p.first + int64(len(p.pending))
+	for _, w := range p.workers {
+		if w.next < min {
+			min = w.next
+		}
+	}
+	if n := min - p.first; n > 0 {
+		p.pending = p.pending[n:]
+		p.first = min
+	}
+}
+
+// compact rewrites the outbox with the pending events and where every sink is
+// A failed compaction leaves the outbox as it was
+func (p *publisher) compact() error {
+	recs := make([]outboxRecord, 0, len(p.pending)+len(p.workers))
+	for i := range p.pending {
+		recs = append(recs, outboxRecord{Seq: p.first + int64(i), Event: &p.pending[i]})
+	}
+	for _, w := range p.workers {
+		recs = append(recs, outboxRecord{Sink: w.name, Next: w.next})
+	}
+	return p.outbox.rewrite(recs)
+}
+
+func (p *publisher) deadLetter(sink string, e Event, err error) {
+	if p.opts.DeadLetter != nil {
+		p.opts.DeadLetter(sink, e, err)
+	}
+}
+
+// run delivers the pending events as they are published until closing, then the ones still pending
+func (w *worker) run() {
+	defer close(w.done)
+	for {
+		select {
+		case <-w.notify:
+		case <-w.closing:
+		}
+		for {
+			e, ok := w.p.next(w)
+			if !ok {
+				break
+			}
+			if !w.deliver(e) {
+				return
+			}
+			w.p.ack(w)
+		}
+		select {
+		case <-w.closing:
+			return
+		default:
+		}
+	}
+}
+
+// deliver retries until the event is delivered or the worker is stopped
+func (w *worker) deliver(e Event) bool {
+	wait := w.p.opts.RetryMin
+	for {
+		err := w.sink.Deliver(e)
+		if err == nil {
+			return true
+		}
+		if w.p.opts.DeliveryFailed != nil {
+			w.p.opts.DeliveryFailed(w.name, e, err)
+		}
+		if !sleep(wait, w.stop) {
+			return false
+		}
+		wait *= 2
+		if wait > w.p.opts.RetryMax {
+			wait = w.p.opts.RetryMax
+		}
+	}
+}
+
+// sleep waits for the duration returning false if stopped before
+func sleep(d time.Duration, stop <-chan struct{}) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format(time.RFC3339Nano)
+	}
+	return hex.EncodeToString(b)
+}
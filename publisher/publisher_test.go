@@ -0,0 +1,336 @@
+package publisher
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var fastRetry = Options{RetryMin: time.Millisecond, RetryMax: 5 * time.Millisecond, CloseTimeout: time.Second}
+
+type failingSink struct {
+	sync.Mutex
+	failures  int
+	delivered []Event
+}
+
+func (s *failingSink) Deliver(e Event) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("Unavailable")
+	}
+	s.delivered = append(s.delivered, e)
+	return nil
+}
+
+func (s *failingSink) count() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.delivered)
+}
+
+type blockedSink struct {
+	release chan struct{}
+}
+
+func (s blockedSink) Deliver(e Event) error {
+	<-s.release
+	return nil
+}
+
+func TestRetryUntilDelivered(t *testing.T) {
+	s := &failingSink{failures: 5}
+	p := NewPublisher(fastRetry, s)
+	for id := int64(1); id <= 3; id++ {
+		if err := p.Publish(Event{Type: CartCreated, CartID: id}); err != nil {
+			t.Fatalf("Error publishing %v", err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Error closing %v", err)
+	}
+	if len(s.delivered) != 3 {
+		t.Fatalf("Delivered %v instead of 3 events", s.delivered)
+	}
+	for i, e := range s.delivered {
+		if e.CartID != int64(i+1) || e.ID == "" || e.OccurredAt.IsZero() {
+			t.Errorf("Delivered event %v out of order or without ID and time", e)
+		}
+	}
+	if err := p.Publish(Event{Type: CartCreated}); err != ErrClosed {
+		t.Errorf("Publish after close: %v instead of %v", err, ErrClosed)
+	}
+}
+
+func TestSinksDoNotDelayEachOther(t *testing.T) {
+	blocked := blockedSink{release: make(chan struct{})}
+	defer close(blocked.release)
+	s := &failingSink{}
+	opts := fastRetry
+	opts.CloseTimeout = 10 * time.Millisecond
+	p := NewPublisher(opts, blocked, s)
+	p.Publish(Event{Type: CartCreated, CartID: 1})
+	for i := 0; i < 100 && s.count() == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if s.count() != 1 {
+		t.Errorf("Event not delivered to a sink because another one is blocked")
+	}
+	if err := p.Close(); err != ErrPendingEvents {
+		t.Errorf("Close with a blocked sink: %v instead of %v", err, ErrPendingEvents)
+	}
+}
+
+func TestSlowSinkKeepsEveryEvent(t *testing.T) {
+	blocked := blockedSink{release: make(chan struct{})}
+	s := &failingSink{}
+	p := NewPublisher(fastRetry, blocked, s)
+	for i := int64(1); i <= 5000; i++ {
+		if err := p.Publish(Event{Type: CartCreated, CartID: i}); err != nil {
+			t.Fatalf("Error publishing to a slow sink %v", err)
+		}
+	}
+	close(blocked.release)
+	if err := p.Close(); err != nil {
+		t.Fatalf("Error closing %v", err)
+	}
+	if s.count() != 5000 {
+		t.Errorf("Delivered %d instead of 5000 events", s.count())
+	}
+}
+
+func TestCloseDeadLettersPendingEvents(t *testing.T) {
+	blocked := blockedSink{release: make(chan struct{})}
+	defer close(blocked.release)
+	var dropped []int64
+	opts := fastRetry
+	opts.CloseTimeout = 10 * time.Millisecond
+	opts.DeadLetter = func(sink string, e Event, err error) {
+		if err == ErrPendingEvents {
+			dropped = append(dropped, e.CartID)
+		}
+	}
+	p := NewPublisher(opts, blocked)
+	p.Publish(Event{Type: CartCreated, CartID: 1})
+	p.Publish(Event{Type: CartCreated, CartID: 2})
+	if err := p.Close(); err != ErrPendingEvents {
+		t.Errorf("Close with a blocked sink: %v instead of %v", err, ErrPendingEvents)
+	}
+	if len(dropped) != 2 || dropped[0] != 1 || dropped[1] != 2 {
+		t.Errorf("Dead letters %v instead of the pending events", dropped)
+	}
+}
+
+func TestPublishRacingCloseLosesNoEvent(t *testing.T) {
+	s := &failingSink{failures: 10}
+	var mu sync.Mutex
+	dropped := 0
+	opts := fastRetry
+	opts.CloseTimeout = time.Millisecond
+	opts.DeadLetter = func(sink string, e Event, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		dropped++
+	}
+	p := NewPublisher(opts, s)
+	var accepted int64
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if p.Publish(Event{Type: CartCreated}) == nil {
+					mu.Lock()
+					accepted++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	p.Close()
+	wg.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if int64(s.count()+dropped) < accepted {
+		t.Errorf("%d events accepted but %d delivered and %d dead letters", accepted, s.count(), dropped)
+	}
+}
+
+type namedSink struct {
+	failingSink
+	name string
+}
+
+func (s *namedSink) Name() string {
+	return s.name
+}
+
+type blockedNamedSink struct {
+	blockedSink
+	name string
+}
+
+func (s blockedNamedSink) Name() string {
+	return s.name
+}
+
+func TestDurablePublisherRedeliversPendingEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatalf("Error creating the dir %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.outbox")
+	delivering := &namedSink{name: "delivering"}
+	blocked := blockedNamedSink{blockedSink{release: make(chan struct{})}, "blocked"}
+	defer close(blocked.release)
+	opts := fastRetry
+	opts.CloseTimeout = 10 * time.Millisecond
+	p, err := NewDurablePublisher(path, opts, blocked, delivering)
+	if err != nil {
+		t.Fatalf("Error opening the publisher %v", err)
+	}
+	p.Publish(Event{Type: CartCreated, CartID: 1})
+	p.Publish(Event{Type: CartCreated, CartID: 2})
+	for i := 0; i < 100 && delivering.count() < 2; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if err := p.Close(); err != ErrPendingEvents {
+		t.Fatalf("Close with a blocked sink: %v instead of %v", err, ErrPendingEvents)
+	}
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"seq":3,"event":{"id":`)
+	f.Close()
+
+	recovered := &namedSink{name: "blocked"}
+	again := &namedSink{name: "delivering"}
+	added := &namedSink{name: "added"}
+	p, err = NewDurablePublisher(path, fastRetry, recovered, again, added)
+	if err != nil {
+		t.Fatalf("Error reopening the publisher %v", err)
+	}
+	p.Publish(Event{Type: CartDeleted, CartID: 3})
+	if err := p.Close(); err != nil {
+		t.Fatalf("Error closing %v", err)
+	}
+	if len(recovered.delivered) != 3 || recovered.delivered[0].ID != delivering.delivered[0].ID ||
+		recovered.delivered[1].ID != delivering.delivered[1].ID || recovered.delivered[2].CartID != 3 {
+		t.Errorf("Delivered %v after the restart instead of %v and the new event", recovered.delivered, delivering.delivered)
+	}
+	if len(again.delivered) != 1 || again.delivered[0].CartID != 3 {
+		t.Errorf("Delivered %v after the restart instead of the new event only", again.delivered)
+	}
+	if len(added.delivered) != 1 || added.delivered[0].CartID != 3 {
+		t.Errorf("Delivered %v to a new sink instead of the new event only", added.delivered)
+	}
+}
+
+func TestOutboxIsCompacted(t *testing.T) {
+	defer func(n int) { compactEvery = n }(compactEvery)
+	compactEvery = 4
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatalf("Error creating the dir %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.outbox")
+	s := &namedSink{name: "sink"}
+	p, err := NewDurablePublisher(path, fastRetry, s)
+	if err != nil {
+		t.Fatalf("Error opening the publisher %v", err)
+	}
+	for i := int64(1); i <= 20; i++ {
+		p.Publish(Event{Type: CartCreated, CartID: i})
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Error closing %v", err)
+	}
+	f, _ := os.Open(path)
+	recs, _, err := readRecords(f)
+	f.Close()
+	if err != nil || len(recs) > compactEvery+1 {
+		t.Errorf("Outbox has %d records instead of at most %d", len(recs), compactEvery+1)
+	}
+	s = &namedSink{name: "sink"}
+	p, _ = NewDurablePublisher(path, fastRetry, s)
+	p.Close()
+	if s.count() != 0 {
+		t.Errorf("Delivered again %v acknowledged events", s.delivered)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var mu sync.Mutex
+	var received []Event
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil || r.Header.Get("X-Event-Id") != e.ID {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received = append(received, e)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	p := NewPublisher(fastRetry, NewWebhookSink(srv.URL, time.Second))
+	p.Publish(Event{Type: ArticleAdded, CartID: 1, ArticleID: "MUG", Quantity: 2})
+	p.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 1 || received[0].ArticleID != "MUG" || received[0].Quantity != 2 {
+		t.Errorf("Webhook received %v after %d calls instead of the event retried after a failure", received, calls)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatalf("Error creating the dir %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.jsonl")
+	for _, id := range []int64{1, 2} {
+		fs, err := NewFileSink(path)
+		if err != nil {
+			t.Fatalf("Error opening the file sink %v", err)
+		}
+		p := NewPublisher(fastRetry, fs)
+		p.Publish(Event{Type: CartDeleted, CartID: id})
+		p.Close()
+		fs.Close()
+	}
+	f, _ := os.Open(path)
+	defer f.Close()
+	var ids []int64
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("Invalid JSON line %q", sc.Text())
+		}
+		ids = append(ids, e.CartID)
+	}
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("File contains events of carts %v instead of 1 and 2", ids)
+	}
+}
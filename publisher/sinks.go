@@ -0,0 +1,119 @@
+package publisher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+type writerSink struct {
+	sync.Mutex
+	w io.Writer
+}
+
+// NewWriterSink creates a sink writing the events to w as JSON lines
+func NewWriterSink(w io.Writer) Sink {
+	return &writerSink{w: w}
+}
+
+// Name identifies the sink in the outbox
+func (s *writerSink) Name() string {
+	return "writer"
+}
+
+// Deliver writes the event as a JSON line
+func (s *writerSink) Deliver(e Event) error {
+	j, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	_, err = s.w.Write(append(j, '\n'))
+	return err
+}
+
+// FileSink is a sink appending the events to a file
+type FileSink interface {
+	Sink
+	Close() error
+}
+
+type fileSink struct {
+	writerSink
+	f    *os.File
+	path string
+}
+
+// NewFileSink creates a sink appending the events to the file at path as JSON lines flushed to disk
+func NewFileSink(path string) (FileSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{writerSink: writerSink{w: f}, f: f, path: path}, nil
+}
+
+// Name identifies the sink in the outbox
+func (s *fileSink) Name() string {
+	return "file:" + s.path
+}
+
+// Deliver appends the event and flushes the file
+func (s *fileSink) Deliver(e Event) error {
+	if err := s.writerSink.Deliver(e); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// Close closes the file
+func (s *fileSink) Close() error {
+	return s.f.Close()
+}
+
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink creates a sink posting every event as JSON to url
+// Responses with a status other than 2xx are delivery failures
+func NewWebhookSink(url string, timeout time.Duration) Sink {
+	return &webhookSink{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// Name identifies the sink in the outbox
+func (s *webhookSink) Name() string {
+	return "webhook:" + s.url
+}
+
+// Deliver posts the event
+func (s *webhookSink) Deliver(e Event) error {
+	j, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(j))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Id", e.ID)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Webhook %s answered %s", s.url, resp.Status)
+	}
+	return nil
+}
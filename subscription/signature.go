@@ -0,0 +1,60 @@
+package subscription
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader is the header with the signature of the payloads posted to the subscriptions
+const SignatureHeader = "X-Signature"
+
+// Sign returns the signature of a payload sent at the given Unix time: t=<time>,v1=<hex HMAC-SHA256 of "<time>.<payload>">
+// Signing the time lets consumers reject old payloads replayed by an attacker
+func Sign(secret string, t int64, payload []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", t, hex.EncodeToString(mac(secret, t, payload)))
+}
+
+// Verify tells if the signature is valid for the payload and was made within tolerance from now (0 skips the check)
+func Verify(secret, signature string, payload []byte, tolerance time.Duration) bool {
+	var t int64
+	var sum []byte
+	for _, part := range strings.Split(signature, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return false
+		}
+		switch kv[0] {
+		case "t":
+			n, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil {
+				return false
+			}
+			t = n
+		case "v1":
+			b, err := hex.DecodeString(kv[1])
+			if err != nil {
+				return false
+			}
+			sum = b
+		}
+	}
+	if t == 0 || sum == nil {
+		return false
+	}
+	if age := time.Since(time.Unix(t, 0)); tolerance > 0 && (age > tolerance || age < -tolerance) {
+		return false
+	}
+	return hmac.Equal(sum, mac(secret, t, payload))
+}
+
+func mac(secret string, t int64, payload []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(h, "%d.", t)
+	h.Write(payload)
+	return h.Sum(nil)
+}
@@ -0,0 +1,410 @@
+package subscription
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"shopping-cart-kata/publisher"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrSubscriptionNotFound when the subscription is not present
+var ErrSubscriptionNotFound = errors.New("Unable to find the subscription")
+
+// ErrInvalidCallbackURL when the callback URL is not an absolute http or https URL
+var ErrInvalidCallbackURL = errors.New("Callback URL must be an absolute http or https URL")
+
+// ErrForbiddenCallback when the callback URL targets the host of the service or its internal network
+var ErrForbiddenCallback = errors.New("Callback URL must not target loopback, link-local, private or unspecified addresses")
+
+// ErrNoEventTypes when a subscription does not list any event type
+var ErrNoEventTypes = errors.New("At least one event type is required")
+
+// ErrUnknownEventType when a subscription lists an event type that does not exist
+var ErrUnknownEventType = errors.New("Unknown event type")
+
+// Subscription is a callback URL receiving the events of the given types
+// The secret signs the payloads and is shown only when the subscription is created
+type Subscription struct {
+	ID        string
+	URL       string
+	Types     []publisher.Type
+	Secret    string
+	CreatedAt time.Time
+}
+
+// DeadLetter is an event that could not be delivered to a subscription
+type DeadLetter struct {
+	Event    publisher.Event
+	Attempts int
+	Error    string
+	FailedAt time.Time
+}
+
+// Options tunes the delivery to the subscriptions
+// A delivery is attempted MaxAttempts times waiting from RetryMin doubling up to RetryMax
+// Only the last MaxDeadLetters dead letters of a subscription are kept
+// Callbacks to loopback, link-local, private and unspecified addresses are refused unless AllowInternal
+type Options struct {
+	MaxAttempts    int
+	RetryMin       time.Duration
+	RetryMax       time.Duration
+	Timeout        time.Duration
+	QueueSize      int
+	MaxDeadLetters int
+	AllowInternal  bool
+}
+
+// DefaultOptions are the options used by NewManager when not given
+var DefaultOptions = Options{MaxAttempts: 6, RetryMin: 500 * time.Millisecond, RetryMax: time.Minute, Timeout: 5 * time.Second, QueueSize: 1024, MaxDeadLetters: 1000}
+
+// Manager keeps the subscriptions and, as a publisher sink, delivers them the events of the subscribed types
+type Manager interface {
+	publisher.Sink
+	publisher.Filter
+	Create(callbackURL string, types []publisher.Type) (Subscription, error)
+	Get(id string) (Subscription, bool)
+	List() []Subscription
+	Delete(id string) error
+	DeadLetters(id string) ([]DeadLetter, error)
+	Replay(id string) (int, error)
+	Close() error
+}
+
+type subscriber struct {
+	sub   Subscription
+	types map[publisher.Type]bool
+	queue chan publisher.Event
+	stop  chan struct{}
+	dead  []DeadLetter
+}
+
+type manager struct {
+	sync.RWMutex
+	opts   Options
+	client *http.Client
+	subs   map[string]*subscriber
+	closed bool
+}
+
+// NewManager creates a manager of subscriptions kept in memory
+func NewManager(opts Options) Manager {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultOptions.MaxAttempts
+	}
+	if opts.RetryMin <= 0 {
+		opts.RetryMin = DefaultOptions.RetryMin
+	}
+	if opts.RetryMax < opts.RetryMin {
+		opts.RetryMax = opts.RetryMin
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultOptions.Timeout
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultOptions.QueueSize
+	}
+	if opts.MaxDeadLetters <= 0 {
+		opts.MaxDeadLetters = DefaultOptions.MaxDeadLetters
+	}
+	return &manager{opts: opts, client: newClient(opts), subs: make(map[string]*subscriber)}
+}
+
+// newClient creates the HTTP client of the callbacks checking, unless allowed, the address of every connection
+// so that host names resolving to internal addresses and redirects to them are refused too
+func newClient(opts Options) *http.Client {
+	if opts.AllowInternal {
+		return &http.Client{Timeout: opts.Timeout}
+	}
+	d := &net.Dialer{Timeout: opts.Timeout, Control: func(network string, address string, c syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || internalIP(ip) {
+			return ErrForbiddenCallback
+		}
+		return nil
+	}}
+	return &http.Client{Timeout: opts.Timeout, Transport: &http.Transport{DialContext: d.DialContext}}
+}
+
+// forbiddenHost tells if the host of a callback URL is a local name or an internal address
+func forbiddenHost(host string) bool {
+	host = strings.ToLower(host)
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && internalIP(ip)
+}
+
+func internalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast()
+}
+
+// Create registers a subscription delivering the events of the given types to the callback URL
+func (m *manager) Create(callbackURL string, types []publisher.Type) (Subscription, error) {
+	u, err := url.Parse(callbackURL)
+	if err != nil || !u.IsAbs() || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return Subscription{}, ErrInvalidCallbackURL
+	}
+	if !m.opts.AllowInternal && forbiddenHost(u.Hostname()) {
+		return Subscription{}, ErrForbiddenCallback
+	}
+	if len(types) == 0 {
+		return Subscription{}, ErrNoEventTypes
+	}
+	set := make(map[publisher.Type]bool)
+	for _, t := range types {
+		if !knownType(t) {
+			return Subscription{}, fmt.Errorf("%v %q", ErrUnknownEventType, t)
+		}
+		set[t] = true
+	}
+	sub := Subscription{ID: randomHex(16), URL: callbackURL, Secret: randomHex(32), CreatedAt: time.Now()}
+	for _, t := range publisher.Types {
+		if set[t] {
+			sub.Types = append(sub.Types, t)
+		}
+	}
+	s := &subscriber{sub: sub, types: set, queue: make(chan publisher.Event, m.opts.QueueSize), stop: make(chan struct{})}
+	m.Lock()
+	defer m.Unlock()
+	if m.closed {
+		return Subscription{}, publisher.ErrClosed
+	}
+	m.subs[sub.ID] = s
+	go m.run(s)
+	return sub, nil
+}
+
+// Get retrieves a subscription
+func (m *manager) Get(id string) (Subscription, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	s, ok := m.subs[id]
+	if !ok {
+		return Subscription{}, false
+	}
+	return s.sub, true
+}
+
+// List retrieves the subscriptions by creation time
+func (m *manager) List() []Subscription {
+	m.RLock()
+	defer m.RUnlock()
+	subs := make([]Subscription, 0, len(m.subs))
+	for _, s := range m.subs {
+		subs = append(subs, s.sub)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].CreatedAt.Before(subs[j].CreatedAt) })
+	return subs
+}
+
+// Delete removes a subscription discarding its pending events and dead letters
+func (m *manager) Delete(id string) error {
+	m.Lock()
+	defer m.Unlock()
+	s, ok := m.subs[id]
+	if !ok {
+		return ErrSubscriptionNotFound
+	}
+	delete(m.subs, id)
+	close(s.stop)
+	return nil
+}
+
+// DeadLetters retrieves the events that could not be delivered to a subscription
+func (m *manager) DeadLetters(id string) ([]DeadLetter, error) {
+	m.RLock()
+	defer m.RUnlock()
+	s, ok := m.subs[id]
+	if !ok {
+		return nil, ErrSubscriptionNotFound
+	}
+	return append([]DeadLetter(nil), s.dead...), nil
+}
+
+// Replay queues again the dead letters of a subscription returning how many were queued
+// Events failing again go back to the dead letters
+func (m *manager) Replay(id string) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	s, ok := m.subs[id]
+	if !ok {
+		return 0, ErrSubscriptionNotFound
+	}
+	n := 0
+	for n < len(s.dead) && m.enqueue(s, s.dead[n].Event) {
+		n++
+	}
+	s.dead = s.dead[n:]
+	return n, nil
+}
+
+// Deliver queues the event for the subscriptions to its type
+// It never fails: an event that cannot be queued becomes a dead letter
+func (m *manager) Deliver(e publisher.Event) error {
+	m.Lock()
+	defer m.Unlock()
+	for _, s := range m.subs {
+		if s.types[e.Type] && !m.enqueue(s, e) {
+			m.addDeadLetter(s, DeadLetter{Event: e, Error: "Delivery queue full", FailedAt: time.Now()})
+		}
+	}
+	return nil
+}
+
+// Name identifies the subscriptions as a sink in the outbox of the publisher
+func (m *manager) Name() string {
+	return "subscriptions"
+}
+
+// Wants tells if any subscription is to the events of the type
+func (m *manager) Wants(t publisher.Type) bool {
+	m.RLock()
+	defer m.RUnlock()
+	for _, s := range m.subs {
+		if s.types[t] {
+			return true
+		}
+	}
+	return false
+}
+
+// Close stops delivering events
+func (m *manager) Close() error {
+	m.Lock()
+	defer m.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	for id, s := range m.subs {
+		delete(m.subs, id)
+		close(s.stop)
+	}
+	return nil
+}
+
+func (m *manager) enqueue(s *subscriber, e publisher.Event) bool {
+	select {
+	case s.queue <- e:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *manager) run(s *subscriber) {
+	for {
+		select {
+		case e := <-s.queue:
+			m.deliver(s, e)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// deliver attempts the delivery with exponential backoff moving the event to the dead letters when all the attempts fail
+func (m *manager) deliver(s *subscriber, e publisher.Event) {
+	wait := m.opts.RetryMin
+	var err error
+	attempts := 0
+	for {
+		attempts++
+		if err = m.post(s.sub, e); err == nil {
+			return
+		}
+		if attempts == m.opts.MaxAttempts {
+			break
+		}
+		if !sleep(wait, s.stop) {
+			return
+		}
+		wait *= 2
+		if wait > m.opts.RetryMax {
+			wait = m.opts.RetryMax
+		}
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.addDeadLetter(s, DeadLetter{Event: e, Attempts: attempts, Error: err.Error(), FailedAt: time.Now()})
+}
+
+// addDeadLetter appends a dead letter dropping the oldest ones beyond the maximum
+func (m *manager) addDeadLetter(s *subscriber, dl DeadLetter) {
+	s.dead = append(s.dead, dl)
+	if over := len(s.dead) - m.opts.MaxDeadLetters; over > 0 {
+		s.dead = append([]DeadLetter(nil), s.dead[over:]...)
+	}
+}
+
+func (m *manager) post(sub Subscription, e publisher.Event) error {
+	j, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(j))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Id", e.ID)
+	req.Header.Set("X-Event-Type", string(e.Type))
+	req.Header.Set("X-Subscription-Id", sub.ID)
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, time.Now().Unix(), j))
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Callback answered %s", resp.Status)
+	}
+	return nil
+}
+
+// sleep waits for the duration returning false if stopped before
+func sleep(d time.Duration, stop <-chan struct{}) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+func knownType(t publisher.Type) bool {
+	for _, k := range publisher.Types {
+		if t == k {
+			return true
+		}
+	}
+	return false
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,191 @@
+package subscription
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"shopping-cart-kata/publisher"
+	"sync"
+	"testing"
+	"time"
+)
+
+var fastRetry = Options{MaxAttempts: 3, RetryMin: time.Millisecond, RetryMax: 2 * time.Millisecond, AllowInternal: true}
+
+type callback struct {
+	sync.Mutex
+	secret   string
+	failing  bool
+	received []string
+	invalid  int
+}
+
+func (c *callback) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	defer c.Unlock()
+	if c.failing {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if !Verify(c.secret, r.Header.Get(SignatureHeader), body, time.Minute) {
+		c.invalid++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	c.received = append(c.received, r.Header.Get("X-Event-Id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *callback) setFailing(failing bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.failing = failing
+}
+
+func (c *callback) count() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.received)
+}
+
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 500; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return cond()
+}
+
+func TestSignature(t *testing.T) {
+	payload := []byte(`{"type":"cart.created"}`)
+	sig := Sign("secret", time.Now().Unix(), payload)
+	if !Verify("secret", sig, payload, time.Minute) {
+		t.Errorf("Valid signature %s not verified", sig)
+	}
+	if Verify("other", sig, payload, time.Minute) || Verify("secret", sig, []byte(`{}`), time.Minute) {
+		t.Errorf("Signature %s verified with another secret or payload", sig)
+	}
+	old := Sign("secret", time.Now().Add(-time.Hour).Unix(), payload)
+	if Verify("secret", old, payload, time.Minute) || !Verify("secret", old, payload, 0) {
+		t.Errorf("Old signature %s not rejected only when checking its time", old)
+	}
+}
+
+func TestCreateValidation(t *testing.T) {
+	m := NewManager(fastRetry)
+	defer m.Close()
+	if _, err := m.Create("/relative", []publisher.Type{publisher.CartCreated}); err != ErrInvalidCallbackURL {
+		t.Errorf("Relative callback URL: %v instead of %v", err, ErrInvalidCallbackURL)
+	}
+	if _, err := m.Create("http://127.0.0.1/cb", nil); err != ErrNoEventTypes {
+		t.Errorf("No event types: %v instead of %v", err, ErrNoEventTypes)
+	}
+	if _, err := m.Create("http://127.0.0.1/cb", []publisher.Type{"cart.unknown"}); err == nil {
+		t.Errorf("Unknown event type accepted")
+	}
+	if len(m.List()) != 0 {
+		t.Errorf("Invalid subscriptions created %v", m.List())
+	}
+}
+
+func TestForbiddenCallbacks(t *testing.T) {
+	m := NewManager(DefaultOptions)
+	defer m.Close()
+	urls := []string{"http://127.0.0.1/cb", "http://localhost:8080/cb", "http://169.254.169.254/latest", "http://[::1]/cb", "https://10.0.0.1/cb", "http://0.0.0.0/cb"}
+	for _, u := range urls {
+		if _, err := m.Create(u, []publisher.Type{publisher.CartCreated}); err != ErrForbiddenCallback {
+			t.Errorf("Callback URL %s: %v instead of %v", u, err, ErrForbiddenCallback)
+		}
+	}
+	if _, err := m.Create("https://example.com/cb", []publisher.Type{publisher.CartCreated}); err != nil {
+		t.Errorf("Error creating a subscription to a public callback %v", err)
+	}
+	srv := httptest.NewServer(&callback{})
+	defer srv.Close()
+	if _, err := newClient(DefaultOptions).Get(srv.URL); !errors.Is(err, ErrForbiddenCallback) {
+		t.Errorf("Connecting to a loopback callback: %v instead of %v", err, ErrForbiddenCallback)
+	}
+}
+
+func TestDeadLettersAreCapped(t *testing.T) {
+	m := NewManager(Options{MaxDeadLetters: 2}).(*manager)
+	s := &subscriber{}
+	for _, id := range []string{"1", "2", "3"} {
+		m.addDeadLetter(s, DeadLetter{Event: publisher.Event{ID: id}})
+	}
+	if len(s.dead) != 2 || s.dead[0].Event.ID != "2" || s.dead[1].Event.ID != "3" {
+		t.Errorf("Dead letters %v instead of the last two", s.dead)
+	}
+}
+
+func TestWants(t *testing.T) {
+	m := NewManager(fastRetry)
+	defer m.Close()
+	m.Create("http://127.0.0.1/cb", []publisher.Type{publisher.CartCreated})
+	if !m.Wants(publisher.CartCreated) || m.Wants(publisher.PromotionApplied) {
+		t.Errorf("Wants not telling only the subscribed event types")
+	}
+}
+
+func TestDeliverSignedEventsOfSubscribedTypes(t *testing.T) {
+	cb := &callback{}
+	srv := httptest.NewServer(cb)
+	defer srv.Close()
+	m := NewManager(fastRetry)
+	defer m.Close()
+	s, err := m.Create(srv.URL, []publisher.Type{publisher.CartDeleted, publisher.CartCreated})
+	if err != nil {
+		t.Fatalf("Error creating the subscription %v", err)
+	}
+	cb.secret = s.Secret
+	m.Deliver(publisher.Event{ID: "1", Type: publisher.CartCreated, CartID: 1})
+	m.Deliver(publisher.Event{ID: "2", Type: publisher.ArticleAdded, CartID: 1})
+	m.Deliver(publisher.Event{ID: "3", Type: publisher.CartDeleted, CartID: 1})
+	if !waitFor(func() bool { return cb.count() == 2 }) {
+		t.Fatalf("Callback received %v instead of the events of the subscribed types", cb.received)
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	if cb.received[0] != "1" || cb.received[1] != "3" || cb.invalid != 0 {
+		t.Errorf("Callback received %v with %d invalid signatures", cb.received, cb.invalid)
+	}
+}
+
+func TestDeadLettersAndReplay(t *testing.T) {
+	cb := &callback{failing: true}
+	srv := httptest.NewServer(cb)
+	defer srv.Close()
+	m := NewManager(fastRetry)
+	defer m.Close()
+	s, _ := m.Create(srv.URL, []publisher.Type{publisher.ArticleAdded})
+	cb.secret = s.Secret
+	m.Deliver(publisher.Event{ID: "1", Type: publisher.ArticleAdded, CartID: 1, ArticleID: "MUG", Quantity: 1})
+	var dls []DeadLetter
+	waitFor(func() bool {
+		dls, _ = m.DeadLetters(s.ID)
+		return len(dls) == 1
+	})
+	if len(dls) != 1 || dls[0].Attempts != fastRetry.MaxAttempts || dls[0].Event.ID != "1" {
+		t.Fatalf("Dead letters %v instead of the event failing %d times", dls, fastRetry.MaxAttempts)
+	}
+	cb.setFailing(false)
+	if n, err := m.Replay(s.ID); n != 1 || err != nil {
+		t.Fatalf("Replayed %d events with error %v instead of 1", n, err)
+	}
+	if !waitFor(func() bool { return cb.count() == 1 }) {
+		t.Fatalf("Replayed event not delivered")
+	}
+	if dls, _ := m.DeadLetters(s.ID); len(dls) != 0 {
+		t.Errorf("Dead letters %v left after a successful replay", dls)
+	}
+	if err := m.Delete(s.ID); err != nil {
+		t.Fatalf("Error deleting the subscription %v", err)
+	}
+	if _, err := m.Replay(s.ID); err != ErrSubscriptionNotFound {
+		t.Errorf("Replay of a deleted subscription: %v instead of %v", err, ErrSubscriptionNotFound)
+	}
+}